@@ -0,0 +1,65 @@
+package command
+
+import "go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+
+// commandAliases maps the alternate names that the server accepts for some commands to the canonical names used by
+// the proxy's fixers, policies, quotas, and limits.
+var commandAliases = map[string]string{
+	"buildinfo":     "buildInfo",
+	"collstats":     "collStats",
+	"datasize":      "dataSize",
+	"dbhash":        "dbHash",
+	"dbstats":       "dbStats",
+	"deleteIndexes": "dropIndexes",
+	"findandmodify": "findAndModify",
+	"getlasterror":  "getLastError",
+	"ismaster":      "isMaster",
+	"mapreduce":     "mapReduce",
+}
+
+// CanonicalName returns the canonical name of the command named cmdName. Names that are not aliases are returned
+// unchanged.
+func CanonicalName(cmdName string) string {
+	if canonical, ok := commandAliases[cmdName]; ok {
+		return canonical
+	}
+	return cmdName
+}
+
+// Canonicalize returns cmd with its command name replaced by the canonical name. The command being explained by an
+// explain command is also canonicalized. If no names change, cmd is returned as-is.
+func Canonicalize(cmd bsoncore.Document) bsoncore.Document {
+	canonicalized, _ := canonicalize(cmd)
+	return canonicalized
+}
+
+// canonicalize implements Canonicalize and also reports whether any names were changed.
+func canonicalize(cmd bsoncore.Document) (bsoncore.Document, bool) {
+	elems, err := cmd.Elements()
+	if err != nil || len(elems) == 0 {
+		return cmd, false
+	}
+
+	cmdName := elems[0].Key()
+	canonical := CanonicalName(cmdName)
+	changed := canonical != cmdName
+	val := elems[0].Value()
+	if inner, ok := val.DocumentOK(); ok && canonical == "explain" {
+		var innerChanged bool
+		if inner, innerChanged = canonicalize(inner); innerChanged {
+			val = bsoncore.Value{Type: val.Type, Data: inner}
+			changed = true
+		}
+	}
+	if !changed {
+		return cmd, false
+	}
+
+	idx, dst := bsoncore.AppendDocumentStart(nil)
+	dst = bsoncore.AppendValueElement(dst, canonical, val)
+	for _, elem := range elems[1:] {
+		dst = append(dst, elem...)
+	}
+	dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst, true
+}
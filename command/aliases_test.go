@@ -0,0 +1,95 @@
+package command
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+)
+
+func TestCanonicalize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cmd      string
+		expected string
+	}{
+		{"buildinfo", `{"buildinfo": 1, "$db": "admin"}`, `{"buildInfo": 1, "$db": "admin"}`},
+		{"collstats", `{"collstats": "coll", "$db": "db"}`, `{"collStats": "coll", "$db": "db"}`},
+		{"datasize", `{"datasize": "db.coll", "$db": "db"}`, `{"dataSize": "db.coll", "$db": "db"}`},
+		{"dbhash", `{"dbhash": 1, "$db": "db"}`, `{"dbHash": 1, "$db": "db"}`},
+		{"dbstats", `{"dbstats": 1, "$db": "db"}`, `{"dbStats": 1, "$db": "db"}`},
+		{
+			"deleteIndexes",
+			`{"deleteIndexes": "coll", "index": "*", "$db": "db"}`,
+			`{"dropIndexes": "coll", "index": "*", "$db": "db"}`,
+		},
+		{
+			"findandmodify",
+			`{"findandmodify": "coll", "query": {}, "remove": true, "$db": "db"}`,
+			`{"findAndModify": "coll", "query": {}, "remove": true, "$db": "db"}`,
+		},
+		{"getlasterror", `{"getlasterror": 1, "$db": "db"}`, `{"getLastError": 1, "$db": "db"}`},
+		{"ismaster", `{"ismaster": 1, "$db": "admin"}`, `{"isMaster": 1, "$db": "admin"}`},
+		{
+			"mapreduce",
+			`{"mapreduce": "coll", "out": {"replace": "c", "db": "db1"}, "$db": "db"}`,
+			`{"mapReduce": "coll", "out": {"replace": "c", "db": "db1"}, "$db": "db"}`,
+		},
+		{"canonical name", `{"findAndModify": "coll", "$db": "db"}`, `{"findAndModify": "coll", "$db": "db"}`},
+		{"other case", `{"FIND": "coll", "$db": "db"}`, `{"FIND": "coll", "$db": "db"}`},
+		{
+			"explained alias",
+			`{"explain": {"findandmodify": "coll", "remove": true}, "$db": "db"}`,
+			`{"explain": {"findAndModify": "coll", "remove": true}, "$db": "db"}`,
+		},
+		{
+			"alias in other command",
+			`{"find": {"mapreduce": "coll"}, "$db": "db"}`,
+			`{"find": {"mapreduce": "coll"}, "$db": "db"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Canonicalize(testutil.Document(t, tc.cmd))
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(got, expected) {
+				t.Fatalf("expected %s, got %s", expected, got)
+			}
+		})
+	}
+}
+
+// TestCanonicalizedAliasDatabases checks that aliases of commands that reference other databases are fixed and
+// checked the same way as the canonical command once they are canonicalized.
+func TestCanonicalizedAliasDatabases(t *testing.T) {
+	const prefix = "t1_"
+	p := NewParser(ParserOptions{Prefix: prefix})
+
+	testCases := []struct {
+		name     string
+		cmd      string
+		expected []string
+	}{
+		{
+			"mapreduce",
+			`{"mapreduce": "coll", "out": {"replace": "c", "db": "db1"}, "$db": "db"}`,
+			[]string{"t1_db", "t1_db1"},
+		},
+		{"datasize", `{"datasize": "db1.coll", "$db": "db"}`, []string{"t1_db", "t1_db1"}},
+		{
+			"explained mapreduce",
+			`{"explain": {"mapreduce": "coll", "out": {"replace": "c", "db": "db1"}}, "$db": "db"}`,
+			[]string{"t1_db", "t1_db1"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := Canonicalize(testutil.Document(t, tc.cmd))
+			cmdName := cmd.Index(0).Key()
+			fixed, err := p.Parse(FixerName(cmdName, cmd)).FixRequest(cmd)
+			if err != nil {
+				t.Fatalf("error fixing request: %v", err)
+			}
+			assertDatabases(t, ReferencedDatabases(cmdName, fixed), tc.expected)
+		})
+	}
+}
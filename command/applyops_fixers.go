@@ -0,0 +1,52 @@
+package command
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// newApplyOpsEntryFixer creates a ValueFixer for the oplog-format entries in an applyOps request. The prefix is
+// prepended to the ns value of each entry. For command entries, the embedded command in the o value is fixed using
+// that command's request fixer, which also handles nested applyOps commands. No-op entries have an empty ns, which is
+// not changed. Entries with a ui value are rejected because the server applies them to the collection with that UUID
+// regardless of ns, so the prefixed ns would not keep them inside the tenant's databases.
+func (p *Parser) newApplyOpsEntryFixer() ValueFixerFunc {
+	nsFixer := ValueFixerFunc(func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		if ns, ok := val.StringValueOK(); ok && ns == "" {
+			return bsoncore.AppendValueElement(dst, string(key), val), nil
+		}
+		return p.addNSPrefix.fixValue(val, key, dst)
+	})
+	crudEntryFixer := DocumentFixer{
+		"ns": nsFixer,
+	}
+	commandEntryFixer := DocumentFixer{
+		"ns": nsFixer,
+		"o": ValueFixerFunc(func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+			cmd, ok := val.DocumentOK()
+			if !ok {
+				return nil, fmt.Errorf("expected command entry o value to be document, got %s", val.Type)
+			}
+			elem, err := cmd.IndexErr(0)
+			if err != nil {
+				return nil, fmt.Errorf("command entry o value must contain a command: %w", err)
+			}
+			return p.Parse(elem.Key()).requestFixer.fixValue(val, key, dst)
+		}),
+	}
+
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		entry, ok := val.DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("expected applyOps entry to be document, got %s", val.Type)
+		}
+		if _, err := entry.LookupErr("ui"); err == nil {
+			return nil, fmt.Errorf("applyOps entries cannot specify ui")
+		}
+		if op, _ := entry.Lookup("op").StringValueOK(); op == "c" {
+			return commandEntryFixer.fixValue(val, key, dst)
+		}
+		return crudEntryFixer.fixValue(val, key, dst)
+	}
+}
@@ -0,0 +1,71 @@
+package command
+
+import "testing"
+
+func TestApplyOpsFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	runRequestFixerTests(t, p, []fixerTestCase{
+		{
+			name: "CRUD entries",
+			input: `{"applyOps": [{"op": "i", "ns": "db.coll", "o": {"_id": 1}}, ` +
+				`{"op": "u", "ns": "other.coll", "o2": {"_id": 1}, "o": {"$set": {"x": 1}}}, ` +
+				`{"op": "n", "ns": "", "o": {"msg": "noop"}}], "$db": "admin"}`,
+			expected: `{"applyOps": [{"op": "i", "ns": "t1_db.coll", "o": {"_id": 1}}, ` +
+				`{"op": "u", "ns": "t1_other.coll", "o2": {"_id": 1}, "o": {"$set": {"x": 1}}}, ` +
+				`{"op": "n", "ns": "", "o": {"msg": "noop"}}], "$db": "t1_admin"}`,
+		},
+		{
+			name:     "create command",
+			input:    `{"applyOps": [{"op": "c", "ns": "db.$cmd", "o": {"create": "coll"}}], "$db": "admin"}`,
+			expected: `{"applyOps": [{"op": "c", "ns": "t1_db.$cmd", "o": {"create": "coll"}}], "$db": "t1_admin"}`,
+		},
+		{
+			name: "renameCollection command",
+			input: `{"applyOps": [{"op": "c", "ns": "db.$cmd", ` +
+				`"o": {"renameCollection": "db.a", "to": "other.b"}}], "$db": "admin"}`,
+			expected: `{"applyOps": [{"op": "c", "ns": "t1_db.$cmd", ` +
+				`"o": {"renameCollection": "t1_db.a", "to": "t1_other.b"}}], "$db": "t1_admin"}`,
+		},
+		{
+			name: "nested applyOps",
+			input: `{"applyOps": [{"op": "c", "ns": "admin.$cmd", ` +
+				`"o": {"applyOps": [{"op": "d", "ns": "db.coll", "o": {"_id": 1}}]}}], "$db": "admin"}`,
+			expected: `{"applyOps": [{"op": "c", "ns": "t1_admin.$cmd", ` +
+				`"o": {"applyOps": [{"op": "d", "ns": "t1_db.coll", "o": {"_id": 1}}]}}], "$db": "t1_admin"}`,
+		},
+		{
+			name: "preCondition",
+			input: `{"applyOps": [], "preCondition": [{"ns": "db.coll", "q": {"_id": 1}, "res": {"x": 1}}], ` +
+				`"$db": "admin"}`,
+			expected: `{"applyOps": [], "preCondition": [{"ns": "t1_db.coll", "q": {"_id": 1}, "res": {"x": 1}}], ` +
+				`"$db": "t1_admin"}`,
+		},
+		{
+			name: "ui",
+			input: `{"applyOps": [{"op": "i", "ns": "db.coll", "ui": {"$binary": {"base64": "AAAAAAAAAAAAAAAAAAAAAA==", ` +
+				`"subType": "04"}}, "o": {"_id": 1}}], "$db": "admin"}`,
+			errMsg: "applyOps entries cannot specify ui",
+		},
+		{
+			name:   "nested ui",
+			input:  `{"applyOps": [{"op": "c", "ns": "admin.$cmd", "o": {"applyOps": [{"op": "n", "ui": 1}]}}], "$db": "admin"}`,
+			errMsg: "applyOps entries cannot specify ui",
+		},
+		{
+			name:   "non-string ns",
+			input:  `{"applyOps": [{"op": "i", "ns": 1, "o": {"_id": 1}}], "$db": "admin"}`,
+			errMsg: "expected namespace value to be string",
+		},
+		{
+			name:   "non-document entry",
+			input:  `{"applyOps": ["db.coll"], "$db": "admin"}`,
+			errMsg: "expected applyOps entry to be document",
+		},
+		{
+			name:   "empty command entry",
+			input:  `{"applyOps": [{"op": "c", "ns": "db.$cmd", "o": {}}], "$db": "admin"}`,
+			errMsg: "command entry o value must contain a command",
+		},
+	})
+}
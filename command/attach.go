@@ -1,25 +1,388 @@
 package command
 
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
 func attachFixers(p *Parser) {
+	attachAdminFixers(p)
+
 	// listCollections: cursor subdocument where each batch document is in the form
 	// {name: <collName>, ..., idIndex: {ns: <coll namespace>, ...}}
 	// The idIndex.ns value in each batch document needs to be fixed to remove the DB prefix.
+	// For views, the options.pipeline value in each batch document also needs to be fixed.
 	listCollsBatchFixer := DocumentFixer{
 		"idIndex": DocumentFixer{
-			"ns": ValueFixerFunc(removeDBPrefixValueFixer),
+			"ns": p.removeDBPrefix,
+		},
+		"options": DocumentFixer{
+			"pipeline": newPipelineValueFixer(p.removeDBPrefix),
 		},
 	}
-	listCollsResponseFixer := newDefaultCursorResponseFixer(listCollsBatchFixer)
+	listCollsResponseFixer := p.newDefaultCursorResponseFixer(listCollsBatchFixer)
 	p.register("listCollections", nil, listCollsResponseFixer)
 
-	// listIndexes: each batch document has an ns value.
+	// find: simple cursor subdocument. If DBRefs are being fixed, each batch document is also fixed.
+	findResponseFixer := p.newDefaultCursorResponseFixer(p.userDocumentResponseFixer())
+	p.register("find", nil, findResponseFixer)
+
+	attachPipelineFixers(p)
+	attachUserFixers(p)
+	attachExplainFixers(p)
+	attachOplogFixers(p)
+	attachCurrentOpFixers(p)
+	attachStatsFixers(p)
+	attachIndexFixers(p)
+	if p.opts.FixDBRefs {
+		attachDBRefFixers(p)
+	}
+
+	// applyOps: each entry has a full namespace and command entries embed a command that needs to be fixed. Each
+	// preCondition also has a full namespace.
+	applyOpsRequestFixer := DocumentFixer{
+		"applyOps": newArrayValueFixer(p.newApplyOpsEntryFixer()),
+		"preCondition": newArrayValueFixer(DocumentFixer{
+			"ns": p.addNSPrefix,
+		}),
+	}
+	p.register("applyOps", applyOpsRequestFixer, nil)
+
+	if p.opts.Sessions != nil {
+		attachSessionFixers(p, p.opts.Sessions)
+	}
+}
+
+func attachAdminFixers(p *Parser) {
+	// Commands that run against the server's admin database need to be registered so they don't use the default
+	// fixers, which would send them to the tenant's admin database instead.
+	for cmdName := range adminCommands {
+		p.register(cmdName, nil, nil)
+	}
+
+	// renameCollection: the command value and the to value are both full namespaces.
+	renameCollectionRequestFixer := DocumentFixer{
+		"renameCollection": p.addNSPrefix,
+		"to":               p.addNSPrefix,
+	}
+	p.register("renameCollection", renameCollectionRequestFixer, nil)
+}
+
+func attachPipelineFixers(p *Parser) {
+	pipelineRequestFixer := DocumentFixer{
+		"pipeline": newPipelineValueFixer(p.addDBPrefix),
+	}
+
+	// aggregate: the pipeline can reference other databases and the response is a cursor subdocument.
+	p.register("aggregate", pipelineRequestFixer, p.newDefaultCursorResponseFixer(p.userDocumentResponseFixer()))
+
+	// mapReduce: the out value is a collection name, {inline: 1}, or a document such as {replace: <coll>, db: <db>}.
+	// The result value in responses is a collection name or {db, collection} document. Inline results are not
+	// changed.
+	mapReduceRequestFixer := DocumentFixer{
+		"out": newOptionalDocumentValueFixer(DocumentFixer{
+			"db": p.addDBPrefix,
+		}),
+	}
+	mapReduceResponseFixer := DocumentFixer{
+		"result": newOptionalDocumentValueFixer(DocumentFixer{
+			"db": p.removeDBPrefix,
+		}),
+	}
+	p.register("mapReduce", mapReduceRequestFixer, mapReduceResponseFixer)
+
+	// create, collMod: views are defined by a pipeline, which is fixed in the same way as an aggregate pipeline. The
+	// viewOn value is a collection name in the command's database.
+	p.register("create", pipelineRequestFixer, nil)
+	p.register("collMod", pipelineRequestFixer, nil)
+}
+
+func attachUserFixers(p *Parser) {
+	// Role references are either a role name on the command's database or a {role, db} document. Privileges are in
+	// the form {resource: {db, collection}, actions}.
+	roleRequestFixer := newArrayValueFixer(newOptionalDocumentValueFixer(DocumentFixer{
+		"db": p.addDBPrefix,
+	}))
+	privilegeRequestFixer := newArrayValueFixer(DocumentFixer{
+		"resource": newResourceRequestFixer(p.addDBPrefix),
+	})
+
+	// createUser, updateUser, grantRolesToUser, revokeRolesFromUser, grantRolesToRole, revokeRolesFromRole: roles
+	// array.
+	rolesRequestFixer := DocumentFixer{
+		"roles": roleRequestFixer,
+	}
+	for _, cmdName := range []string{
+		"createUser", "updateUser", "grantRolesToUser", "revokeRolesFromUser", "grantRolesToRole", "revokeRolesFromRole",
+	} {
+		p.register(cmdName, rolesRequestFixer, nil)
+	}
+
+	// createRole, updateRole: roles and privileges arrays.
+	roleDefinitionRequestFixer := DocumentFixer{
+		"roles":      roleRequestFixer,
+		"privileges": privilegeRequestFixer,
+	}
+	p.register("createRole", roleDefinitionRequestFixer, nil)
+	p.register("updateRole", roleDefinitionRequestFixer, nil)
+
+	// grantPrivilegesToRole, revokePrivilegesFromRole: privileges array.
+	privilegesRequestFixer := DocumentFixer{
+		"privileges": privilegeRequestFixer,
+	}
+	p.register("grantPrivilegesToRole", privilegesRequestFixer, nil)
+	p.register("revokePrivilegesFromRole", privilegesRequestFixer, nil)
+
+	// Role and privilege documents in usersInfo and rolesInfo responses.
+	roleResponseFixer := newArrayValueFixer(DocumentFixer{
+		"db": p.removeDBPrefix,
+	})
+	privilegeResponseFixer := newArrayValueFixer(DocumentFixer{
+		"resource": DocumentFixer{
+			"db": p.removeDBPrefix,
+		},
+	})
+
+	// usersInfo: the command value selects users by name or {user, db} document, or all users with {forAllDBs: true},
+	// which is rejected. Each user document in the response has an _id in the form <db>.<user> as well as db, role,
+	// and privilege values.
+	usersInfoRequestFixer := DocumentFixer{
+		"usersInfo": newUserOrRoleSelectorFixer(DocumentFixer{
+			"db":        p.addDBPrefix,
+			"forAllDBs": newForbiddenFlagValueFixer(),
+		}),
+	}
+	usersInfoResponseFixer := DocumentFixer{
+		"users": newArrayValueFixer(DocumentFixer{
+			"_id":                 p.removeDBPrefix,
+			"db":                  p.removeDBPrefix,
+			"roles":               roleResponseFixer,
+			"inheritedRoles":      roleResponseFixer,
+			"inheritedPrivileges": privilegeResponseFixer,
+		}),
+	}
+	p.register("usersInfo", usersInfoRequestFixer, usersInfoResponseFixer)
+
+	// rolesInfo: the command value selects roles by name or {role, db} document. Each role document in the response
+	// has db, role, and privilege values.
+	rolesInfoRequestFixer := DocumentFixer{
+		"rolesInfo": newUserOrRoleSelectorFixer(DocumentFixer{
+			"db": p.addDBPrefix,
+		}),
+	}
+	rolesInfoResponseFixer := DocumentFixer{
+		"roles": newArrayValueFixer(DocumentFixer{
+			"db":                  p.removeDBPrefix,
+			"roles":               roleResponseFixer,
+			"inheritedRoles":      roleResponseFixer,
+			"privileges":          privilegeResponseFixer,
+			"inheritedPrivileges": privilegeResponseFixer,
+		}),
+	}
+	p.register("rolesInfo", rolesInfoRequestFixer, rolesInfoResponseFixer)
+}
+
+func attachExplainFixers(p *Parser) {
+	// explain: the command value is the command being explained, which is fixed using that command's request fixer.
+	// The inner command is looked up when the request is fixed because it may be registered after explain.
+	explainRequestFixer := DocumentFixer{
+		"explain": ValueFixerFunc(func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+			inner, ok := val.DocumentOK()
+			if !ok {
+				return nil, fmt.Errorf("expected explain value to be document, got %s", val.Type)
+			}
+			elem, err := inner.IndexErr(0)
+			if err != nil {
+				return nil, fmt.Errorf("explain value must contain a command: %w", err)
+			}
+			return p.Parse(elem.Key()).requestFixer.fixValue(val, key, dst)
+		}),
+	}
+
+	// The namespace values in query planner output and the $db value of the echoed command can appear at any depth,
+	// including in per-shard sections. The backend's host and port are removed from serverInfo sections.
+	serverInfoFixer := DocumentFixer{
+		"host": omitValueFixer,
+		"port": omitValueFixer,
+	}
+	explainOutputFixer := newDeepValueFixer(DocumentFixer{
+		"namespace":        p.removeDBPrefix,
+		"$db":              p.removeDBPrefix,
+		"serverInfo":       serverInfoFixer,
+		"connectionString": omitValueFixer,
+	})
+	explainResponseFixer := DocumentFixer{
+		"queryPlanner":   explainOutputFixer,
+		"executionStats": explainOutputFixer,
+		"stages":         explainOutputFixer,
+		"shards":         explainOutputFixer,
+		"command":        explainOutputFixer,
+		"serverInfo":     serverInfoFixer,
+	}
+	p.register("explain", explainRequestFixer, explainResponseFixer)
+}
+
+func attachOplogFixers(p *Parser) {
+	// find on local.oplog.rs: the local database is not prefixed and the filter is restricted to the tenant's
+	// entries. Batch documents are oplog entries whose namespaces need the prefix removed and whose session IDs need to
+	// be translated. getMore requests on the cursor use the same FixerSet.
+	p.register(oplogFixerName, DocumentFixer{
+		"$db": newAddDBPrefixValueFixer(p.opts.Prefix, oplogDatabaseNames),
+	}, p.newDefaultCursorResponseFixer(newOplogEntryFixer(p.removeDBPrefix, p.opts.Sessions)))
+	p.setRequestPreparer(oplogFixerName, newOplogFilterPreparer(p.opts.Prefix))
+}
+
+func attachCurrentOpFixers(p *Parser) {
+	opFixer := p.newOperationFixer()
+
+	// currentOp: operations that don't belong to the tenant are removed from the inprog array.
+	currentOpResponseFixer := DocumentFixer{
+		"inprog": p.newInprogValueFixer(opFixer),
+	}
+	p.register("currentOp", nil, currentOpResponseFixer)
+
+	// aggregate with $currentOp: the command must run against the server's admin database. A $match stage is added
+	// to filter out other tenants' operations and each batch document is fixed as an operation document.
+	currentOpAggregateRequestFixer := DocumentFixer{
+		"$db":      p.addAdminDBPrefix,
+		"pipeline": newPipelineValueFixer(p.addDBPrefix),
+	}
+	p.register(currentOpFixerName, currentOpAggregateRequestFixer, p.newDefaultCursorResponseFixer(opFixer))
+	p.setRequestPreparer(currentOpFixerName, newCurrentOpMatchPreparer(p.opts.Prefix))
+}
+
+func attachStatsFixers(p *Parser) {
+	// dbStats: the response has a db value. On sharded clusters, the raw value has a section per shard.
+	p.register("dbStats", nil, DocumentFixer{
+		"db": p.removeDBPrefix,
+		"raw": newDeepValueFixer(DocumentFixer{
+			"db": p.removeDBPrefix,
+		}),
+	})
+
+	// collStats: the response has an ns value. On sharded clusters, the shards value has a section per shard.
+	p.register("collStats", nil, DocumentFixer{
+		"ns": p.removeDBPrefix,
+		"shards": newDeepValueFixer(DocumentFixer{
+			"ns": p.removeDBPrefix,
+		}),
+	})
+
+	// aggregate with $collStats: each batch document has an ns value.
+	p.register(collStatsFixerName, DocumentFixer{
+		"pipeline": newPipelineValueFixer(p.addDBPrefix),
+	}, p.newDefaultCursorResponseFixer(DocumentFixer{
+		"ns": p.removeDBPrefix,
+	}))
+
+	// dataSize: the command value is a full namespace.
+	p.register("dataSize", DocumentFixer{
+		"dataSize": p.addNSPrefix,
+	}, nil)
+
+	// validate: the response has an ns value.
+	p.register("validate", nil, DocumentFixer{
+		"ns": p.removeDBPrefix,
+	})
+}
+
+func attachIndexFixers(p *Parser) {
+	// Index command errors such as IndexKeySpecsConflict include full namespaces in the error message. On sharded
+	// clusters, the raw value has a section per shard, each of which can have its own error message.
+	errmsgFixer := newErrmsgValueFixer(p.opts.Prefix)
+	rawFixer := newDeepValueFixer(DocumentFixer{
+		"errmsg": errmsgFixer,
+	})
+
+	// listIndexes: each batch document has an ns value on older servers.
 	listIndexesBatchFixer := DocumentFixer{
-		"ns": ValueFixerFunc(removeDBPrefixValueFixer),
+		"ns": p.removeDBPrefix,
 	}
-	listIndexesResponseFixer := newDefaultCursorResponseFixer(listIndexesBatchFixer)
+	listIndexesResponseFixer := p.newDefaultCursorResponseFixer(listIndexesBatchFixer)
+	listIndexesResponseFixer["errmsg"] = errmsgFixer
 	p.register("listIndexes", nil, listIndexesResponseFixer)
 
-	// find: simple cursor subdocument.
-	findResponseFixer := newDefaultCursorResponseFixer(nil)
-	p.register("find", nil, findResponseFixer)
+	// createIndexes: each index spec can have an ns value, which older servers require. The response has
+	// numIndexesBefore, numIndexesAfter, and commitQuorum values, none of which need to be fixed.
+	createIndexesRequestFixer := DocumentFixer{
+		"indexes": newArrayValueFixer(DocumentFixer{
+			"ns": p.addNSPrefix,
+		}),
+	}
+	indexResponseFixer := DocumentFixer{
+		"errmsg": errmsgFixer,
+		"raw":    rawFixer,
+	}
+	p.register("createIndexes", createIndexesRequestFixer, indexResponseFixer)
+
+	// dropIndexes, setIndexCommitQuorum: only error messages need to be fixed.
+	p.register("dropIndexes", nil, indexResponseFixer)
+	p.register("setIndexCommitQuorum", nil, indexResponseFixer)
+}
+
+func attachDBRefFixers(p *Parser) {
+	// insert: each document can contain DBRefs. The documents are in the request body or in a document sequence.
+	p.register("insert", DocumentFixer{
+		"documents": newArrayValueFixer(p.addDBRefPrefix),
+	}, nil)
+	p.setSequenceFixer("insert", "documents", p.addDBRefPrefix)
+
+	// update: each update statement is in the form {q: <filter>, u: <update>, ...}. The filter can match DBRefs and the
+	// u value can be a replacement document, an update operator document, or a pipeline. The statements are in the
+	// request body or in a document sequence.
+	updateStatementFixer := DocumentFixer{
+		"q": p.addDBRefPrefix,
+		"u": p.addDBRefPrefix,
+	}
+	p.register("update", DocumentFixer{
+		"updates": newArrayValueFixer(updateStatementFixer),
+	}, nil)
+	p.setSequenceFixer("update", "updates", updateStatementFixer)
+
+	// delete: each delete statement is in the form {q: <filter>, limit: <limit>}. The statements are in the request body
+	// or in a document sequence.
+	deleteStatementFixer := DocumentFixer{
+		"q": p.addDBRefPrefix,
+	}
+	p.register("delete", DocumentFixer{
+		"deletes": newArrayValueFixer(deleteStatementFixer),
+	}, nil)
+	p.setSequenceFixer("delete", "deletes", deleteStatementFixer)
+
+	// findAndModify: the query and update values are fixed like an update statement's q and u values and the returned
+	// document is fixed like a batch document.
+	p.register("findAndModify", DocumentFixer{
+		"query":  p.addDBRefPrefix,
+		"update": p.addDBRefPrefix,
+	}, DocumentFixer{
+		"value": p.removeDBRefPrefix,
+	})
+
+	// find, count, distinct: the filter can match DBRefs. find is already registered with a response fixer for its
+	// batches.
+	p.fixers["find"].requestFixer["filter"] = p.addDBRefPrefix
+	p.register("count", DocumentFixer{
+		"query": p.addDBRefPrefix,
+	}, nil)
+	p.register("distinct", DocumentFixer{
+		"query": p.addDBRefPrefix,
+	}, nil)
+}
+
+func attachSessionFixers(p *Parser, sessions SessionMapper) {
+	// endSessions, refreshSessions, killSessions: the command value is an array of lsid documents. killSessions runs
+	// against the server's admin database and kills every session of the proxy's user if the array is empty, which
+	// would kill the sessions of all tenants, so an empty array is rejected.
+	lsidArrayFixer := newArrayValueFixer(newLsidRequestFixer(sessions))
+	for _, cmdName := range []string{"endSessions", "refreshSessions"} {
+		p.register(cmdName, DocumentFixer{cmdName: lsidArrayFixer}, nil)
+	}
+	p.register("killSessions", DocumentFixer{"killSessions": newNonEmptyArrayValueFixer(lsidArrayFixer)}, nil)
+
+	// startSession: the response contains the new lsid document under the id key.
+	startSessionResponseFixer := DocumentFixer{
+		"id": newLsidResponseFixer(sessions),
+	}
+	p.register("startSession", nil, startSessionResponseFixer)
 }
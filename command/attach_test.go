@@ -0,0 +1,441 @@
+package command
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+)
+
+const testPrefix = "t1_"
+
+// fixerTestCase is a document to fix and the expected result. If errMsg is non-empty, fixing the document is expected
+// to fail with an error containing errMsg.
+type fixerTestCase struct {
+	name     string
+	input    string
+	expected string
+	errMsg   string
+}
+
+// runRequestFixerTests fixes each request with the FixerSet that the proxy would use for it.
+func runRequestFixerTests(t *testing.T, p *Parser, testCases []fixerTestCase) {
+	t.Helper()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := testutil.Document(t, tc.input)
+			fixerSet := p.Parse(FixerName(request.Index(0).Key(), request))
+			got, err := fixerSet.FixRequest(request)
+			assertFixed(t, tc, got, err)
+		})
+	}
+}
+
+// runResponseFixerTests fixes each response with the FixerSet with the given name.
+func runResponseFixerTests(t *testing.T, p *Parser, fixerName string, testCases []fixerTestCase) {
+	t.Helper()
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := p.Parse(fixerName).FixResponse(testutil.Document(t, tc.input))
+			assertFixed(t, tc, got, err)
+		})
+	}
+}
+
+func assertFixed(t *testing.T, tc fixerTestCase, got []byte, err error) {
+	t.Helper()
+
+	if tc.errMsg != "" {
+		if err == nil {
+			t.Fatalf("expected error containing %q, got nil", tc.errMsg)
+		}
+		if !strings.Contains(err.Error(), tc.errMsg) {
+			t.Fatalf("expected error containing %q, got %q", tc.errMsg, err.Error())
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := testutil.Document(t, tc.expected); !bytes.Equal(got, expected) {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestExplainFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	t.Run("request", func(t *testing.T) {
+		runRequestFixerTests(t, p, []fixerTestCase{
+			{
+				name:     "find",
+				input:    `{"explain": {"find": "coll", "filter": {"x": 1}}, "verbosity": "queryPlanner", "$db": "db"}`,
+				expected: `{"explain": {"find": "coll", "filter": {"x": 1}}, "verbosity": "queryPlanner", "$db": "t1_db"}`,
+			},
+			{
+				name: "aggregate",
+				input: `{"explain": {"aggregate": "coll", "pipeline": [{"$lookup": {"from": "other", ` +
+					`"localField": "a", "foreignField": "b", "as": "c"}}, {"$out": {"db": "out", "coll": "c"}}], ` +
+					`"cursor": {}}, "$db": "db"}`,
+				expected: `{"explain": {"aggregate": "coll", "pipeline": [{"$lookup": {"from": "other", ` +
+					`"localField": "a", "foreignField": "b", "as": "c"}}, {"$out": {"db": "t1_out", "coll": "c"}}], ` +
+					`"cursor": {}}, "$db": "t1_db"}`,
+			},
+			{
+				name:     "mapReduce",
+				input:    `{"explain": {"mapReduce": "coll", "out": {"replace": "c", "db": "out"}}, "$db": "db"}`,
+				expected: `{"explain": {"mapReduce": "coll", "out": {"replace": "c", "db": "t1_out"}}, "$db": "t1_db"}`,
+			},
+			{
+				name:   "non-document",
+				input:  `{"explain": "find", "$db": "db"}`,
+				errMsg: "expected explain value to be document, got string",
+			},
+			{
+				name:   "empty",
+				input:  `{"explain": {}, "$db": "db"}`,
+				errMsg: "explain value must contain a command",
+			},
+		})
+	})
+	t.Run("response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "explain", []fixerTestCase{
+			{
+				name: "queryPlanner",
+				input: `{"queryPlanner": {"namespace": "t1_db.coll", "winningPlan": {"stage": "COLLSCAN"}}, ` +
+					`"command": {"find": "coll", "$db": "t1_db"}, ` +
+					`"serverInfo": {"host": "backend", "port": 27017, "version": "4.4.0"}, "ok": 1}`,
+				expected: `{"queryPlanner": {"namespace": "db.coll", "winningPlan": {"stage": "COLLSCAN"}}, ` +
+					`"command": {"find": "coll", "$db": "db"}, "serverInfo": {"version": "4.4.0"}, "ok": 1}`,
+			},
+			{
+				name: "aggregate stages",
+				input: `{"stages": [{"$cursor": {"queryPlanner": {"namespace": "t1_db.coll"}}}, ` +
+					`{"$lookup": {"from": "other"}}], "ok": 1}`,
+				expected: `{"stages": [{"$cursor": {"queryPlanner": {"namespace": "db.coll"}}}, ` +
+					`{"$lookup": {"from": "other"}}], "ok": 1}`,
+			},
+			{
+				name: "sharded",
+				input: `{"queryPlanner": {"winningPlan": {"shards": [{"shardName": "s0", ` +
+					`"connectionString": "rs0/backend:27018", "serverInfo": {"host": "backend", "port": 27018}, ` +
+					`"namespace": "t1_db.coll"}]}}, "ok": 1}`,
+				expected: `{"queryPlanner": {"winningPlan": {"shards": [{"shardName": "s0", ` +
+					`"serverInfo": {}, "namespace": "db.coll"}]}}, "ok": 1}`,
+			},
+			{
+				name: "executionStats",
+				input: `{"executionStats": {"executionStages": {"stage": "SHARD_MERGE", ` +
+					`"shards": [{"shardName": "s0", "executionStages": {"namespace": "t1_db.coll"}}]}}, "ok": 1}`,
+				expected: `{"executionStats": {"executionStages": {"stage": "SHARD_MERGE", ` +
+					`"shards": [{"shardName": "s0", "executionStages": {"namespace": "db.coll"}}]}}, "ok": 1}`,
+			},
+		})
+	})
+}
+
+func TestDBRefFixers(t *testing.T) {
+	const (
+		ref      = `{"$ref": "coll", "$id": 1, "$db": "other"}`
+		fixedRef = `{"$ref": "coll", "$id": 1, "$db": "t1_other"}`
+	)
+	p := NewParser(ParserOptions{Prefix: testPrefix, FixDBRefs: true})
+
+	t.Run("request", func(t *testing.T) {
+		runRequestFixerTests(t, p, []fixerTestCase{
+			{
+				name:  "insert",
+				input: `{"insert": "coll", "documents": [{"a": ` + ref + `, "b": [{"c": ` + ref + `}]}], "$db": "db"}`,
+				expected: `{"insert": "coll", "documents": [{"a": ` + fixedRef + `, "b": [{"c": ` + fixedRef + `}]}], ` +
+					`"$db": "t1_db"}`,
+			},
+			{
+				name: "update",
+				input: `{"update": "coll", "updates": [{"q": {"a": ` + ref + `}, "u": {"$set": {"b": ` + ref + `}}}, ` +
+					`{"q": {}, "u": [{"$set": {"c": ` + ref + `}}]}], "$db": "db"}`,
+				expected: `{"update": "coll", "updates": [{"q": {"a": ` + fixedRef + `}, "u": {"$set": {"b": ` + fixedRef +
+					`}}}, {"q": {}, "u": [{"$set": {"c": ` + fixedRef + `}}]}], "$db": "t1_db"}`,
+			},
+			{
+				name:     "delete",
+				input:    `{"delete": "coll", "deletes": [{"q": {"a": ` + ref + `}, "limit": 1}], "$db": "db"}`,
+				expected: `{"delete": "coll", "deletes": [{"q": {"a": ` + fixedRef + `}, "limit": 1}], "$db": "t1_db"}`,
+			},
+			{
+				name: "findAndModify",
+				input: `{"findAndModify": "coll", "query": {"a": ` + ref + `}, "update": {"b": ` + ref + `}, ` +
+					`"$db": "db"}`,
+				expected: `{"findAndModify": "coll", "query": {"a": ` + fixedRef + `}, "update": {"b": ` + fixedRef + `}, ` +
+					`"$db": "t1_db"}`,
+			},
+			{
+				name:     "find",
+				input:    `{"find": "coll", "filter": {"a": {"$in": [` + ref + `]}}, "$db": "db"}`,
+				expected: `{"find": "coll", "filter": {"a": {"$in": [` + fixedRef + `]}}, "$db": "t1_db"}`,
+			},
+			{
+				name:     "count",
+				input:    `{"count": "coll", "query": {"a": ` + ref + `}, "$db": "db"}`,
+				expected: `{"count": "coll", "query": {"a": ` + fixedRef + `}, "$db": "t1_db"}`,
+			},
+			{
+				name:     "distinct",
+				input:    `{"distinct": "coll", "key": "x", "query": {"a": ` + ref + `}, "$db": "db"}`,
+				expected: `{"distinct": "coll", "key": "x", "query": {"a": ` + fixedRef + `}, "$db": "t1_db"}`,
+			},
+			{
+				name:   "non-string $db",
+				input:  `{"insert": "coll", "documents": [{"a": {"$ref": "coll", "$id": 1, "$db": 1}}], "$db": "db"}`,
+				errMsg: "expected $db value to be string",
+			},
+		})
+	})
+	t.Run("document sequences", func(t *testing.T) {
+		testCases := []struct {
+			fixerTestCase
+			cmdName    string
+			identifier string
+		}{
+			{
+				fixerTestCase{name: "insert", input: `{"a": ` + ref + `}`, expected: `{"a": ` + fixedRef + `}`},
+				"insert", "documents",
+			},
+			{
+				fixerTestCase{
+					name:     "update",
+					input:    `{"q": {"a": ` + ref + `}, "u": {"b": ` + ref + `}, "upsert": true}`,
+					expected: `{"q": {"a": ` + fixedRef + `}, "u": {"b": ` + fixedRef + `}, "upsert": true}`,
+				},
+				"update", "updates",
+			},
+			{
+				fixerTestCase{
+					name:     "delete",
+					input:    `{"q": {"a": ` + ref + `}, "limit": 0}`,
+					expected: `{"q": {"a": ` + fixedRef + `}, "limit": 0}`,
+				},
+				"delete", "deletes",
+			},
+			{
+				fixerTestCase{name: "unknown identifier", input: `{"a": ` + ref + `}`, expected: `{"a": ` + ref + `}`},
+				"insert", "other",
+			},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				fixerSet := p.Parse(tc.cmdName)
+				got, err := fixerSet.FixRequestSequenceDocument(tc.identifier, testutil.Document(t, tc.input))
+				assertFixed(t, tc.fixerTestCase, got, err)
+			})
+		}
+	})
+	t.Run("find response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "find", []fixerTestCase{
+			{
+				name:     "firstBatch",
+				input:    `{"cursor": {"firstBatch": [{"a": ` + fixedRef + `}], "id": 0, "ns": "t1_db.coll"}, "ok": 1}`,
+				expected: `{"cursor": {"firstBatch": [{"a": ` + ref + `}], "id": 0, "ns": "db.coll"}, "ok": 1}`,
+			},
+			{
+				name:     "nextBatch",
+				input:    `{"cursor": {"nextBatch": [{"a": [` + fixedRef + `]}], "id": 0, "ns": "t1_db.coll"}, "ok": 1}`,
+				expected: `{"cursor": {"nextBatch": [{"a": [` + ref + `]}], "id": 0, "ns": "db.coll"}, "ok": 1}`,
+			},
+		})
+	})
+	t.Run("aggregate response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "aggregate", []fixerTestCase{
+			{
+				name:     "firstBatch",
+				input:    `{"cursor": {"firstBatch": [{"a": ` + fixedRef + `}], "id": 0, "ns": "t1_db.coll"}, "ok": 1}`,
+				expected: `{"cursor": {"firstBatch": [{"a": ` + ref + `}], "id": 0, "ns": "db.coll"}, "ok": 1}`,
+			},
+		})
+	})
+	t.Run("findAndModify response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "findAndModify", []fixerTestCase{
+			{
+				name:     "value",
+				input:    `{"lastErrorObject": {"n": 1}, "value": {"a": ` + fixedRef + `}, "ok": 1}`,
+				expected: `{"lastErrorObject": {"n": 1}, "value": {"a": ` + ref + `}, "ok": 1}`,
+			},
+			{
+				name:     "null value",
+				input:    `{"lastErrorObject": {"n": 0}, "value": null, "ok": 1}`,
+				expected: `{"lastErrorObject": {"n": 0}, "value": null, "ok": 1}`,
+			},
+		})
+	})
+	t.Run("disabled", func(t *testing.T) {
+		p := NewParser(ParserOptions{Prefix: testPrefix})
+		runRequestFixerTests(t, p, []fixerTestCase{
+			{
+				name:     "insert",
+				input:    `{"insert": "coll", "documents": [{"a": ` + ref + `}], "$db": "db"}`,
+				expected: `{"insert": "coll", "documents": [{"a": ` + ref + `}], "$db": "t1_db"}`,
+			},
+			{
+				name:     "find",
+				input:    `{"find": "coll", "filter": {"a": ` + ref + `}, "$db": "db"}`,
+				expected: `{"find": "coll", "filter": {"a": ` + ref + `}, "$db": "t1_db"}`,
+			},
+		})
+		runResponseFixerTests(t, p, "find", []fixerTestCase{
+			{
+				name:     "find response",
+				input:    `{"cursor": {"firstBatch": [{"a": ` + fixedRef + `}], "id": 0, "ns": "t1_db.coll"}, "ok": 1}`,
+				expected: `{"cursor": {"firstBatch": [{"a": ` + fixedRef + `}], "id": 0, "ns": "db.coll"}, "ok": 1}`,
+			},
+		})
+	})
+}
+
+func TestStatsFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	t.Run("request", func(t *testing.T) {
+		runRequestFixerTests(t, p, []fixerTestCase{
+			{
+				name:     "dataSize",
+				input:    `{"dataSize": "db.coll", "keyPattern": {"x": 1}, "$db": "db"}`,
+				expected: `{"dataSize": "t1_db.coll", "keyPattern": {"x": 1}, "$db": "t1_db"}`,
+			},
+			{
+				name:   "dataSize non-string",
+				input:  `{"dataSize": 1, "$db": "db"}`,
+				errMsg: "expected namespace value to be string",
+			},
+			{
+				name:     "$collStats",
+				input:    `{"aggregate": "coll", "pipeline": [{"$collStats": {"storageStats": {}}}], "$db": "db"}`,
+				expected: `{"aggregate": "coll", "pipeline": [{"$collStats": {"storageStats": {}}}], "$db": "t1_db"}`,
+			},
+		})
+	})
+	t.Run("dbStats response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "dbStats", []fixerTestCase{
+			{
+				name:     "replica set",
+				input:    `{"db": "t1_db", "collections": 1, "ok": 1}`,
+				expected: `{"db": "db", "collections": 1, "ok": 1}`,
+			},
+			{
+				name: "sharded",
+				input: `{"raw": {"rs0/host:27018": {"db": "t1_db", "collections": 1}, ` +
+					`"rs1/host:27019": {"db": "t1_db", "collections": 2}}, "db": "t1_db", "ok": 1}`,
+				expected: `{"raw": {"rs0/host:27018": {"db": "db", "collections": 1}, ` +
+					`"rs1/host:27019": {"db": "db", "collections": 2}}, "db": "db", "ok": 1}`,
+			},
+		})
+	})
+	t.Run("collStats response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "collStats", []fixerTestCase{
+			{
+				name:     "replica set",
+				input:    `{"ns": "t1_db.coll", "count": 1, "ok": 1}`,
+				expected: `{"ns": "db.coll", "count": 1, "ok": 1}`,
+			},
+			{
+				name:     "sharded",
+				input:    `{"sharded": true, "shards": {"s0": {"ns": "t1_db.coll", "count": 1}}, "ns": "t1_db.coll", "ok": 1}`,
+				expected: `{"sharded": true, "shards": {"s0": {"ns": "db.coll", "count": 1}}, "ns": "db.coll", "ok": 1}`,
+			},
+		})
+	})
+	t.Run("$collStats response", func(t *testing.T) {
+		runResponseFixerTests(t, p, collStatsFixerName, []fixerTestCase{
+			{
+				name: "batch",
+				input: `{"cursor": {"firstBatch": [{"ns": "t1_db.coll", "shard": "s0", "storageStats": {}}], ` +
+					`"id": 0, "ns": "t1_db.coll"}, "ok": 1}`,
+				expected: `{"cursor": {"firstBatch": [{"ns": "db.coll", "shard": "s0", "storageStats": {}}], ` +
+					`"id": 0, "ns": "db.coll"}, "ok": 1}`,
+			},
+		})
+	})
+	t.Run("validate response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "validate", []fixerTestCase{
+			{
+				name:     "valid",
+				input:    `{"ns": "t1_db.coll", "valid": true, "errors": [], "ok": 1}`,
+				expected: `{"ns": "db.coll", "valid": true, "errors": [], "ok": 1}`,
+			},
+		})
+	})
+}
+
+func TestIndexFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	const conflictErr = `Index with name: x_1 already exists with a different name in t1_db.coll`
+	const fixedConflictErr = `Index with name: x_1 already exists with a different name in db.coll`
+
+	t.Run("request", func(t *testing.T) {
+		runRequestFixerTests(t, p, []fixerTestCase{
+			{
+				name: "createIndexes",
+				input: `{"createIndexes": "coll", "indexes": [{"key": {"x": 1}, "name": "x_1", "ns": "db.coll"}, ` +
+					`{"key": {"y": 1}, "name": "y_1"}], "commitQuorum": "majority", "$db": "db"}`,
+				expected: `{"createIndexes": "coll", "indexes": [{"key": {"x": 1}, "name": "x_1", "ns": "t1_db.coll"}, ` +
+					`{"key": {"y": 1}, "name": "y_1"}], "commitQuorum": "majority", "$db": "t1_db"}`,
+			},
+			{
+				name:     "dropIndexes",
+				input:    `{"dropIndexes": "coll", "index": "x_1", "$db": "db"}`,
+				expected: `{"dropIndexes": "coll", "index": "x_1", "$db": "t1_db"}`,
+			},
+			{
+				name:     "setIndexCommitQuorum",
+				input:    `{"setIndexCommitQuorum": "coll", "indexNames": ["x_1"], "commitQuorum": 1, "$db": "db"}`,
+				expected: `{"setIndexCommitQuorum": "coll", "indexNames": ["x_1"], "commitQuorum": 1, "$db": "t1_db"}`,
+			},
+		})
+	})
+	for _, cmdName := range []string{"createIndexes", "dropIndexes", "setIndexCommitQuorum"} {
+		t.Run(cmdName+" response", func(t *testing.T) {
+			runResponseFixerTests(t, p, cmdName, []fixerTestCase{
+				{
+					name:     "success",
+					input:    `{"numIndexesBefore": 1, "numIndexesAfter": 2, "commitQuorum": "votingMembers", "ok": 1}`,
+					expected: `{"numIndexesBefore": 1, "numIndexesAfter": 2, "commitQuorum": "votingMembers", "ok": 1}`,
+				},
+				{
+					name:     "error",
+					input:    `{"ok": 0, "errmsg": "` + conflictErr + `", "code": 86, "codeName": "IndexKeySpecsConflict"}`,
+					expected: `{"ok": 0, "errmsg": "` + fixedConflictErr + `", "code": 86, "codeName": "IndexKeySpecsConflict"}`,
+				},
+				{
+					name:     "prefix inside a word",
+					input:    `{"ok": 0, "errmsg": "index at1_db.coll not found", "code": 27}`,
+					expected: `{"ok": 0, "errmsg": "index at1_db.coll not found", "code": 27}`,
+				},
+				{
+					name: "sharded",
+					input: `{"raw": {"rs0/host:27018": {"ok": 0, "errmsg": "` + conflictErr + `"}, ` +
+						`"rs1/host:27019": {"ok": 1}}, "ok": 0, "errmsg": "` + conflictErr + `"}`,
+					expected: `{"raw": {"rs0/host:27018": {"ok": 0, "errmsg": "` + fixedConflictErr + `"}, ` +
+						`"rs1/host:27019": {"ok": 1}}, "ok": 0, "errmsg": "` + fixedConflictErr + `"}`,
+				},
+			})
+		})
+	}
+	t.Run("listIndexes response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "listIndexes", []fixerTestCase{
+			{
+				name: "batch",
+				input: `{"cursor": {"firstBatch": [{"v": 2, "key": {"_id": 1}, "name": "_id_", "ns": "t1_db.coll"}], ` +
+					`"id": 0, "ns": "t1_db.$cmd.listIndexes.coll"}, "ok": 1}`,
+				expected: `{"cursor": {"firstBatch": [{"v": 2, "key": {"_id": 1}, "name": "_id_", "ns": "db.coll"}], ` +
+					`"id": 0, "ns": "db.$cmd.listIndexes.coll"}, "ok": 1}`,
+			},
+			{
+				name:     "error",
+				input:    `{"ok": 0, "errmsg": "ns does not exist: t1_db.coll", "code": 26, "codeName": "NamespaceNotFound"}`,
+				expected: `{"ok": 0, "errmsg": "ns does not exist: db.coll", "code": 26, "codeName": "NamespaceNotFound"}`,
+			},
+		})
+	})
+}
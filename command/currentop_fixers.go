@@ -0,0 +1,172 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// currentOpFixerName is the name of the FixerSet for aggregate requests with a $currentOp stage.
+const currentOpFixerName = "aggregate($currentOp)"
+
+// currentOpFollowingStages contains the stages that can follow $currentOp. $currentOp aggregations run against the
+// server's admin database, so stages that read or write other collections, such as $lookup, $unionWith, $out, and
+// $merge, would operate on the real admin database and are rejected.
+var currentOpFollowingStages = map[string]struct{}{
+	"$addFields": {},
+	"$count":     {},
+	"$group":     {},
+	"$limit":     {},
+	"$match":     {},
+	"$project":   {},
+	"$set":       {},
+	"$skip":      {},
+	"$sort":      {},
+	"$unset":     {},
+}
+
+// OwnsOperation reports whether an operation document returned by currentOp belongs to the tenant. An operation
+// belongs to the tenant if its namespace or the database of its command has the tenant's prefix, or if it is running
+// on one of the tenant's sessions.
+func (p *Parser) OwnsOperation(op bsoncore.Document) bool {
+	if ns, ok := op.Lookup("ns").StringValueOK(); ok && strings.HasPrefix(ns, p.opts.Prefix) {
+		return true
+	}
+	if db, ok := op.Lookup("command", "$db").StringValueOK(); ok && strings.HasPrefix(db, p.opts.Prefix) {
+		return true
+	}
+	if p.opts.Sessions != nil {
+		if _, id, ok := op.Lookup("lsid", "id").BinaryOK(); ok && p.opts.Sessions.Owns(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// newOperationFixer creates a DocumentFixer for operation documents returned by currentOp. The prefix is removed from
+// the namespace and the $db values of the operation's command and, for getMore operations, the command that created
+// the cursor.
+func (p *Parser) newOperationFixer() DocumentFixer {
+	commandFixer := DocumentFixer{
+		"$db": p.removeDBPrefix,
+	}
+	fixer := DocumentFixer{
+		"ns":                 p.removeDBPrefix,
+		"command":            commandFixer,
+		"originatingCommand": commandFixer,
+	}
+	if p.opts.Sessions != nil {
+		fixer["lsid"] = newLsidResponseFixer(p.opts.Sessions)
+	}
+	return fixer
+}
+
+// newInprogValueFixer creates a ValueFixer for the inprog array in currentOp responses. Operations that do not belong
+// to the tenant are removed and the remaining operations are fixed using opFixer.
+func (p *Parser) newInprogValueFixer(opFixer ValueFixer) ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		arr, ok := val.ArrayOK()
+		if !ok {
+			return nil, fmt.Errorf("expected inprog value to be array, got %s", val.Type)
+		}
+		ops, err := arr.Values()
+		if err != nil {
+			return nil, err
+		}
+
+		idx, dst := bsoncore.AppendArrayElementStart(dst, string(key))
+		var numOps int
+		for _, opVal := range ops {
+			op, ok := opVal.DocumentOK()
+			if !ok || !p.OwnsOperation(op) {
+				continue
+			}
+
+			dst, err = opFixer.fixValue(opVal, []byte(strconv.Itoa(numOps)), dst)
+			if err != nil {
+				return nil, err
+			}
+			numOps++
+		}
+		dst, _ = bsoncore.AppendArrayEnd(dst, idx)
+		return dst, nil
+	}
+}
+
+// newCurrentOpMatchPreparer returns a function that adds a $match stage after the $currentOp stage in an aggregate
+// request so only operations on the tenant's namespaces are returned. Unlike the currentOp command, operations
+// cannot be matched by session because the tenant's sessions are not known to the server. Requests with a stage that
+// is not in currentOpFollowingStages after the $currentOp stage are rejected.
+func newCurrentOpMatchPreparer(prefix string) func(bsoncore.Document) (bsoncore.Document, error) {
+	nsRegex := "^" + regexp.QuoteMeta(prefix)
+	matchStage := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendDocumentElement(nil, "$match", bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.BuildArrayElement(nil, "$or",
+				bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: bsoncore.BuildDocumentFromElements(nil,
+					bsoncore.AppendRegexElement(nil, "ns", nsRegex, ""),
+				)},
+				bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: bsoncore.BuildDocumentFromElements(nil,
+					bsoncore.AppendRegexElement(nil, "command.$db", nsRegex, ""),
+				)},
+			),
+		)),
+	)
+
+	return func(request bsoncore.Document) (bsoncore.Document, error) {
+		elems, err := request.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		idx, prepared := bsoncore.AppendDocumentStart(nil)
+		for _, elem := range elems {
+			if elem.Key() != "pipeline" {
+				prepared = append(prepared, elem...)
+				continue
+			}
+
+			stages, err := elem.Value().Array().Values()
+			if err != nil {
+				return nil, err
+			}
+			pipelineIdx, fixed := bsoncore.AppendArrayElementStart(prepared, "pipeline")
+			var numStages int
+			for i, stage := range stages {
+				if i > 0 {
+					if err := checkCurrentOpFollowingStage(stage); err != nil {
+						return nil, err
+					}
+				}
+				fixed = bsoncore.AppendValueElement(fixed, strconv.Itoa(numStages), stage)
+				numStages++
+				if i == 0 {
+					fixed = bsoncore.AppendDocumentElement(fixed, strconv.Itoa(numStages), matchStage)
+					numStages++
+				}
+			}
+			prepared, _ = bsoncore.AppendArrayEnd(fixed, pipelineIdx)
+		}
+		prepared, _ = bsoncore.AppendDocumentEnd(prepared, idx)
+		return prepared, nil
+	}
+}
+
+// checkCurrentOpFollowingStage returns an error if a stage after $currentOp is not in currentOpFollowingStages.
+func checkCurrentOpFollowingStage(stage bsoncore.Value) error {
+	stageDoc, ok := stage.DocumentOK()
+	if !ok {
+		return fmt.Errorf("expected pipeline stage to be document, got %s", stage.Type)
+	}
+	elem, err := stageDoc.IndexErr(0)
+	if err != nil {
+		return fmt.Errorf("pipeline stages cannot be empty")
+	}
+	if _, ok := currentOpFollowingStages[elem.Key()]; !ok {
+		return fmt.Errorf("%s is not allowed after $currentOp", elem.Key())
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// testSessions is a SessionMapper that owns a fixed set of server session IDs.
+type testSessions map[string]struct{}
+
+func (ts testSessions) ServerID(clientID []byte) []byte {
+	return clientID
+}
+
+func (ts testSessions) ClientID(serverID []byte) []byte {
+	return serverID
+}
+
+func (ts testSessions) Owns(serverID []byte) bool {
+	_, ok := ts[string(serverID)]
+	return ok
+}
+
+func TestOwnsOperation(t *testing.T) {
+	ownedSession := []byte("owned-session-id")
+	otherSession := []byte("other-session-id")
+	p := NewParser(ParserOptions{
+		Prefix:   "t1_",
+		Sessions: testSessions{string(ownedSession): {}},
+	})
+
+	testCases := []struct {
+		name     string
+		op       bsoncore.Document
+		expected bool
+	}{
+		{"namespace with prefix", operation("t1_db.coll", "", nil), true},
+		{"namespace of another tenant", operation("t11_db.coll", "", nil), false},
+		{"namespace without delimiter", operation("t1db.coll", "", nil), false},
+		{"database without collection", operation("t1_db", "", nil), true},
+		{"shared database", operation("admin.$cmd", "", nil), false},
+		{"command database with prefix", operation("", "t1_db", nil), true},
+		{"command database of another tenant", operation("", "t11_db", nil), false},
+		{"owned session", operation("admin.$cmd", "admin", ownedSession), true},
+		{"other session", operation("t11_db.coll", "t11_db", otherSession), false},
+		{"empty operation", operation("", "", nil), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := p.OwnsOperation(tc.op); got != tc.expected {
+				t.Fatalf("expected %v, got %v for operation %s", tc.expected, got, tc.op)
+			}
+		})
+	}
+}
+
+// operation creates an operation document like those returned by currentOp. Empty values are omitted.
+func operation(ns, db string, lsid []byte) bsoncore.Document {
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	if ns != "" {
+		doc = bsoncore.AppendStringElement(doc, "ns", ns)
+	}
+	if db != "" {
+		doc = bsoncore.AppendDocumentElement(doc, "command", bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.AppendStringElement(nil, "$db", db),
+		))
+	}
+	if lsid != nil {
+		doc = bsoncore.AppendDocumentElement(doc, "lsid", bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.AppendBinaryElement(nil, "id", bsontype.BinaryUUID, lsid),
+		))
+	}
+	doc, _ = bsoncore.AppendDocumentEnd(doc, idx)
+	return doc
+}
@@ -1,18 +1,18 @@
 package command
 
-// newCursorResponseFixer creates a DocumentFixer for cursor responses. The provided batchDocsFixer will be called for
-// each document in the cursor batch.
-func newDefaultCursorResponseFixer(batchDocsFixer ValueFixer) DocumentFixer {
+// newDefaultCursorResponseFixer creates a DocumentFixer for cursor responses. The provided batchDocsFixer will be
+// called for each document in the cursor batch.
+func (p *Parser) newDefaultCursorResponseFixer(batchDocsFixer ValueFixer) DocumentFixer {
 	return DocumentFixer{
-		"cursor": newCursorValueFixer(batchDocsFixer),
+		"cursor": p.newCursorValueFixer(batchDocsFixer),
 	}
 }
 
-// newCursorResponseFixer creates a ValueFixer for cursor subdocuments. The provided batchDocsFixer will be called for
+// newCursorValueFixer creates a ValueFixer for cursor subdocuments. The provided batchDocsFixer will be called for
 // each document in the cursor batch.
-func newCursorValueFixer(batchDocsFixer ValueFixer) ValueFixer {
+func (p *Parser) newCursorValueFixer(batchDocsFixer ValueFixer) ValueFixer {
 	fixers := DocumentFixer{
-		"ns": removeDBPrefixValueFixer,
+		"ns": p.removeDBPrefix,
 	}
 	if batchDocsFixer != nil {
 		avf := newArrayValueFixer(batchDocsFixer)
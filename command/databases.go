@@ -0,0 +1,218 @@
+package command
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+var (
+	// namespaceFields maps command names to the top-level fields that contain full namespaces ("db.coll") in
+	// requests.
+	namespaceFields = map[string][]string{
+		"cloneCollection":  {"cloneCollection"},
+		"dataSize":         {"dataSize"},
+		"renameCollection": {"renameCollection", "to"},
+	}
+
+	// roleManagementCommands contains the names of commands that can have roles and privileges arrays.
+	roleManagementCommands = map[string]struct{}{
+		"createRole":               {},
+		"createUser":               {},
+		"grantPrivilegesToRole":    {},
+		"grantRolesToRole":         {},
+		"grantRolesToUser":         {},
+		"revokePrivilegesFromRole": {},
+		"revokeRolesFromRole":      {},
+		"revokeRolesFromUser":      {},
+		"updateRole":               {},
+		"updateUser":               {},
+	}
+
+	// databaseFields maps command names to the top-level fields that contain database names in requests.
+	databaseFields = map[string][]string{
+		"copydb": {"fromdb", "todb"},
+	}
+)
+
+// ReferencedDatabases returns the names of all databases referenced by a request for the given command. This includes
+// the $db value as well as any databases named elsewhere in the request, such as in aggregation stages, mapReduce
+// output options, applyOps entries, and commands nested in explain. The returned slice may contain duplicates.
+func ReferencedDatabases(cmdName string, cmd bsoncore.Document) []string {
+	var dbs []string
+	if db, ok := cmd.Lookup("$db").StringValueOK(); ok {
+		dbs = append(dbs, db)
+	}
+	return appendCommandDatabases(dbs, cmdName, cmd)
+}
+
+func appendCommandDatabases(dbs []string, cmdName string, cmd bsoncore.Document) []string {
+	for _, field := range namespaceFields[cmdName] {
+		if ns, ok := cmd.Lookup(field).StringValueOK(); ok {
+			dbs = append(dbs, namespaceDatabase(ns))
+		}
+	}
+	for _, field := range databaseFields[cmdName] {
+		if db, ok := cmd.Lookup(field).StringValueOK(); ok {
+			dbs = append(dbs, db)
+		}
+	}
+
+	// Views created with create or modified with collMod have pipelines in addition to aggregate.
+	if pipeline, ok := cmd.Lookup("pipeline").ArrayOK(); ok {
+		dbs = appendPipelineDatabases(dbs, pipeline)
+	}
+
+	if _, ok := roleManagementCommands[cmdName]; ok {
+		dbs = appendRolesDatabases(dbs, cmd)
+	}
+
+	switch cmdName {
+	case "createIndexes":
+		indexes, _ := cmd.Lookup("indexes").ArrayOK()
+		specs, _ := indexes.Values()
+		for _, val := range specs {
+			if spec, ok := val.DocumentOK(); ok {
+				if ns, ok := spec.Lookup("ns").StringValueOK(); ok {
+					dbs = append(dbs, namespaceDatabase(ns))
+				}
+			}
+		}
+	case "mapReduce":
+		if db, ok := cmd.Lookup("out", "db").StringValueOK(); ok {
+			dbs = append(dbs, db)
+		}
+	case "applyOps":
+		if ops, ok := cmd.Lookup("applyOps").ArrayOK(); ok {
+			dbs = appendApplyOpsDatabases(dbs, ops)
+		}
+		if preConditions, ok := cmd.Lookup("preCondition").ArrayOK(); ok {
+			vals, _ := preConditions.Values()
+			for _, val := range vals {
+				if preCondition, ok := val.DocumentOK(); ok {
+					if ns, ok := preCondition.Lookup("ns").StringValueOK(); ok {
+						dbs = append(dbs, namespaceDatabase(ns))
+					}
+				}
+			}
+		}
+	case "explain":
+		if inner, ok := cmd.Lookup("explain").DocumentOK(); ok {
+			if elem, err := inner.IndexErr(0); err == nil {
+				dbs = appendCommandDatabases(dbs, elem.Key(), inner)
+			}
+		}
+	}
+	return dbs
+}
+
+// appendPipelineDatabases appends the databases referenced by the stages in an aggregation pipeline. Stages that
+// take a bare collection name refer to the command's database and are skipped.
+func appendPipelineDatabases(dbs []string, pipeline bsoncore.Array) []string {
+	stages, _ := pipeline.Values()
+	for _, stageVal := range stages {
+		stage, ok := stageVal.DocumentOK()
+		if !ok {
+			continue
+		}
+		elem, err := stage.IndexErr(0)
+		if err != nil {
+			continue
+		}
+		spec := elem.Value()
+
+		switch elem.Key() {
+		case "$out":
+			if specDoc, ok := spec.DocumentOK(); ok {
+				if db, ok := specDoc.Lookup("db").StringValueOK(); ok {
+					dbs = append(dbs, db)
+				}
+			}
+		case "$merge":
+			if specDoc, ok := spec.DocumentOK(); ok {
+				if db, ok := specDoc.Lookup("into", "db").StringValueOK(); ok {
+					dbs = append(dbs, db)
+				}
+			}
+		case "$lookup", "$graphLookup", "$unionWith":
+			if specDoc, ok := spec.DocumentOK(); ok {
+				if db, ok := specDoc.Lookup("from", "db").StringValueOK(); ok {
+					dbs = append(dbs, db)
+				}
+				if subPipeline, ok := specDoc.Lookup("pipeline").ArrayOK(); ok {
+					dbs = appendPipelineDatabases(dbs, subPipeline)
+				}
+			}
+		case "$facet":
+			if specDoc, ok := spec.DocumentOK(); ok {
+				facets, _ := specDoc.Elements()
+				for _, facet := range facets {
+					if subPipeline, ok := facet.Value().ArrayOK(); ok {
+						dbs = appendPipelineDatabases(dbs, subPipeline)
+					}
+				}
+			}
+		}
+	}
+	return dbs
+}
+
+// appendRolesDatabases appends the databases named by the {role, db} documents in a roles array and the resources in a
+// privileges array.
+func appendRolesDatabases(dbs []string, cmd bsoncore.Document) []string {
+	roles, _ := cmd.Lookup("roles").ArrayOK()
+	roleVals, _ := roles.Values()
+	for _, val := range roleVals {
+		if role, ok := val.DocumentOK(); ok {
+			if db, ok := role.Lookup("db").StringValueOK(); ok {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+
+	privileges, _ := cmd.Lookup("privileges").ArrayOK()
+	privilegeVals, _ := privileges.Values()
+	for _, val := range privilegeVals {
+		if privilege, ok := val.DocumentOK(); ok {
+			if db, ok := privilege.Lookup("resource", "db").StringValueOK(); ok {
+				dbs = append(dbs, db)
+			}
+		}
+	}
+	return dbs
+}
+
+// appendApplyOpsDatabases appends the databases referenced by the oplog entries in an applyOps array, including the
+// entries in nested applyOps commands and the namespaces in embedded commands.
+func appendApplyOpsDatabases(dbs []string, ops bsoncore.Array) []string {
+	vals, _ := ops.Values()
+	for _, val := range vals {
+		op, ok := val.DocumentOK()
+		if !ok {
+			continue
+		}
+		if ns, ok := op.Lookup("ns").StringValueOK(); ok && ns != "" {
+			dbs = append(dbs, namespaceDatabase(ns))
+		}
+
+		embedded, ok := op.Lookup("o").DocumentOK()
+		if !ok {
+			continue
+		}
+		if op, _ := op.Lookup("op").StringValueOK(); op != "c" {
+			continue
+		}
+		if elem, err := embedded.IndexErr(0); err == nil {
+			dbs = appendCommandDatabases(dbs, elem.Key(), embedded)
+		}
+	}
+	return dbs
+}
+
+// namespaceDatabase returns the database portion of a full namespace.
+func namespaceDatabase(ns string) string {
+	if idx := strings.IndexByte(ns, '.'); idx >= 0 {
+		return ns[:idx]
+	}
+	return ns
+}
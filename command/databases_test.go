@@ -0,0 +1,186 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+)
+
+func TestReferencedDatabases(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cmd      string
+		expected []string
+	}{
+		{"$db only", `{"find": "coll", "$db": "db"}`, []string{"db"}},
+		{"no $db", `{"find": "coll"}`, nil},
+		{
+			"renameCollection",
+			`{"renameCollection": "db1.coll", "to": "db2.coll", "$db": "admin"}`,
+			[]string{"admin", "db1", "db2"},
+		},
+		{"dataSize", `{"dataSize": "db1.coll", "$db": "db"}`, []string{"db", "db1"}},
+		{"copydb", `{"copydb": 1, "fromdb": "db1", "todb": "db2", "$db": "admin"}`, []string{"admin", "db1", "db2"}},
+		{
+			"aggregate stages",
+			`{"aggregate": "coll", "pipeline": [
+				{"$lookup": {"from": {"db": "db1", "coll": "c"}, "as": "x"}},
+				{"$unionWith": {"coll": "c"}},
+				{"$out": {"db": "db2", "coll": "c"}}
+			], "$db": "db"}`,
+			[]string{"db", "db1", "db2"},
+		},
+		{"$out with collection name", `{"aggregate": "coll", "pipeline": [{"$out": "c"}], "$db": "db"}`, []string{"db"}},
+		{
+			"$merge",
+			`{"aggregate": "coll", "pipeline": [{"$merge": {"into": {"db": "db1", "coll": "c"}}}], "$db": "db"}`,
+			[]string{"db", "db1"},
+		},
+		{
+			"nested pipelines",
+			`{"aggregate": "coll", "pipeline": [
+				{"$facet": {"a": [{"$lookup": {"from": "c", "pipeline": [
+					{"$unionWith": {"coll": "c", "pipeline": [{"$lookup": {"from": {"db": "db1", "coll": "c"}}}]}},
+					{"$graphLookup": {"from": {"db": "db2", "coll": "c"}}}
+				], "as": "x"}}]}}
+			], "$db": "db"}`,
+			[]string{"db", "db1", "db2"},
+		},
+		{
+			"view pipeline",
+			`{"create": "view", "viewOn": "coll", "pipeline": [{"$lookup": {"from": {"db": "db1", "coll": "c"}}}],
+				"$db": "db"}`,
+			[]string{"db", "db1"},
+		},
+		{"mapReduce", `{"mapReduce": "coll", "out": {"replace": "c", "db": "db1"}, "$db": "db"}`, []string{"db", "db1"}},
+		{"mapReduce inline", `{"mapReduce": "coll", "out": {"inline": 1}, "$db": "db"}`, []string{"db"}},
+		{
+			"createIndexes",
+			`{"createIndexes": "coll", "indexes": [{"key": {"a": 1}, "name": "a_1", "ns": "db1.coll"}], "$db": "db"}`,
+			[]string{"db", "db1"},
+		},
+		{
+			"roles and privileges",
+			`{"createRole": "r", "roles": [{"role": "read", "db": "db1"}, "local"],
+				"privileges": [{"resource": {"db": "db2", "collection": ""}, "actions": ["find"]}], "$db": "db"}`,
+			[]string{"db", "db1", "db2"},
+		},
+		{
+			"applyOps",
+			`{"applyOps": [
+				{"op": "i", "ns": "db1.coll", "o": {"_id": 1}},
+				{"op": "n", "ns": "", "o": {"msg": "noop"}},
+				{"op": "c", "ns": "db2.$cmd", "o": {"renameCollection": "db3.a", "to": "db4.b"}},
+				{"op": "c", "ns": "db5.$cmd", "o": {"applyOps": [{"op": "d", "ns": "db6.coll", "o": {"_id": 1}}]}}
+			], "preCondition": [{"ns": "db7.coll", "q": {}, "res": {}}], "$db": "admin"}`,
+			[]string{"admin", "db1", "db2", "db3", "db4", "db5", "db6", "db7"},
+		},
+		{
+			"explain",
+			`{"explain": {"aggregate": "coll", "pipeline": [{"$out": {"db": "db1", "coll": "c"}}], "$db": "ignored"},
+				"$db": "db"}`,
+			[]string{"db", "db1"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := testutil.Document(t, tc.cmd)
+			got := ReferencedDatabases(cmd.Index(0).Key(), cmd)
+			assertDatabases(t, got, tc.expected)
+		})
+	}
+}
+
+// TestFixedRequestDatabases checks that every database referenced by a fixed request has the tenant's prefix,
+// including the delimiter, except for the server's admin database for commands that must run against it.
+func TestFixedRequestDatabases(t *testing.T) {
+	const prefix = "t1_"
+	p := NewParser(ParserOptions{Prefix: prefix})
+
+	testCases := []struct {
+		name     string
+		cmd      string
+		expected []string
+	}{
+		{"find", `{"find": "coll", "$db": "db"}`, []string{"t1_db"}},
+		{"tenant admin database", `{"create": "coll", "$db": "admin"}`, []string{"t1_admin"}},
+		{
+			"database that looks like another tenant's",
+			`{"find": "coll", "$db": "1_db"}`,
+			[]string{"t1_1_db"},
+		},
+		{
+			"renameCollection",
+			`{"renameCollection": "db1.coll", "to": "db2.coll", "$db": "admin"}`,
+			[]string{"admin", "t1_db1", "t1_db2"},
+		},
+		{
+			"aggregate stages",
+			`{"aggregate": "coll", "pipeline": [
+				{"$lookup": {"from": {"db": "db1", "coll": "c"}, "as": "x"}},
+				{"$merge": {"into": {"db": "db2", "coll": "c"}}}
+			], "$db": "db"}`,
+			[]string{"t1_db", "t1_db1", "t1_db2"},
+		},
+		{
+			"mapReduce",
+			`{"mapReduce": "coll", "out": {"replace": "c", "db": "db1"}, "$db": "db"}`,
+			[]string{"t1_db", "t1_db1"},
+		},
+		{
+			"applyOps",
+			`{"applyOps": [
+				{"op": "i", "ns": "db1.coll", "o": {"_id": 1}},
+				{"op": "c", "ns": "db2.$cmd", "o": {"renameCollection": "db3.a", "to": "db4.b"}}
+			], "$db": "db"}`,
+			[]string{"t1_db", "t1_db1", "t1_db2", "t1_db3", "t1_db4"},
+		},
+		{
+			"grantRolesToUser",
+			`{"grantRolesToUser": "u", "roles": [{"role": "read", "db": "db1"}], "$db": "db"}`,
+			[]string{"t1_db", "t1_db1"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := testutil.Document(t, tc.cmd)
+			cmdName := cmd.Index(0).Key()
+			fixed, err := p.Parse(FixerName(cmdName, cmd)).FixRequest(cmd)
+			if err != nil {
+				t.Fatalf("error fixing request: %v", err)
+			}
+
+			got := ReferencedDatabases(cmdName, fixed)
+			assertDatabases(t, got, tc.expected)
+			for _, db := range got {
+				if db != "admin" && !strings.HasPrefix(db, prefix) {
+					t.Fatalf("database %q does not have prefix %q", db, prefix)
+				}
+			}
+		})
+	}
+}
+
+// assertDatabases checks that got contains the expected databases in any order, ignoring duplicates.
+func assertDatabases(t *testing.T, got, expected []string) {
+	t.Helper()
+
+	gotSet := make(map[string]struct{}, len(got))
+	for _, db := range got {
+		gotSet[db] = struct{}{}
+	}
+	expectedSet := make(map[string]struct{}, len(expected))
+	for _, db := range expected {
+		expectedSet[db] = struct{}{}
+	}
+
+	if len(gotSet) != len(expectedSet) {
+		t.Fatalf("databases mismatch; got %v, want %v", got, expected)
+	}
+	for db := range expectedSet {
+		if _, ok := gotSet[db]; !ok {
+			t.Fatalf("databases mismatch; got %v, want %v", got, expected)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/divjotarora/proxy/bsonutil"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
 )
 
@@ -125,3 +126,84 @@ func (avf *arrayValueFixer) fixValue(val bsoncore.Value, key []byte, dst bsoncor
 	dst, _ = bsoncore.AppendArrayEnd(dst, idx)
 	return dst, nil
 }
+
+// deepValueFixer is a ValueFixer that applies a DocumentFixer to matching keys at any depth in a document or array.
+// Values for keys that do not have a registered fixer are copied, recursing into nested documents and arrays.
+type deepValueFixer struct {
+	fixers DocumentFixer
+}
+
+func newDeepValueFixer(fixers DocumentFixer) *deepValueFixer {
+	return &deepValueFixer{
+		fixers: fixers,
+	}
+}
+
+// Fix applies the fixers to the provided document at any depth and returns the fixed document.
+func (dvf *deepValueFixer) Fix(doc bsoncore.Document) (bsoncore.Document, error) {
+	idx, fixed := bsoncore.AppendDocumentStart(nil)
+	fixed, err := dvf.fixElements(doc, true, fixed)
+	if err != nil {
+		return nil, err
+	}
+	fixed, _ = bsoncore.AppendDocumentEnd(fixed, idx)
+	return fixed, nil
+}
+
+// fixValue implements ValueFixer.
+func (dvf *deepValueFixer) fixValue(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+	var idx int32
+	switch val.Type {
+	case bsontype.EmbeddedDocument:
+		idx, dst = bsoncore.AppendDocumentElementStart(dst, string(key))
+	case bsontype.Array:
+		idx, dst = bsoncore.AppendArrayElementStart(dst, string(key))
+	default:
+		return bsoncore.AppendValueElement(dst, string(key), val), nil
+	}
+
+	dst, err := dvf.fixElements(val.Data, val.Type == bsontype.EmbeddedDocument, dst)
+	if err != nil {
+		return nil, err
+	}
+
+	if val.Type == bsontype.Array {
+		dst, _ = bsoncore.AppendArrayEnd(dst, idx)
+	} else {
+		dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	}
+	return dst, nil
+}
+
+func (dvf *deepValueFixer) fixElements(src []byte, isDocument bool, dst bsoncore.Document) (bsoncore.Document, error) {
+	iter, err := bsonutil.NewIterator(src)
+	if err != nil {
+		return nil, err
+	}
+	for iter.Next() {
+		elemKey := iter.Element().KeyBytes()
+		elemVal := iter.Value()
+
+		// Array indexes never match a fixer key, so only document elements are checked.
+		var vf ValueFixer = dvf
+		if isDocument {
+			if fixer, ok := dvf.fixers[string(elemKey)]; ok {
+				vf = fixer
+			}
+		}
+
+		dst, err = vf.fixValue(elemVal, elemKey, dst)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// omitValueFixer is a ValueFixer that removes the value from the document.
+var omitValueFixer = ValueFixerFunc(func(_ bsoncore.Value, _ []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+	return dst, nil
+})
@@ -65,12 +65,13 @@ func BenchmarkFixers(b *testing.B) {
 		// Benchmark using a DocumentFixer.
 		b.ReportAllocs()
 
+		p := NewParser(ParserOptions{Prefix: "fixed"})
 		listCollsBatchFixer := DocumentFixer{
 			"idIndex": DocumentFixer{
-				"ns": ValueFixerFunc(removeDBPrefixValueFixer),
+				"ns": p.removeDBPrefix,
 			},
 		}
-		responseFixer := newDefaultCursorResponseFixer(listCollsBatchFixer)
+		responseFixer := p.newDefaultCursorResponseFixer(listCollsBatchFixer)
 
 		for i := 0; i < b.N; i++ {
 			_, err := responseFixer.Fix(listCollsResponse)
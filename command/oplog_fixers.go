@@ -0,0 +1,123 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// oplogFixerName is the name of the FixerSet for find requests against the oplog.
+const oplogFixerName = "find(oplog)"
+
+var (
+	// oplogDatabaseNames contains the names of databases that are not prefixed in oplog reads.
+	oplogDatabaseNames = map[string]struct{}{
+		"local": {},
+	}
+)
+
+func isOplogNamespace(cmd bsoncore.Document) bool {
+	db, _ := cmd.Lookup("$db").StringValueOK()
+	coll, _ := cmd.Index(0).Value().StringValueOK()
+	return db == "local" && coll == "oplog.rs"
+}
+
+// newOplogFilterPreparer returns a function that restricts the filter of an oplog find request to entries for the
+// tenant's databases. Entries for transactions and other applyOps commands are matched by the namespaces of their
+// nested operations and are only returned if every nested operation is for one of the tenant's databases. Requests
+// other than find, such as getMore requests on an oplog cursor, are not changed.
+func newOplogFilterPreparer(prefix string) func(bsoncore.Document) (bsoncore.Document, error) {
+	nsRegex := "^" + regexp.QuoteMeta(prefix)
+	tenantFilter := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.BuildArrayElement(nil, "$or",
+			bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: bsoncore.BuildDocumentFromElements(nil,
+				bsoncore.AppendRegexElement(nil, "ns", nsRegex, ""),
+			)},
+			bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: bsoncore.BuildDocumentFromElements(nil,
+				bsoncore.AppendRegexElement(nil, "o.applyOps.ns", nsRegex, ""),
+				bsoncore.AppendDocumentElement(nil, "o.applyOps", bsoncore.BuildDocumentFromElements(nil,
+					bsoncore.AppendDocumentElement(nil, "$not", bsoncore.BuildDocumentFromElements(nil,
+						bsoncore.AppendDocumentElement(nil, "$elemMatch", bsoncore.BuildDocumentFromElements(nil,
+							bsoncore.AppendDocumentElement(nil, "ns", bsoncore.BuildDocumentFromElements(nil,
+								bsoncore.AppendRegexElement(nil, "$not", nsRegex, ""),
+							)),
+						)),
+					)),
+				)),
+			)},
+		),
+	)
+
+	return func(request bsoncore.Document) (bsoncore.Document, error) {
+		if request.Index(0).Key() != "find" {
+			return request, nil
+		}
+		elems, err := request.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		filter := tenantFilter
+		idx, prepared := bsoncore.AppendDocumentStart(nil)
+		for _, elem := range elems {
+			if elem.Key() != "filter" {
+				prepared = append(prepared, elem...)
+				continue
+			}
+
+			clientFilter, ok := elem.Value().DocumentOK()
+			if !ok {
+				return nil, fmt.Errorf("expected filter value to be document, got %s", elem.Value().Type)
+			}
+			filter = bsoncore.BuildDocumentFromElements(nil,
+				bsoncore.BuildArrayElement(nil, "$and",
+					bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: clientFilter},
+					bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: tenantFilter},
+				),
+			)
+		}
+		prepared = bsoncore.AppendDocumentElement(prepared, "filter", filter)
+		prepared, _ = bsoncore.AppendDocumentEnd(prepared, idx)
+		return prepared, nil
+	}
+}
+
+// newOplogEntryFixer creates a ValueFixer for oplog entries in responses. The prefix is removed from the ns value of
+// each entry and, for command entries, from the namespaces in renameCollection commands and the ns values of operations
+// nested in an applyOps command. If sessions is not nil, lsid values are translated to the client's session IDs.
+func newOplogEntryFixer(removeDBPrefix ValueFixer, sessions SessionMapper) ValueFixer {
+	var entryFixer ValueFixerFunc
+	crudEntryFixer := DocumentFixer{
+		"ns": removeDBPrefix,
+	}
+	commandEntryFixer := DocumentFixer{
+		"ns": removeDBPrefix,
+		"o": DocumentFixer{
+			"renameCollection": removeDBPrefix,
+			"to":               removeDBPrefix,
+			"applyOps": newArrayValueFixer(ValueFixerFunc(func(val bsoncore.Value, key []byte,
+				dst bsoncore.Document) (bsoncore.Document, error) {
+
+				return entryFixer(val, key, dst)
+			})),
+		},
+	}
+	if sessions != nil {
+		crudEntryFixer["lsid"] = newLsidResponseFixer(sessions)
+		commandEntryFixer["lsid"] = newLsidResponseFixer(sessions)
+	}
+
+	entryFixer = func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		entry, ok := val.DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("expected oplog entry to be document, got %s", val.Type)
+		}
+		if op, _ := entry.Lookup("op").StringValueOK(); op == "c" {
+			return commandEntryFixer.fixValue(val, key, dst)
+		}
+		return crudEntryFixer.fixValue(val, key, dst)
+	}
+	return entryFixer
+}
@@ -0,0 +1,139 @@
+package command
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+)
+
+func TestOplogFilterPreparer(t *testing.T) {
+	const tenantFilter = `{"$or": [
+		{"ns": {"$regularExpression": {"pattern": "^t1_", "options": ""}}},
+		{
+			"o.applyOps.ns": {"$regularExpression": {"pattern": "^t1_", "options": ""}},
+			"o.applyOps": {"$not": {"$elemMatch": {"ns": {"$not": {"$regularExpression": {"pattern": "^t1_", "options": ""}}}}}}
+		}
+	]}`
+	prepare := newOplogFilterPreparer("t1_")
+
+	testCases := []struct {
+		name     string
+		request  string
+		expected string
+	}{
+		{
+			"no filter",
+			`{"find": "oplog.rs", "$db": "local"}`,
+			`{"find": "oplog.rs", "$db": "local", "filter": ` + tenantFilter + `}`,
+		},
+		{
+			"client filter",
+			`{"find": "oplog.rs", "filter": {"op": "i"}, "$db": "local"}`,
+			`{"find": "oplog.rs", "$db": "local", "filter": {"$and": [{"op": "i"}, ` + tenantFilter + `]}}`,
+		},
+		{
+			"getMore",
+			`{"getMore": {"$numberLong": "1"}, "collection": "oplog.rs", "$db": "local"}`,
+			`{"getMore": {"$numberLong": "1"}, "collection": "oplog.rs", "$db": "local"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := prepare(testutil.Document(t, tc.request))
+			if err != nil {
+				t.Fatalf("error preparing request: %v", err)
+			}
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(got, expected) {
+				t.Fatalf("expected %s, got %s", expected, got)
+			}
+		})
+	}
+
+	if _, err := prepare(testutil.Document(t, `{"find": "oplog.rs", "filter": 1, "$db": "local"}`)); err == nil {
+		t.Fatal("expected error for non-document filter, got nil")
+	}
+}
+
+// mappedSessions is a SessionMapper that maps server session IDs to client session IDs using a fixed table.
+type mappedSessions map[string]string // server ID -> client ID
+
+func (ms mappedSessions) ServerID(clientID []byte) []byte {
+	for serverID, mappedClientID := range ms {
+		if mappedClientID == string(clientID) {
+			return []byte(serverID)
+		}
+	}
+	return clientID
+}
+
+func (ms mappedSessions) ClientID(serverID []byte) []byte {
+	if clientID, ok := ms[string(serverID)]; ok {
+		return []byte(clientID)
+	}
+	return serverID
+}
+
+func (ms mappedSessions) Owns(serverID []byte) bool {
+	_, ok := ms[string(serverID)]
+	return ok
+}
+
+func TestOplogEntryFixer(t *testing.T) {
+	const (
+		serverSession = `{"$binary": {"base64": "c2VydmVyLXNlc3Npb24=", "subType": "04"}}` // "server-session"
+		clientSession = `{"$binary": {"base64": "Y2xpZW50LXNlc3Npb24=", "subType": "04"}}` // "client-session"
+	)
+	p := NewParser(ParserOptions{
+		Prefix:   "t1_",
+		Sessions: mappedSessions{"server-session": "client-session"},
+	})
+
+	testCases := []struct {
+		name     string
+		entry    string
+		expected string
+	}{
+		{
+			"insert",
+			`{"op": "i", "ns": "t1_db.coll", "o": {"_id": 1}}`,
+			`{"op": "i", "ns": "db.coll", "o": {"_id": 1}}`,
+		},
+		{
+			"retryable write",
+			`{"op": "u", "ns": "t1_db.coll", "lsid": {"id": ` + serverSession + `}, "o": {"$set": {"a": 1}}}`,
+			`{"op": "u", "ns": "db.coll", "lsid": {"id": ` + clientSession + `}, "o": {"$set": {"a": 1}}}`,
+		},
+		{
+			"renameCollection",
+			`{"op": "c", "ns": "t1_db.$cmd", "o": {"renameCollection": "t1_db.a", "to": "t1_db2.b", "stayTemp": false}}`,
+			`{"op": "c", "ns": "db.$cmd", "o": {"renameCollection": "db.a", "to": "db2.b", "stayTemp": false}}`,
+		},
+		{
+			"transaction",
+			`{"op": "c", "ns": "admin.$cmd", "lsid": {"id": ` + serverSession + `}, "o": {"applyOps": [
+				{"op": "i", "ns": "t1_db.coll", "o": {"_id": 1}},
+				{"op": "c", "ns": "t1_db.$cmd", "o": {"renameCollection": "t1_db.a", "to": "t1_db.b"}}
+			]}}`,
+			`{"op": "c", "ns": "admin.$cmd", "lsid": {"id": ` + clientSession + `}, "o": {"applyOps": [
+				{"op": "i", "ns": "db.coll", "o": {"_id": 1}},
+				{"op": "c", "ns": "db.$cmd", "o": {"renameCollection": "db.a", "to": "db.b"}}
+			]}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reply := testutil.Document(t, `{"cursor": {"id": {"$numberLong": "0"}, "ns": "local.oplog.rs", "firstBatch": [`+
+				tc.entry+`]}, "ok": 1}`)
+			fixed, err := p.Parse(oplogFixerName).FixResponse(reply)
+			if err != nil {
+				t.Fatalf("error fixing response: %v", err)
+			}
+
+			got := fixed.Lookup("cursor", "firstBatch").Array().Index(0).Value().Document()
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(got, expected) {
+				t.Fatalf("expected %s, got %s", expected, got)
+			}
+		})
+	}
+}
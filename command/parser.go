@@ -7,33 +7,102 @@ import (
 // FixerSet represents two fixers associated with a command: one for the incoming request to the underlying server and
 // one for the outgoing response back to the client.
 type FixerSet struct {
-	requestFixer  DocumentFixer
-	responseFixer DocumentFixer
+	// prepareRequest, if non-nil, is called to rewrite the request before the request fixer is applied. This is
+	// used for changes that DocumentFixer cannot express, such as adding a value that is missing from the request.
+	prepareRequest func(bsoncore.Document) (bsoncore.Document, error)
+	requestFixer   DocumentFixer
+	responseFixer  DocumentFixer
+	// sequenceFixers fixes the documents in OP_MSG document sequences in requests, keyed by sequence identifier.
+	sequenceFixers map[string]documentFixer
+}
+
+// documentFixer is implemented by types that can fix a standalone document.
+type documentFixer interface {
+	Fix(bsoncore.Document) (bsoncore.Document, error)
 }
 
 // FixRequest calls the registered Fixer for the incoming request to the underlying server.
 func (f FixerSet) FixRequest(request bsoncore.Document) (bsoncore.Document, error) {
+	if f.prepareRequest != nil {
+		var err error
+		if request, err = f.prepareRequest(request); err != nil {
+			return nil, err
+		}
+	}
 	return f.requestFixer.Fix(request)
 }
 
+// FixRequestSequenceDocument calls the registered fixer for a document in the request's document sequence with the
+// given identifier. If there is no fixer for the sequence, the document is returned unchanged.
+func (f FixerSet) FixRequestSequenceDocument(identifier string, doc bsoncore.Document) (bsoncore.Document, error) {
+	fixer, ok := f.sequenceFixers[identifier]
+	if !ok {
+		return doc, nil
+	}
+	return fixer.Fix(doc)
+}
+
 // FixResponse calls the registered Fixer for the outgoing response back to the client.
 func (f FixerSet) FixResponse(response bsoncore.Document) (bsoncore.Document, error) {
 	return f.responseFixer.Fix(response)
 }
 
+// ParserOptions configures the fixers created by a Parser.
+type ParserOptions struct {
+	// Prefix is prepended to database names in requests and removed from database names in responses. It is also
+	// used to decide whether namespaces in currentOp output and the oplog belong to the tenant, so it must end with a
+	// delimiter that cannot occur in any other tenant's prefix (see tenant.Tenant.DatabasePrefix).
+	Prefix string
+	// Sessions is used to translate logical session IDs. If nil, session IDs are proxied without modification.
+	Sessions SessionMapper
+	// FixDBRefs enables fixing the $db values of DBRefs in user documents and in the filters of find, count,
+	// distinct, update, delete, and findAndModify requests. This requires walking every document written or read, so
+	// it is disabled by default. DBRefs in aggregation pipelines, such as in $match stages, and filters that match the
+	// $db field by a dotted path like {"ref.$db": <db>} are not fixed.
+	FixDBRefs bool
+}
+
 // Parser parsers command names and maps them to Fixer implementations.
 type Parser struct {
 	fixers          map[string]FixerSet
 	defaultFixerSet FixerSet
+	opts            ParserOptions
+
+	addDBPrefix      ValueFixer
+	addAdminDBPrefix ValueFixer
+	addNSPrefix      ValueFixer
+	removeDBPrefix   ValueFixer
+	writeErrors      ValueFixer
+
+	// addDBRefPrefix and removeDBRefPrefix fix the DBRefs in a user document at any depth. Both are nil if DBRefs
+	// are not being fixed.
+	addDBRefPrefix    *deepValueFixer
+	removeDBRefPrefix *deepValueFixer
 }
 
 // NewParser initializes a new Parser instance.
-func NewParser() *Parser {
+func NewParser(opts ParserOptions) *Parser {
 	p := &Parser{
-		fixers: make(map[string]FixerSet),
+		fixers:           make(map[string]FixerSet),
+		opts:             opts,
+		addDBPrefix:      newAddDBPrefixValueFixer(opts.Prefix, nil),
+		addAdminDBPrefix: newAddDBPrefixValueFixer(opts.Prefix, noopDatabaseNames),
+		addNSPrefix:      newAddNamespacePrefixValueFixer(opts.Prefix),
+		removeDBPrefix:   newRemoveDBPrefixValueFixer(opts.Prefix),
+		writeErrors:      newWriteErrorsValueFixer(opts.Prefix),
+	}
+	if opts.FixDBRefs {
+		// $db is reserved for DBRefs. Servers before 5.0 reject other field names starting with $ and it is not a query
+		// operator, so any $db key is treated as part of a DBRef.
+		p.addDBRefPrefix = newDeepValueFixer(DocumentFixer{
+			"$db": p.addDBPrefix,
+		})
+		p.removeDBRefPrefix = newDeepValueFixer(DocumentFixer{
+			"$db": p.removeDBPrefix,
+		})
 	}
 	p.defaultFixerSet = FixerSet{
-		requestFixer:  p.createDefaultRequestFixer(),
+		requestFixer:  p.createDefaultRequestFixer(""),
 		responseFixer: p.createDefaultResponseFixer(),
 	}
 
@@ -49,21 +118,70 @@ func (p *Parser) Parse(cmdName string) FixerSet {
 	return p.defaultFixerSet
 }
 
-func (p *Parser) createDefaultRequestFixer() DocumentFixer {
-	// By default, only the $db value is fixed in requests to prepend a prefix to the database name.
-	return DocumentFixer{
-		"$db": addDBPrefixValueFixer,
+// collStatsFixerName is the name of the FixerSet for aggregate requests with a $collStats stage.
+const collStatsFixerName = "aggregate($collStats)"
+
+// FixerName returns the name of the FixerSet to use for a request. This is usually the command name, but reads from
+// the oplog and $currentOp and $collStats aggregations are fixed differently from other find and aggregate requests.
+func FixerName(cmdName string, cmd bsoncore.Document) string {
+	switch {
+	case cmdName == "find" && isOplogNamespace(cmd):
+		return oplogFixerName
+	case cmdName == "aggregate" && firstStageName(cmd) == "$currentOp":
+		return currentOpFixerName
+	case cmdName == "aggregate" && firstStageName(cmd) == "$collStats":
+		return collStatsFixerName
+	}
+	return cmdName
+}
+
+// firstStageName returns the name of the first stage in an aggregate request's pipeline, or an empty string if the
+// pipeline is empty or missing.
+func firstStageName(cmd bsoncore.Document) string {
+	pipeline, ok := cmd.Lookup("pipeline").ArrayOK()
+	if !ok {
+		return ""
 	}
+	stageElem, err := pipeline.IndexErr(0)
+	if err != nil {
+		return ""
+	}
+	firstStage, ok := stageElem.Value().DocumentOK()
+	if !ok {
+		return ""
+	}
+	elem, err := firstStage.IndexErr(0)
+	if err != nil {
+		return ""
+	}
+	return elem.Key()
+}
+
+func (p *Parser) createDefaultRequestFixer(cmdName string) DocumentFixer {
+	// By default, the $db value is fixed in requests to prepend a prefix to the database name. This includes admin,
+	// so each tenant has its own admin database, unless the command must be run against the server's admin database.
+	// If session IDs are being translated, the lsid value is also fixed.
+	dbFixer := p.addDBPrefix
+	if _, ok := adminCommands[cmdName]; ok {
+		dbFixer = p.addAdminDBPrefix
+	}
+	fixer := DocumentFixer{
+		"$db": dbFixer,
+	}
+	if p.opts.Sessions != nil {
+		fixer["lsid"] = newLsidRequestFixer(p.opts.Sessions)
+	}
+	return fixer
 }
 
 func (p *Parser) createDefaultResponseFixer() DocumentFixer {
 	return DocumentFixer{
-		"writeErrors": writeErrorsValueFixer,
+		"writeErrors": p.writeErrors,
 	}
 }
 
 func (p *Parser) register(cmdName string, requestFixer DocumentFixer, responseFixer DocumentFixer) {
-	fullRequestFixer := p.createDefaultRequestFixer()
+	fullRequestFixer := p.createDefaultRequestFixer(cmdName)
 	for k, v := range requestFixer {
 		fullRequestFixer[k] = v
 	}
@@ -78,3 +196,29 @@ func (p *Parser) register(cmdName string, requestFixer DocumentFixer, responseFi
 		responseFixer: fullResponseFixer,
 	}
 }
+
+// setRequestPreparer sets the function that rewrites requests for a registered command before they are fixed.
+func (p *Parser) setRequestPreparer(cmdName string, prepare func(bsoncore.Document) (bsoncore.Document, error)) {
+	fixerSet := p.fixers[cmdName]
+	fixerSet.prepareRequest = prepare
+	p.fixers[cmdName] = fixerSet
+}
+
+// setSequenceFixer sets the fixer for the documents in a document sequence for a registered command.
+func (p *Parser) setSequenceFixer(cmdName, identifier string, fixer documentFixer) {
+	fixerSet := p.fixers[cmdName]
+	if fixerSet.sequenceFixers == nil {
+		fixerSet.sequenceFixers = make(map[string]documentFixer)
+	}
+	fixerSet.sequenceFixers[identifier] = fixer
+	p.fixers[cmdName] = fixerSet
+}
+
+// userDocumentResponseFixer returns the ValueFixer for user documents in responses, or nil if DBRefs are not being
+// fixed.
+func (p *Parser) userDocumentResponseFixer() ValueFixer {
+	if p.removeDBRefPrefix == nil {
+		return nil
+	}
+	return p.removeDBRefPrefix
+}
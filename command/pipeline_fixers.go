@@ -0,0 +1,62 @@
+package command
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// newPipelineValueFixer creates a ValueFixer for aggregation pipelines. The provided dbFixer is applied to database
+// names in stages that can reference other databases: $lookup and $graphLookup from documents, $out documents, and
+// $merge into documents. Pipelines nested in $lookup, $unionWith, and $facet stages are fixed recursively. Stages
+// that only take a collection name refer to the command's database and are copied without modification.
+func newPipelineValueFixer(dbFixer ValueFixer) ValueFixer {
+	stageFixer := DocumentFixer{}
+	pipelineFixer := newArrayValueFixer(stageFixer)
+	namespaceFixer := newOptionalDocumentValueFixer(DocumentFixer{
+		"db": dbFixer,
+	})
+
+	stageFixer["$lookup"] = DocumentFixer{
+		"from":     namespaceFixer,
+		"pipeline": pipelineFixer,
+	}
+	stageFixer["$graphLookup"] = DocumentFixer{
+		"from": namespaceFixer,
+	}
+	stageFixer["$unionWith"] = newOptionalDocumentValueFixer(DocumentFixer{
+		"pipeline": pipelineFixer,
+	})
+	stageFixer["$facet"] = newEachValueFixer(pipelineFixer)
+	stageFixer["$out"] = namespaceFixer
+	stageFixer["$merge"] = newOptionalDocumentValueFixer(DocumentFixer{
+		"into": namespaceFixer,
+	})
+
+	return pipelineFixer
+}
+
+// newEachValueFixer creates a ValueFixer for documents with arbitrary keys that applies the provided ValueFixer to
+// every value in the document.
+func newEachValueFixer(vf ValueFixer) ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		doc, ok := val.DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("expected value for key %s to be document, got %s", key, val.Type)
+		}
+		elems, err := doc.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		idx, dst := bsoncore.AppendDocumentElementStart(dst, string(key))
+		for _, elem := range elems {
+			dst, err = vf.fixValue(elem.Value(), []byte(elem.Key()), dst)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+		return dst, nil
+	}
+}
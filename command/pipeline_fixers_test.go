@@ -0,0 +1,105 @@
+package command
+
+import "testing"
+
+func TestViewFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	t.Run("request", func(t *testing.T) {
+		runRequestFixerTests(t, p, []fixerTestCase{
+			{
+				name: "create view",
+				input: `{"create": "view", "viewOn": "coll", "pipeline": [{"$match": {"x": 1}}, ` +
+					`{"$lookup": {"from": {"db": "other", "coll": "c"}, "as": "joined", ` +
+					`"pipeline": [{"$graphLookup": {"from": {"db": "other", "coll": "g"}}}]}}], "$db": "db"}`,
+				expected: `{"create": "view", "viewOn": "coll", "pipeline": [{"$match": {"x": 1}}, ` +
+					`{"$lookup": {"from": {"db": "t1_other", "coll": "c"}, "as": "joined", ` +
+					`"pipeline": [{"$graphLookup": {"from": {"db": "t1_other", "coll": "g"}}}]}}], "$db": "t1_db"}`,
+			},
+			{
+				name:     "create collection",
+				input:    `{"create": "coll", "capped": true, "size": 1024, "$db": "db"}`,
+				expected: `{"create": "coll", "capped": true, "size": 1024, "$db": "t1_db"}`,
+			},
+			{
+				name: "collMod view",
+				input: `{"collMod": "view", "viewOn": "coll", ` +
+					`"pipeline": [{"$unionWith": {"coll": "c", "pipeline": [{"$lookup": {"from": "same"}}]}}, ` +
+					`{"$facet": {"a": [{"$lookup": {"from": {"db": "other", "coll": "c"}}}]}}], "$db": "db"}`,
+				expected: `{"collMod": "view", "viewOn": "coll", ` +
+					`"pipeline": [{"$unionWith": {"coll": "c", "pipeline": [{"$lookup": {"from": "same"}}]}}, ` +
+					`{"$facet": {"a": [{"$lookup": {"from": {"db": "t1_other", "coll": "c"}}}]}}], "$db": "t1_db"}`,
+			},
+			{
+				name:   "non-array pipeline",
+				input:  `{"create": "view", "viewOn": "coll", "pipeline": {}, "$db": "db"}`,
+				errMsg: "expected value for key pipeline to be array",
+			},
+		})
+	})
+	t.Run("listCollections response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "listCollections", []fixerTestCase{
+			{
+				name: "collections and views",
+				input: `{"cursor": {"id": {"$numberLong": "0"}, "ns": "t1_db.$cmd.listCollections", "firstBatch": [` +
+					`{"name": "coll", "type": "collection", "options": {}, ` +
+					`"idIndex": {"v": 2, "key": {"_id": 1}, "name": "_id_", "ns": "t1_db.coll"}}, ` +
+					`{"name": "view", "type": "view", "options": {"viewOn": "coll", ` +
+					`"pipeline": [{"$lookup": {"from": {"db": "t1_other", "coll": "c"}}}]}}]}, "ok": 1}`,
+				expected: `{"cursor": {"id": {"$numberLong": "0"}, "ns": "db.$cmd.listCollections", "firstBatch": [` +
+					`{"name": "coll", "type": "collection", "options": {}, ` +
+					`"idIndex": {"v": 2, "key": {"_id": 1}, "name": "_id_", "ns": "db.coll"}}, ` +
+					`{"name": "view", "type": "view", "options": {"viewOn": "coll", ` +
+					`"pipeline": [{"$lookup": {"from": {"db": "other", "coll": "c"}}}]}}]}, "ok": 1}`,
+			},
+		})
+	})
+}
+
+func TestMapReduceFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	t.Run("request", func(t *testing.T) {
+		runRequestFixerTests(t, p, []fixerTestCase{
+			{
+				name:     "collection output",
+				input:    `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": "results", "$db": "db"}`,
+				expected: `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": "results", "$db": "t1_db"}`,
+			},
+			{
+				name:     "inline output",
+				input:    `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": {"inline": 1}, "$db": "db"}`,
+				expected: `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": {"inline": 1}, "$db": "t1_db"}`,
+			},
+			{
+				name:     "output database",
+				input:    `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": {"merge": "c", "db": "out"}, "$db": "db"}`,
+				expected: `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": {"merge": "c", "db": "t1_out"}, "$db": "t1_db"}`,
+			},
+			{
+				name:     "output in command database",
+				input:    `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": {"replace": "c"}, "$db": "db"}`,
+				expected: `{"mapReduce": "coll", "map": "m", "reduce": "r", "out": {"replace": "c"}, "$db": "t1_db"}`,
+			},
+		})
+	})
+	t.Run("response", func(t *testing.T) {
+		runResponseFixerTests(t, p, "mapReduce", []fixerTestCase{
+			{
+				name:     "collection result",
+				input:    `{"result": "results", "ok": 1}`,
+				expected: `{"result": "results", "ok": 1}`,
+			},
+			{
+				name:     "database result",
+				input:    `{"result": {"db": "t1_out", "collection": "c"}, "ok": 1}`,
+				expected: `{"result": {"db": "out", "collection": "c"}, "ok": 1}`,
+			},
+			{
+				name:     "inline results",
+				input:    `{"results": [{"_id": "t1_a", "value": {"db": "t1_b"}}], "ok": 1}`,
+				expected: `{"results": [{"_id": "t1_a", "value": {"db": "t1_b"}}], "ok": 1}`,
+			},
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package command
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// SessionMapper is implemented by types that translate logical session IDs between the values used by clients and the
+// values sent to the server.
+type SessionMapper interface {
+	// ServerID returns the server session ID for the given client session ID, creating a new one if necessary.
+	ServerID(clientID []byte) []byte
+	// ClientID returns the client session ID for the given server session ID.
+	ClientID(serverID []byte) []byte
+	// Owns reports whether the given server session ID was created for or returned to one of the mapper's clients.
+	Owns(serverID []byte) bool
+}
+
+// newSessionIDValueFixer creates a ValueFixer for session ID values. The provided mapFn is used to translate the ID.
+func newSessionIDValueFixer(mapFn func([]byte) []byte) ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		subtype, id, ok := val.BinaryOK()
+		if !ok {
+			return nil, fmt.Errorf("expected session ID value to be binary, got %s", val.Type)
+		}
+
+		dst = bsoncore.AppendBinaryElement(dst, string(key), subtype, mapFn(id))
+		return dst, nil
+	}
+}
+
+// newLsidRequestFixer creates a ValueFixer for lsid documents in requests, which are in the form {id: <UUID>}.
+func newLsidRequestFixer(sessions SessionMapper) DocumentFixer {
+	return DocumentFixer{
+		"id": newSessionIDValueFixer(sessions.ServerID),
+	}
+}
+
+// newLsidResponseFixer creates a ValueFixer for lsid documents in responses.
+func newLsidResponseFixer(sessions SessionMapper) DocumentFixer {
+	return DocumentFixer{
+		"id": newSessionIDValueFixer(sessions.ClientID),
+	}
+}
+
+// newNonEmptyArrayValueFixer creates a ValueFixer that rejects empty arrays and fixes all other values using vf. It is
+// used for killSessions, where an empty array means every session.
+func newNonEmptyArrayValueFixer(vf ValueFixer) ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		if arr, ok := val.ArrayOK(); ok {
+			if _, err := arr.IndexErr(0); err != nil {
+				return nil, fmt.Errorf("%s requires at least one session", key)
+			}
+		}
+		return vf.fixValue(val, key, dst)
+	}
+}
@@ -3,58 +3,131 @@ package command
 import (
 	"bytes"
 	"fmt"
+	"regexp"
 
 	"github.com/divjotarora/proxy/bsonutil"
 	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
 )
 
 var (
-	// noopDatabaseNames contains names of databases that should be proxied without fixing.
+	// noopDatabaseNames contains names of databases that should be proxied without fixing for commands that operate on
+	// the whole server.
 	noopDatabaseNames = map[string]struct{}{
 		"admin": {},
 	}
+
+	// adminCommands contains the names of commands that must be run against the server's real admin database. All
+	// other commands that target admin are run against the tenant's own prefixed admin database.
+	adminCommands = map[string]struct{}{
+		"abortTransaction":  {},
+		"buildInfo":         {},
+		"commitTransaction": {},
+		"connPoolStats":     {},
+		"currentOp":         {},
+		"endSessions":       {},
+		"fsync":             {},
+		"fsyncUnlock":       {},
+		"getCmdLineOpts":    {},
+		"getLog":            {},
+		"getParameter":      {},
+		"hello":             {},
+		"hostInfo":          {},
+		"killOp":            {},
+		"killSessions":      {},
+		"listCommands":      {},
+		"logRotate":         {},
+		"ping":              {},
+		"refreshSessions":   {},
+		"renameCollection":  {},
+		"replSetGetStatus":  {},
+		"serverStatus":      {},
+		"startSession":      {},
+		"top":               {},
+	}
 )
 
-// ValueFixerFunc to add the database name prefix in requests.
-var addDBPrefixValueFixer ValueFixerFunc = func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
-	db, ok := val.StringValueOK()
-	if !ok {
-		return nil, fmt.Errorf("expected $db value to be string, got %s", val.Type)
+// AdminCommands returns the names of the commands that are run against the server's real admin database rather than
+// the tenant's prefixed admin database.
+func AdminCommands() []string {
+	names := make([]string, 0, len(adminCommands))
+	for name := range adminCommands {
+		names = append(names, name)
 	}
+	return names
+}
+
+// newAddDBPrefixValueFixer creates a ValueFixer to add the database name prefix in requests. Databases in noopDBs are
+// not prefixed.
+func newAddDBPrefixValueFixer(prefix string, noopDBs map[string]struct{}) ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		db, ok := val.StringValueOK()
+		if !ok {
+			return nil, fmt.Errorf("expected $db value to be string, got %s", val.Type)
+		}
 
-	fixedDB := db
-	if _, ok := noopDatabaseNames[db]; !ok {
-		fixedDB = fmt.Sprintf("fixed%s", db)
+		fixedDB := db
+		if _, ok := noopDBs[db]; !ok {
+			fixedDB = prefix + db
+		}
+		dst = bsoncore.AppendStringElement(dst, string(key), fixedDB)
+		return dst, nil
 	}
-	dst = bsoncore.AppendStringElement(dst, string(key), fixedDB)
-	return dst, nil
 }
 
-// ValueFixerFunc to remove the database name prefix in responsnes.
-var removeDBPrefixValueFixer ValueFixerFunc = func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
-	db, ok := bsonutil.ValueToByteSlice(val)
-	if !ok {
-		return nil, fmt.Errorf("expected $db value to be string, got %s", val.Type)
+// newAddNamespacePrefixValueFixer creates a ValueFixer to add the database name prefix to a full namespace
+// ("db.coll") in requests.
+func newAddNamespacePrefixValueFixer(prefix string) ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		ns, ok := val.StringValueOK()
+		if !ok {
+			return nil, fmt.Errorf("expected namespace value to be string, got %s", val.Type)
+		}
+
+		dst = bsoncore.AppendStringElement(dst, string(key), prefix+ns)
+		return dst, nil
 	}
+}
+
+// newRemoveDBPrefixValueFixer creates a ValueFixer to remove the database name prefix in responses.
+func newRemoveDBPrefixValueFixer(prefix string) ValueFixerFunc {
+	prefixBytes := []byte(prefix)
+
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		db, ok := bsonutil.ValueToByteSlice(val)
+		if !ok {
+			return nil, fmt.Errorf("expected $db value to be string, got %s", val.Type)
+		}
 
-	fixedDB := db
-	if _, ok := noopDatabaseNames[string(db)]; !ok {
-		fixedDB = db[5:] // remove "fixed" prefix
+		fixedDB := db
+		if _, ok := noopDatabaseNames[string(db)]; !ok {
+			fixedDB = bytes.TrimPrefix(db, prefixBytes)
+		}
+		dst = bsoncore.AppendStringElement(dst, string(key), string(fixedDB))
+		return dst, nil
 	}
-	dst = bsoncore.AppendStringElement(dst, string(key), string(fixedDB))
-	return dst, nil
 }
 
-// ValueFixer implementation to remove the database name prefix from messages in the writeErrors array in responses.
-var writeErrorsValueFixer ValueFixer = newArrayValueFixer(DocumentFixer{
-	"errmsg": ValueFixerFunc(func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+// newErrmsgValueFixer creates a ValueFixer to remove the database name prefix from namespaces in error messages in
+// responses. The prefix is only removed where it starts a word so other text that happens to contain it is not changed.
+func newErrmsgValueFixer(prefix string) ValueFixerFunc {
+	prefixRegex := regexp.MustCompile(`(^|[^A-Za-z0-9_$])` + regexp.QuoteMeta(prefix))
+
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
 		errmsg, ok := bsonutil.ValueToByteSlice(val)
 		if !ok {
 			return dst, fmt.Errorf("expected errmsg value to be of type string, got %s", val.Type)
 		}
 
-		fixedErrMsg := bytes.ReplaceAll(errmsg, []byte("fixed"), []byte(""))
+		fixedErrMsg := prefixRegex.ReplaceAll(errmsg, []byte("$1"))
 		dst = bsoncore.AppendStringElement(dst, string(key), string(fixedErrMsg))
 		return dst, nil
-	}),
-})
+	}
+}
+
+// newWriteErrorsValueFixer creates a ValueFixer to remove the database name prefix from messages in the writeErrors
+// array in responses.
+func newWriteErrorsValueFixer(prefix string) ValueFixer {
+	return newArrayValueFixer(DocumentFixer{
+		"errmsg": newErrmsgValueFixer(prefix),
+	})
+}
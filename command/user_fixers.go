@@ -0,0 +1,74 @@
+package command
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// newOptionalDocumentValueFixer creates a ValueFixer that applies the provided DocumentFixer to document values and
+// copies all other values without modification. This is used for values such as role references, which can either be
+// a role name string or a {role, db} document.
+func newOptionalDocumentValueFixer(df DocumentFixer) ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		if _, ok := val.DocumentOK(); !ok {
+			return bsoncore.AppendValueElement(dst, string(key), val), nil
+		}
+		return df.fixValue(val, key, dst)
+	}
+}
+
+// newUserOrRoleSelectorFixer creates a ValueFixer for the command value of usersInfo and rolesInfo, which can be a
+// name, a {user|role, db} document, an array of names and documents, or 1.
+func newUserOrRoleSelectorFixer(df DocumentFixer) ValueFixerFunc {
+	elemFixer := newOptionalDocumentValueFixer(df)
+	arrayFixer := newArrayValueFixer(elemFixer)
+
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		if _, ok := val.ArrayOK(); ok {
+			return arrayFixer.fixValue(val, key, dst)
+		}
+		return elemFixer(val, key, dst)
+	}
+}
+
+// newResourceRequestFixer creates a ValueFixer for privilege resource documents in requests, which are in the form
+// {db: <db>, collection: <coll>}. The db value is prefixed. Resources that span databases, such as {cluster: true},
+// {anyResource: true}, or an empty db, are rejected because they would grant access to other tenants' data.
+func newResourceRequestFixer(addDBPrefix ValueFixer) ValueFixerFunc {
+	fixer := DocumentFixer{
+		"db": addDBPrefix,
+	}
+
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		resource, ok := val.DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("expected resource value to be document, got %s", val.Type)
+		}
+		if _, err := resource.LookupErr("cluster"); err == nil {
+			return nil, fmt.Errorf("privileges on the cluster resource cannot be granted")
+		}
+		if _, err := resource.LookupErr("anyResource"); err == nil {
+			return nil, fmt.Errorf("privileges on anyResource cannot be granted")
+		}
+		if db, _ := resource.Lookup("db").StringValueOK(); db == "" {
+			return nil, fmt.Errorf("privilege resources must name a database")
+		}
+
+		return fixer.fixValue(val, key, dst)
+	}
+}
+
+// newForbiddenFlagValueFixer creates a ValueFixer that rejects requests where the value is true. It is used for
+// options such as the forAllDBs field of usersInfo selectors, which would return information about all tenants.
+func newForbiddenFlagValueFixer() ValueFixerFunc {
+	return func(val bsoncore.Value, key []byte, dst bsoncore.Document) (bsoncore.Document, error) {
+		if n, ok := val.AsInt64OK(); ok && n != 0 {
+			return nil, fmt.Errorf("%s is not supported", key)
+		}
+		if b, ok := val.BooleanOK(); ok && b {
+			return nil, fmt.Errorf("%s is not supported", key)
+		}
+		return bsoncore.AppendValueElement(dst, string(key), val), nil
+	}
+}
@@ -0,0 +1,128 @@
+package command
+
+import "testing"
+
+func TestUserRequestFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	runRequestFixerTests(t, p, []fixerTestCase{
+		{
+			name: "createUser roles",
+			input: `{"createUser": "alice", "pwd": "pwd", "roles": ["read", {"role": "readWrite", "db": "other"}], ` +
+				`"$db": "db"}`,
+			expected: `{"createUser": "alice", "pwd": "pwd", ` +
+				`"roles": ["read", {"role": "readWrite", "db": "t1_other"}], "$db": "t1_db"}`,
+		},
+		{
+			name:     "grantRolesToRole roles",
+			input:    `{"grantRolesToRole": "r", "roles": [{"role": "read", "db": "other"}], "$db": "db"}`,
+			expected: `{"grantRolesToRole": "r", "roles": [{"role": "read", "db": "t1_other"}], "$db": "t1_db"}`,
+		},
+		{
+			name: "createRole privileges",
+			input: `{"createRole": "r", "privileges": [{"resource": {"db": "other", "collection": ""}, ` +
+				`"actions": ["find"]}], "roles": [], "$db": "db"}`,
+			expected: `{"createRole": "r", "privileges": [{"resource": {"db": "t1_other", "collection": ""}, ` +
+				`"actions": ["find"]}], "roles": [], "$db": "t1_db"}`,
+		},
+		{
+			name: "grantPrivilegesToRole cluster resource",
+			input: `{"grantPrivilegesToRole": "r", "privileges": [{"resource": {"cluster": true}, ` +
+				`"actions": ["shutdown"]}], "$db": "db"}`,
+			errMsg: "privileges on the cluster resource cannot be granted",
+		},
+		{
+			name: "grantPrivilegesToRole anyResource",
+			input: `{"grantPrivilegesToRole": "r", "privileges": [{"resource": {"anyResource": true}, ` +
+				`"actions": ["find"]}], "$db": "db"}`,
+			errMsg: "privileges on anyResource cannot be granted",
+		},
+		{
+			name: "revokePrivilegesFromRole empty db",
+			input: `{"revokePrivilegesFromRole": "r", "privileges": [{"resource": {"db": "", "collection": "c"}, ` +
+				`"actions": ["find"]}], "$db": "db"}`,
+			errMsg: "privilege resources must name a database",
+		},
+		{
+			name:     "usersInfo name",
+			input:    `{"usersInfo": "alice", "$db": "db"}`,
+			expected: `{"usersInfo": "alice", "$db": "t1_db"}`,
+		},
+		{
+			name:     "usersInfo document",
+			input:    `{"usersInfo": {"user": "alice", "db": "other"}, "$db": "db"}`,
+			expected: `{"usersInfo": {"user": "alice", "db": "t1_other"}, "$db": "t1_db"}`,
+		},
+		{
+			name:     "usersInfo array",
+			input:    `{"usersInfo": ["alice", {"user": "bob", "db": "other"}], "$db": "db"}`,
+			expected: `{"usersInfo": ["alice", {"user": "bob", "db": "t1_other"}], "$db": "t1_db"}`,
+		},
+		{
+			name:     "usersInfo all users",
+			input:    `{"usersInfo": 1, "$db": "db"}`,
+			expected: `{"usersInfo": 1, "$db": "t1_db"}`,
+		},
+		{
+			name:   "usersInfo forAllDBs",
+			input:  `{"usersInfo": {"forAllDBs": true}, "$db": "admin"}`,
+			errMsg: "forAllDBs is not supported",
+		},
+		{
+			name:     "usersInfo forAllDBs false",
+			input:    `{"usersInfo": {"forAllDBs": false}, "$db": "admin"}`,
+			expected: `{"usersInfo": {"forAllDBs": false}, "$db": "t1_admin"}`,
+		},
+		{
+			name:     "rolesInfo document",
+			input:    `{"rolesInfo": [{"role": "r", "db": "other"}], "showPrivileges": true, "$db": "db"}`,
+			expected: `{"rolesInfo": [{"role": "r", "db": "t1_other"}], "showPrivileges": true, "$db": "t1_db"}`,
+		},
+	})
+}
+
+func TestUserResponseFixers(t *testing.T) {
+	p := NewParser(ParserOptions{Prefix: testPrefix})
+
+	t.Run("usersInfo", func(t *testing.T) {
+		runResponseFixerTests(t, p, "usersInfo", []fixerTestCase{
+			{
+				name: "users",
+				input: `{"users": [{"_id": "t1_db.alice", "user": "alice", "db": "t1_db", ` +
+					`"roles": [{"role": "read", "db": "t1_other"}], ` +
+					`"inheritedRoles": [{"role": "read", "db": "t1_other"}], ` +
+					`"inheritedPrivileges": [{"resource": {"db": "t1_other", "collection": ""}, ` +
+					`"actions": ["find"]}]}], "ok": 1}`,
+				expected: `{"users": [{"_id": "db.alice", "user": "alice", "db": "db", ` +
+					`"roles": [{"role": "read", "db": "other"}], ` +
+					`"inheritedRoles": [{"role": "read", "db": "other"}], ` +
+					`"inheritedPrivileges": [{"resource": {"db": "other", "collection": ""}, ` +
+					`"actions": ["find"]}]}], "ok": 1}`,
+			},
+			{
+				name:     "no users",
+				input:    `{"users": [], "ok": 1}`,
+				expected: `{"users": [], "ok": 1}`,
+			},
+		})
+	})
+	t.Run("rolesInfo", func(t *testing.T) {
+		runResponseFixerTests(t, p, "rolesInfo", []fixerTestCase{
+			{
+				name: "roles",
+				input: `{"roles": [{"role": "r", "db": "t1_db", "isBuiltin": false, ` +
+					`"roles": [{"role": "read", "db": "t1_other"}], ` +
+					`"inheritedRoles": [{"role": "read", "db": "t1_other"}], ` +
+					`"privileges": [{"resource": {"db": "t1_db", "collection": "c"}, "actions": ["find"]}], ` +
+					`"inheritedPrivileges": [{"resource": {"db": "t1_other", "collection": ""}, ` +
+					`"actions": ["find"]}]}], "ok": 1}`,
+				expected: `{"roles": [{"role": "r", "db": "db", "isBuiltin": false, ` +
+					`"roles": [{"role": "read", "db": "other"}], ` +
+					`"inheritedRoles": [{"role": "read", "db": "other"}], ` +
+					`"privileges": [{"resource": {"db": "db", "collection": "c"}, "actions": ["find"]}], ` +
+					`"inheritedPrivileges": [{"resource": {"db": "other", "collection": ""}, ` +
+					`"actions": ["find"]}]}], "ok": 1}`,
+			},
+		})
+	})
+}
@@ -5,25 +5,51 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
 
 	"github.com/divjotarora/proxy/mongo/mongowire"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
 )
 
 var (
 	// ErrClientHungUp is returned when a client closes the connection.
 	ErrClientHungUp = errors.New("client hung up the connection")
+
+	globalConnectionID uint64
 )
 
+// WriteError is returned when a wire message cannot be written to the client. The connection should not be used after
+// a WriteError.
+type WriteError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e WriteError) Error() string {
+	return fmt.Sprintf("error writing to client: %v", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e WriteError) Unwrap() error {
+	return e.Err
+}
+
 // Connection represents a network connection between a client and the proxy.
 type Connection struct {
 	net.Conn
+	id uint64
+
+	// lastError is the getLastError reply describing the result of the last legacy write on the connection. Requests
+	// on a connection are handled one at a time, so it does not need to be synchronized.
+	lastError bsoncore.Document
 }
 
 // NewConn creates a new Conn instance wrapping the underlying net.Conn. This function performs all handshake commands
 // necessary to initialize the connection.
 func NewConn(nc net.Conn) (*Connection, error) {
 	c := &Connection{
-		nc,
+		Conn: nc,
+		id:   atomic.AddUint64(&globalConnectionID, 1),
 	}
 
 	if err := c.handshake(); err != nil {
@@ -32,6 +58,21 @@ func NewConn(nc net.Conn) (*Connection, error) {
 	return c, nil
 }
 
+// ID returns a unique identifier for the connection.
+func (c *Connection) ID() uint64 {
+	return c.id
+}
+
+// LastError returns the getLastError reply recorded by the last call to SetLastError, or nil if there is none.
+func (c *Connection) LastError() bsoncore.Document {
+	return c.lastError
+}
+
+// SetLastError records the getLastError reply for a legacy write on the connection.
+func (c *Connection) SetLastError(doc bsoncore.Document) {
+	c.lastError = doc
+}
+
 // ReadWireMessage reads the next wire message from the client. If the connection is closed by the client while
 // reading the message, ErrClientHungUp is returned.
 func (c *Connection) ReadWireMessage(buf []byte) ([]byte, error) {
@@ -63,10 +104,13 @@ func (c *Connection) ReadWireMessage(buf []byte) ([]byte, error) {
 	return buffer, nil
 }
 
-// WriteWireMessage writes the given wire message to the client.
+// WriteWireMessage writes the given wire message to the client. If the message cannot be written, a WriteError is
+// returned.
 func (c *Connection) WriteWireMessage(buf []byte) error {
-	_, err := c.Write(buf)
-	return err
+	if _, err := c.Write(buf); err != nil {
+		return WriteError{err}
+	}
+	return nil
 }
 
 func (c *Connection) handshake() error {
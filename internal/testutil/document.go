@@ -0,0 +1,24 @@
+// Package testutil contains helpers shared by the proxy's tests.
+package testutil
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Document creates a document from a relaxed extended JSON string. Key order is preserved.
+func Document(t testing.TB, extJSON string) bsoncore.Document {
+	t.Helper()
+
+	var d bson.D
+	if err := bson.UnmarshalExtJSON([]byte(extJSON), false, &d); err != nil {
+		t.Fatalf("error unmarshalling %s: %v", extJSON, err)
+	}
+	doc, err := bson.Marshal(d)
+	if err != nil {
+		t.Fatalf("error marshalling %s: %v", extJSON, err)
+	}
+	return doc
+}
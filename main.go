@@ -2,7 +2,6 @@ package main
 
 import (
 	"github.com/divjotarora/proxy/proxy"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -12,8 +11,7 @@ var (
 )
 
 func main() {
-	clientOpts := options.Client().ApplyURI(mongoURI)
-	proxy, err := proxy.NewProxy(network, addresss, clientOpts)
+	proxy, err := proxy.NewProxy(network, addresss, mongoURI, nil)
 	if err != nil {
 		panic(err)
 	}
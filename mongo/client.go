@@ -2,68 +2,269 @@ package mongo
 
 import (
 	"context"
-	"reflect"
-	"unsafe"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/address"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/description"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
-// Client represents a direct connection to a MongoDB server. This is a long-lived type and is safe for concurrent use.
+var (
+	defaultHealthCheckInterval = 10 * time.Second
+
+	pingCommand = bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendInt32Element(nil, "ping", 1),
+		bsoncore.AppendStringElement(nil, "$db", "admin"),
+	)
+)
+
+// Options configures a Client.
+type Options struct {
+	// MaxPoolSize is the maximum number of connections to each server. If zero, the maxPoolSize value from the URI is
+	// used, which defaults to 100.
+	MaxPoolSize uint64
+	// MinPoolSize is the number of connections to each server that are kept open even if they are idle. If non-zero,
+	// these connections are established in the background when the client is created so requests do not have to
+	// wait for connection establishment. If zero, the minPoolSize value from the URI is used.
+	MinPoolSize uint64
+	// MaxConnIdleTime is the maximum amount of time a connection can be idle in the pool before it is closed. If zero,
+	// the maxIdleTimeMS value from the URI is used.
+	MaxConnIdleTime time.Duration
+	// HealthCheckInterval is how often the client pings the server using a pooled connection. Failed pings cause the
+	// server to be re-checked immediately. If zero, a default of 10 seconds is used. If negative, health checks are
+	// disabled.
+	HealthCheckInterval time.Duration
+}
+
+// Client represents a connection to a MongoDB deployment. This is a long-lived type and is safe for concurrent use.
 type Client struct {
-	client *mongo.Client
-	server driver.Server
+	topology *topology.Topology
+	opts     Options
+	done     chan struct{}
+	wg       sync.WaitGroup
 }
 
-// NewClient creates a new Client instance.
-func NewClient(ctx context.Context, opts *options.ClientOptions) (*Client, error) {
-	client, err := mongo.Connect(ctx, opts)
+// NewClient creates a new Client instance for the deployment at the given URI. The opts parameter can be nil to use the
+// default options.
+func NewClient(ctx context.Context, uri string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	cs, err := connstring.Parse(uri)
 	if err != nil {
 		return nil, err
 	}
 
-	topo := extractTopology(client)
-	server, err := topo.SelectServer(ctx, description.ReadPrefSelector(readpref.Primary()))
+	topo, err := topology.New(
+		topology.WithConnString(func(connstring.ConnString) connstring.ConnString { return cs }),
+		topology.WithServerOptions(func(serverOpts ...topology.ServerOption) []topology.ServerOption {
+			return append(serverOpts, poolServerOptions(opts)...)
+		}),
+	)
 	if err != nil {
-		// Use context.Background to ensure client is properly disconnected even if ctx has expired.
-		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+	if err := topo.Connect(); err != nil {
+		return nil, err
+	}
+
+	// Wait for a primary to be discovered so errors connecting to the deployment are reported immediately.
+	if _, err := topo.SelectServer(ctx, description.ReadPrefSelector(readpref.Primary())); err != nil {
+		// Use context.Background to ensure the topology is properly disconnected even if ctx has expired.
+		_ = topo.Disconnect(context.Background())
 		return nil, err
 	}
 
 	c := &Client{
-		client: client,
-		server: server,
+		topology: topo,
+		opts:     *opts,
+		done:     make(chan struct{}),
 	}
+	c.start()
 	return c, nil
 }
 
+// poolServerOptions converts the connection pool settings in opts to topology.ServerOption values. Only settings that
+// have been explicitly set are converted so they do not override values from the URI.
+func poolServerOptions(opts *Options) []topology.ServerOption {
+	var serverOpts []topology.ServerOption
+
+	if opts.MaxPoolSize != 0 {
+		serverOpts = append(serverOpts, topology.WithMaxConnections(func(uint64) uint64 { return opts.MaxPoolSize }))
+	}
+	if opts.MinPoolSize != 0 {
+		serverOpts = append(serverOpts, topology.WithMinConnections(func(uint64) uint64 { return opts.MinPoolSize }))
+	}
+	if opts.MaxConnIdleTime != 0 {
+		idleTimeout := topology.WithIdleTimeout(func(time.Duration) time.Duration { return opts.MaxConnIdleTime })
+		serverOpts = append(serverOpts, topology.WithConnectionOptions(
+			func(connOpts ...topology.ConnectionOption) []topology.ConnectionOption {
+				return append(connOpts, idleTimeout)
+			},
+		))
+	}
+	return serverOpts
+}
+
+// start launches the client's background routines.
+func (c *Client) start() {
+	if c.opts.MinPoolSize != 0 {
+		c.wg.Add(1)
+		go c.warmUp()
+	}
+
+	interval := c.opts.HealthCheckInterval
+	if interval == 0 {
+		interval = defaultHealthCheckInterval
+	}
+	if interval > 0 {
+		c.wg.Add(1)
+		go c.healthCheck(interval)
+	}
+}
+
+// warmUp establishes MinPoolSize connections in parallel and returns them to the pool. The pool would eventually
+// create them on its own, but only after its first maintenance interval.
+func (c *Client) warmUp() {
+	defer c.wg.Done()
+
+	conns := make([]*Conn, 0, c.opts.MinPoolSize)
+	for i := uint64(0); i < c.opts.MinPoolSize; i++ {
+		conn, err := c.Checkout(context.Background(), nil)
+		if err != nil {
+			log.Printf("error warming up connection pool: %v\n", err)
+			break
+		}
+		conns = append(conns, conn)
+	}
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// healthCheck pings the server every interval until the client is disconnected.
+func (c *Client) healthCheck(interval time.Duration) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		_, err := c.RunCommand(ctx, pingCommand)
+		cancel()
+		if err != nil {
+			log.Printf("health check failed: %v\n", err)
+			c.topology.RequestImmediateCheck()
+		}
+	}
+}
+
 // Disconnect closes open connections to the MongoDB server and cleans up any remaining resources.
 func (c *Client) Disconnect(ctx context.Context) error {
-	return c.client.Disconnect(ctx)
+	close(c.done)
+	c.wg.Wait()
+	return c.topology.Disconnect(ctx)
+}
+
+// Kind returns the kind of the topology the client is connected to.
+func (c *Client) Kind() description.TopologyKind {
+	return c.topology.Kind()
+}
+
+// Checkout selects a server using the given read preference and checks a connection to it out of the pool. If rp is
+// nil, the primary is selected. Servers are selected for every checkout, so a primary that has stepped down will not be
+// selected once the topology has been updated. The returned Conn must be closed to return it to the pool.
+func (c *Client) Checkout(ctx context.Context, rp *readpref.ReadPref) (*Conn, error) {
+	if rp == nil {
+		rp = readpref.Primary()
+	}
+
+	server, err := c.topology.SelectServer(ctx, description.ReadPrefSelector(rp))
+	if err != nil {
+		return nil, err
+	}
+	return newConn(ctx, server)
 }
 
-// RoundTrip sends a wire message to the underlying MongoDB server and returns the server's response.
+// CheckoutAddress checks out a connection to the server with the given address. This should be used for requests
+// that must go to a specific server, such as getMore requests for a cursor. The returned Conn must be closed to return
+// it to the pool.
+func (c *Client) CheckoutAddress(ctx context.Context, addr address.Address) (*Conn, error) {
+	for _, desc := range c.topology.Description().Servers {
+		if desc.Addr != addr {
+			continue
+		}
+
+		server, err := c.topology.FindServer(desc)
+		if err != nil {
+			return nil, err
+		}
+		if server != nil {
+			return newConn(ctx, server)
+		}
+	}
+	return nil, fmt.Errorf("server %s is no longer part of the topology", addr)
+}
+
+// RoundTrip sends a wire message to the primary and returns the server's response.
 func (c *Client) RoundTrip(ctx context.Context, msg []byte) ([]byte, error) {
-	conn, err := c.server.Connection(ctx)
+	conn, err := c.Checkout(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
-	if err := conn.WriteWireMessage(ctx, msg); err != nil {
+	return conn.RoundTrip(ctx, msg)
+}
+
+// RunCommand sends the provided command document to the primary and returns the reply document. The
+// command document must include a $db field. If the server reports that the command failed, a CommandError is
+// returned.
+func (c *Client) RunCommand(ctx context.Context, cmd bsoncore.Document) (bsoncore.Document, error) {
+	conn, err := c.Checkout(ctx, nil)
+	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
 
-	return conn.ReadWireMessage(ctx, nil)
+	return conn.RunCommand(ctx, cmd)
 }
 
-func extractTopology(c *mongo.Client) *topology.Topology {
-	e := reflect.ValueOf(c).Elem()
-	d := e.FieldByName("deployment")
-	d = reflect.NewAt(d.Type(), unsafe.Pointer(d.UnsafeAddr())).Elem() // #nosec G103
-	return d.Interface().(*topology.Topology)
+// Send sends a wire message to the primary without waiting for a reply. This should only be used for
+// messages that have the moreToCome flag set, as the server does not reply to those.
+func (c *Client) Send(ctx context.Context, msg []byte) error {
+	conn, err := c.Checkout(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Send(ctx, msg)
+}
+
+// Stream sends a wire message to the primary and calls handler for every reply in the response
+// stream. The same connection is used until the stream ends. See Conn.Stream for more details.
+func (c *Client) Stream(ctx context.Context, msg []byte, handler ReplyHandler) error {
+	conn, err := c.Checkout(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Stream(ctx, msg, handler)
 }
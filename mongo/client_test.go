@@ -0,0 +1,28 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoolServerOptions(t *testing.T) {
+	testCases := []struct {
+		name    string
+		opts    Options
+		numOpts int
+	}{
+		{"no settings", Options{}, 0},
+		{"health check only", Options{HealthCheckInterval: time.Second}, 0},
+		{"max pool size", Options{MaxPoolSize: 10}, 1},
+		{"min pool size", Options{MinPoolSize: 5}, 1},
+		{"max idle time", Options{MaxConnIdleTime: time.Minute}, 1},
+		{"all settings", Options{MaxPoolSize: 10, MinPoolSize: 5, MaxConnIdleTime: time.Minute}, 3},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := len(poolServerOptions(&tc.opts)); got != tc.numOpts {
+				t.Fatalf("option count mismatch; got %d, want %d", got, tc.numOpts)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/divjotarora/proxy/mongo/mongowire"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/address"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+var (
+	// ErrConnClosed is returned when a Conn is used after it has been closed.
+	ErrConnClosed = errors.New("connection is closed")
+)
+
+// ReplyHandler is called for each reply in a stream of replies from the server.
+type ReplyHandler func(reply []byte) error
+
+// Conn represents a single connection to a MongoDB server that has been checked out of a Client's connection pool. It
+// can be used to pin a sequence of messages to the same connection. Conn is not safe for concurrent use.
+type Conn struct {
+	conn   driver.Connection
+	server driver.Server
+	addr   address.Address
+}
+
+func newConn(ctx context.Context, server driver.Server) (*Conn, error) {
+	conn, err := server.Connection(ctx)
+	if err != nil {
+		processError(server, err)
+		return nil, err
+	}
+
+	c := &Conn{
+		conn:   conn,
+		server: server,
+		addr:   conn.Description().Addr,
+	}
+	return c, nil
+}
+
+// Address returns the address of the server the connection is connected to.
+func (c *Conn) Address() address.Address {
+	return c.addr
+}
+
+// RoundTrip sends a wire message on the connection and returns the server's response.
+func (c *Conn) RoundTrip(ctx context.Context, msg []byte) ([]byte, error) {
+	var reply []byte
+	err := c.Stream(ctx, msg, func(r []byte) error {
+		reply = r
+		return nil
+	})
+	return reply, err
+}
+
+// RunCommand sends the provided command document on the connection and returns the reply document. The command
+// document must include a $db field. If the server reports that the command failed, a CommandError is returned.
+func (c *Conn) RunCommand(ctx context.Context, cmd bsoncore.Document) (bsoncore.Document, error) {
+	replyBytes, err := c.RoundTrip(ctx, mongowire.NewCommand(cmd).Encode())
+	if err != nil {
+		return nil, err
+	}
+	reply, err := mongowire.Decode(replyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	replyDoc := reply.CommandDocument()
+	if err := extractCommandError(replyDoc); err != nil {
+		return nil, err
+	}
+	return replyDoc, nil
+}
+
+// Send sends a wire message on the connection without waiting for a reply. This should only be used for messages that
+// have the moreToCome flag set, as the server does not reply to those.
+func (c *Conn) Send(ctx context.Context, msg []byte) error {
+	if c.conn == nil {
+		return ErrConnClosed
+	}
+
+	if err := c.conn.WriteWireMessage(ctx, msg); err != nil {
+		processError(c.server, err)
+		c.expire()
+		return err
+	}
+	return nil
+}
+
+// Stream sends a wire message on the connection and calls handler for every reply in the response stream. The server
+// sends more than one reply if the request allowed exhaust and each reply before the last has the moreToCome flag set.
+// If the stream is abandoned early because of an error, the connection is closed rather than being returned to the
+// pool so no unread replies can be picked up by a future request. Any further use of the Conn will return
+// ErrConnClosed.
+func (c *Conn) Stream(ctx context.Context, msg []byte, handler ReplyHandler) error {
+	if c.conn == nil {
+		return ErrConnClosed
+	}
+
+	if err := c.stream(ctx, msg, handler); err != nil {
+		processError(c.server, err)
+		c.expire()
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) stream(ctx context.Context, msg []byte, handler ReplyHandler) error {
+	if err := c.conn.WriteWireMessage(ctx, msg); err != nil {
+		return err
+	}
+
+	for {
+		reply, err := c.conn.ReadWireMessage(ctx, nil)
+		if err != nil {
+			return err
+		}
+		processReplyError(c.server, reply)
+
+		if err := handler(reply); err != nil {
+			return err
+		}
+
+		if !wiremessage.IsMsgMoreToCome(reply) {
+			return nil
+		}
+	}
+}
+
+// Close returns the connection to the pool. It is safe to call Close multiple times.
+func (c *Conn) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// expire closes the underlying network connection so it will not be reused.
+func (c *Conn) expire() {
+	if expirable, ok := c.conn.(driver.Expirable); ok {
+		_ = expirable.Expire()
+	} else {
+		_ = c.conn.Close()
+	}
+	c.conn = nil
+}
+
+// processError reports err to the server so it can update its state. For example, a network error causes the server
+// to be marked as unknown so it will not be selected until it has been re-checked.
+func processError(server driver.Server, err error) {
+	if ep, ok := server.(driver.ErrorProcessor); ok {
+		ep.ProcessError(err)
+	}
+}
+
+// processReplyError reports "not master" and "node is recovering" errors in a server reply to the server. This causes
+// a primary that has stepped down to be marked as unknown so future requests select the new primary.
+func processReplyError(server driver.Server, reply []byte) {
+	cerr, ok := ReplyError(reply).(CommandError)
+	if !ok {
+		return
+	}
+	derr := driver.Error{
+		Code:    cerr.Code,
+		Message: cerr.Message,
+		Name:    cerr.CodeName,
+	}
+	if derr.NotMaster() || derr.NodeIsRecovering() {
+		processError(server, derr)
+	}
+}
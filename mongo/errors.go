@@ -0,0 +1,222 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/divjotarora/proxy/mongo/mongowire"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+var (
+	// retryableCodes contains the error codes for transient errors after which a command can be retried on another
+	// server. This includes "not master" and "node is recovering" errors, which are returned when a primary steps down
+	// or a server is shutting down.
+	retryableCodes = map[int32]struct{}{
+		CodeHostUnreachable:                 {},
+		CodeHostNotFound:                    {},
+		CodeNetworkTimeout:                  {},
+		CodeShutdownInProgress:              {},
+		CodePrimarySteppedDown:              {},
+		CodeSocketException:                 {},
+		CodeNotMaster:                       {},
+		CodeInterruptedAtShutdown:           {},
+		CodeInterruptedDueToReplStateChange: {},
+		CodeNotMasterNoSlaveOk:              {},
+		CodeNotMasterOrSecondary:            {},
+	}
+)
+
+// Error codes for errors generated by the proxy. These match the codes used by the server for the same conditions.
+const (
+	CodeInternalError   int32 = 1
+	CodeBadValue        int32 = 2
+	CodeHostUnreachable int32 = 6
+	CodeUnauthorized    int32 = 13
+	CodeTypeMismatch    int32 = 14
+	CodeCursorNotFound  int32 = 43
+
+	CodeIngressRequestRateLimitExceeded int32 = 462
+	CodeQuotaExceeded                   int32 = 12501
+)
+
+// Error codes for transient errors returned by the server.
+const (
+	CodeHostNotFound                    int32 = 7
+	CodeNetworkTimeout                  int32 = 89
+	CodeShutdownInProgress              int32 = 91
+	CodePrimarySteppedDown              int32 = 189
+	CodeSocketException                 int32 = 9001
+	CodeNotMaster                       int32 = 10107
+	CodeInterruptedAtShutdown           int32 = 11600
+	CodeInterruptedDueToReplStateChange int32 = 11602
+	CodeNotMasterNoSlaveOk              int32 = 13435
+	CodeNotMasterOrSecondary            int32 = 13436
+)
+
+// Error labels that can be attached to a CommandError.
+const (
+	TransientTransactionErrorLabel      = "TransientTransactionError"
+	UnknownTransactionCommitResultLabel = "UnknownTransactionCommitResult"
+	SystemOverloadedErrorLabel          = "SystemOverloadedError"
+	RetryableErrorLabel                 = "RetryableError"
+)
+
+var codeNames = map[int32]string{
+	CodeInternalError:   "InternalError",
+	CodeBadValue:        "BadValue",
+	CodeHostUnreachable: "HostUnreachable",
+	CodeUnauthorized:    "Unauthorized",
+	CodeTypeMismatch:    "TypeMismatch",
+	CodeCursorNotFound:  "CursorNotFound",
+
+	CodeIngressRequestRateLimitExceeded: "IngressRequestRateLimitExceeded",
+	CodeQuotaExceeded:                   "QuotaExceeded",
+
+	CodeHostNotFound:                    "HostNotFound",
+	CodeNetworkTimeout:                  "NetworkTimeout",
+	CodeShutdownInProgress:              "ShutdownInProgress",
+	CodePrimarySteppedDown:              "PrimarySteppedDown",
+	CodeSocketException:                 "SocketException",
+	CodeNotMaster:                       "NotMaster",
+	CodeInterruptedAtShutdown:           "InterruptedAtShutdown",
+	CodeInterruptedDueToReplStateChange: "InterruptedDueToReplStateChange",
+	CodeNotMasterNoSlaveOk:              "NotMasterNoSlaveOk",
+	CodeNotMasterOrSecondary:            "NotMasterOrSecondary",
+}
+
+// CommandError represents a failed command. It is used both for error replies returned by the server and for errors
+// generated by the proxy, which are sent to the client as error replies.
+type CommandError struct {
+	Code     int32
+	CodeName string
+	Message  string
+	Labels   []string
+}
+
+// NewCommandError creates a CommandError with the given code. The code name is filled in automatically for the codes
+// defined in this package.
+func NewCommandError(code int32, format string, args ...interface{}) CommandError {
+	return CommandError{
+		Code:     code,
+		CodeName: codeNames[code],
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// AsCommandError returns err as a CommandError. If err is not and does not wrap a CommandError, a new CommandError
+// with the given code is created using the error's message.
+func AsCommandError(err error, code int32) CommandError {
+	var cerr CommandError
+	if errors.As(err, &cerr) {
+		return cerr
+	}
+	return NewCommandError(code, "%v", err)
+}
+
+// Error implements the error interface.
+func (e CommandError) Error() string {
+	return fmt.Sprintf("(%s) %s", e.CodeName, e.Message)
+}
+
+// HasLabel returns true if the error has the given label.
+func (e CommandError) HasLabel(label string) bool {
+	for _, l := range e.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// WithLabel returns a copy of the error with the given label added.
+func (e CommandError) WithLabel(label string) CommandError {
+	if e.HasLabel(label) {
+		return e
+	}
+
+	labels := make([]string, 0, len(e.Labels)+1)
+	e.Labels = append(append(labels, e.Labels...), label)
+	return e
+}
+
+// Document returns the error reply document for the error in the format used by the server:
+// {ok: 0, errmsg: <message>, code: <code>, codeName: <code name>, errorLabels: [<labels>]}.
+func (e CommandError) Document() bsoncore.Document {
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	doc = bsoncore.AppendInt32Element(doc, "ok", 0)
+	doc = bsoncore.AppendStringElement(doc, "errmsg", e.Message)
+	doc = bsoncore.AppendInt32Element(doc, "code", e.Code)
+	doc = bsoncore.AppendStringElement(doc, "codeName", e.CodeName)
+
+	if len(e.Labels) > 0 {
+		var labelsIdx int32
+		labelsIdx, doc = bsoncore.AppendArrayElementStart(doc, "errorLabels")
+		for i, label := range e.Labels {
+			doc = bsoncore.AppendStringElement(doc, strconv.Itoa(i), label)
+		}
+		doc, _ = bsoncore.AppendArrayEnd(doc, labelsIdx)
+	}
+
+	doc, _ = bsoncore.AppendDocumentEnd(doc, idx)
+	return doc
+}
+
+// Retryable returns true if the error is transient and the command can be retried on another server.
+func (e CommandError) Retryable() bool {
+	_, ok := retryableCodes[e.Code]
+	return ok
+}
+
+// IsNetworkError returns true if err was caused by a network error while communicating with a server.
+func IsNetworkError(err error) bool {
+	var connErr topology.ConnectionError
+	return errors.As(err, &connErr) || errors.Is(err, ErrConnClosed)
+}
+
+// ReplyError returns a CommandError if the provided wire message is a reply for a command that failed, or nil
+// otherwise.
+func ReplyError(reply []byte) error {
+	msg, err := mongowire.Decode(reply)
+	if err != nil {
+		return nil
+	}
+	return extractCommandError(msg.CommandDocument())
+}
+
+// ReplyDocumentError returns a CommandError if the provided reply document is for a command that failed, or nil
+// otherwise.
+func ReplyDocumentError(reply bsoncore.Document) error {
+	return extractCommandError(reply)
+}
+
+// extractCommandError returns a CommandError if the provided reply document does not have ok: 1, or nil otherwise.
+func extractCommandError(reply bsoncore.Document) error {
+	if okVal, err := reply.LookupErr("ok"); err == nil {
+		if ok, isNumber := okVal.AsInt64OK(); isNumber && ok == 1 {
+			return nil
+		}
+	}
+
+	var cerr CommandError
+	if code, ok := reply.Lookup("code").AsInt32OK(); ok {
+		cerr.Code = code
+	}
+	if codeName, ok := reply.Lookup("codeName").StringValueOK(); ok {
+		cerr.CodeName = codeName
+	}
+	if errmsg, ok := reply.Lookup("errmsg").StringValueOK(); ok {
+		cerr.Message = errmsg
+	}
+	if labels, ok := reply.Lookup("errorLabels").ArrayOK(); ok {
+		values, _ := labels.Values()
+		for _, val := range values {
+			if label, ok := val.StringValueOK(); ok {
+				cerr.Labels = append(cerr.Labels, label)
+			}
+		}
+	}
+	return cerr
+}
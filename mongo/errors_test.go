@@ -0,0 +1,188 @@
+package mongo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+)
+
+func TestCommandErrorDocument(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  CommandError
+		want string
+	}{
+		{
+			name: "known code",
+			err:  NewCommandError(CodeQuotaExceeded, "quota of %d bytes exceeded", 10),
+			want: `{"ok": 0, "errmsg": "quota of 10 bytes exceeded", "code": 12501, "codeName": "QuotaExceeded"}`,
+		},
+		{
+			name: "unknown code",
+			err:  NewCommandError(12345, "failed"),
+			want: `{"ok": 0, "errmsg": "failed", "code": 12345, "codeName": ""}`,
+		},
+		{
+			name: "labels",
+			err: NewCommandError(CodeHostUnreachable, "no servers").
+				WithLabel(TransientTransactionErrorLabel).
+				WithLabel(RetryableErrorLabel),
+			want: `{"ok": 0, "errmsg": "no servers", "code": 6, "codeName": "HostUnreachable", ` +
+				`"errorLabels": ["TransientTransactionError", "RetryableError"]}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.err.Document()
+			if want := testutil.Document(t, tc.want); !bytes.Equal(got, want) {
+				t.Fatalf("document mismatch; got %s, want %s", got, want)
+			}
+
+			// The document must round-trip through the reply parser.
+			if parsed := ReplyDocumentError(got); !reflect.DeepEqual(parsed, tc.err) {
+				t.Fatalf("parsed error mismatch; got %#v, want %#v", parsed, tc.err)
+			}
+		})
+	}
+}
+
+func TestReplyDocumentError(t *testing.T) {
+	testCases := []struct {
+		name  string
+		reply string
+		want  error
+	}{
+		{"ok int32", `{"ok": 1}`, nil},
+		{"ok double", `{"ok": 1.0}`, nil},
+		{"ok int64", `{"ok": {"$numberLong": "1"}}`, nil},
+		{"ok 0", `{"ok": 0}`, CommandError{}},
+		{"missing ok", `{"n": 1}`, CommandError{}},
+		{"non-numeric ok", `{"ok": "1"}`, CommandError{}},
+		{
+			"full error",
+			`{"ok": 0, "errmsg": "not master", "code": 10107, "codeName": "NotMaster", ` +
+				`"errorLabels": ["RetryableWriteError", 1]}`,
+			CommandError{
+				Code:     CodeNotMaster,
+				CodeName: "NotMaster",
+				Message:  "not master",
+				Labels:   []string{"RetryableWriteError"},
+			},
+		},
+		{
+			"double code",
+			`{"ok": 0.0, "errmsg": "bad", "code": 2.0}`,
+			CommandError{Code: CodeBadValue, Message: "bad"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ReplyDocumentError(testutil.Document(t, tc.reply))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("error mismatch; got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsCommandError(t *testing.T) {
+	cerr := NewCommandError(CodeUnauthorized, "not allowed")
+
+	testCases := []struct {
+		name string
+		err  error
+		want CommandError
+	}{
+		{"command error", cerr, cerr},
+		{"wrapped command error", fmt.Errorf("checking policy: %w", cerr), cerr},
+		{"other error", errors.New("boom"), NewCommandError(CodeInternalError, "boom")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := AsCommandError(tc.err, CodeInternalError)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("error mismatch; got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommandErrorLabels(t *testing.T) {
+	original := NewCommandError(CodeNotMaster, "not master").WithLabel(RetryableErrorLabel)
+	labeled := original.WithLabel(TransientTransactionErrorLabel)
+	relabeled := labeled.WithLabel(RetryableErrorLabel)
+
+	testCases := []struct {
+		name   string
+		err    CommandError
+		labels []string
+	}{
+		{"original unchanged", original, []string{RetryableErrorLabel}},
+		{"label added", labeled, []string{RetryableErrorLabel, TransientTransactionErrorLabel}},
+		{"duplicate label ignored", relabeled, []string{RetryableErrorLabel, TransientTransactionErrorLabel}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tc.err.Labels, tc.labels) {
+				t.Fatalf("labels mismatch; got %v, want %v", tc.err.Labels, tc.labels)
+			}
+			for _, label := range tc.labels {
+				if !tc.err.HasLabel(label) {
+					t.Fatalf("expected error to have label %q", label)
+				}
+			}
+			if tc.err.HasLabel(SystemOverloadedErrorLabel) {
+				t.Fatalf("expected error not to have label %q", SystemOverloadedErrorLabel)
+			}
+		})
+	}
+}
+
+func TestCommandErrorRetryable(t *testing.T) {
+	testCases := []struct {
+		code      int32
+		retryable bool
+	}{
+		{CodeNotMaster, true},
+		{CodeNotMasterNoSlaveOk, true},
+		{CodeInterruptedDueToReplStateChange, true},
+		{CodeHostUnreachable, true},
+		{CodeUnauthorized, false},
+		{CodeCursorNotFound, false},
+		{CodeQuotaExceeded, false},
+	}
+	for _, tc := range testCases {
+		t.Run(codeNames[tc.code], func(t *testing.T) {
+			if got := NewCommandError(tc.code, "error").Retryable(); got != tc.retryable {
+				t.Fatalf("Retryable mismatch; got %v, want %v", got, tc.retryable)
+			}
+		})
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	request := mongowire.NewCommand(testutil.Document(t, `{"find": "coll", "$db": "db"}`))
+	cerr := NewCommandError(CodeCursorNotFound, "cursor id 1 not found").WithLabel(RetryableErrorLabel)
+
+	testCases := []struct {
+		name  string
+		reply []byte
+		want  error
+	}{
+		{"error reply", mongowire.NewReply(request, cerr.Document()).Encode(), cerr},
+		{"success reply", mongowire.NewReply(request, testutil.Document(t, `{"ok": 1}`)).Encode(), nil},
+		{"malformed reply", []byte{1, 2, 3}, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ReplyError(tc.reply); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("error mismatch; got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
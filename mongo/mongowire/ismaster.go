@@ -15,6 +15,7 @@ var (
 	isMasterResponseDocument = bsoncore.BuildDocumentFromElements(nil,
 		bsoncore.AppendInt32Element(nil, "ok", 1),
 		bsoncore.AppendBooleanElement(nil, "ismaster", true),
+		bsoncore.AppendStringElement(nil, "msg", "isdbgrid"),
 		bsoncore.AppendInt32Element(nil, "maxBsonObjectSize", maxBSONObjectSize),
 		bsoncore.AppendInt32Element(nil, "maxMessageSizeBytes", maxMessageSizeBytes),
 		bsoncore.AppendInt32Element(nil, "maxWriteBatchSize", maxWriteBatchSize),
@@ -29,7 +30,8 @@ func HandshakeIsMasterResponse(requestID int32) Message {
 	return newOpReply(requestID, isMasterResponseDocument)
 }
 
-// HeartbeatIsMasterResponse returns the isMaster response that should be used for server heartbeats.
-func HeartbeatIsMasterResponse(requestID int32) Message {
-	return newOpMsgResponse(requestID, isMasterResponseDocument)
+// HeartbeatIsMasterResponse returns the isMaster response that should be used for server heartbeats. The response uses
+// the wire format of the request, so legacy clients that send heartbeats with OP_QUERY get an OP_REPLY.
+func HeartbeatIsMasterResponse(request Message) Message {
+	return NewReply(request, isMasterResponseDocument)
 }
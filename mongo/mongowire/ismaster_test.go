@@ -0,0 +1,49 @@
+package mongowire
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+func TestHeartbeatIsMasterResponse(t *testing.T) {
+	cmd := testutil.Document(t, `{"isMaster": 1, "$db": "admin"}`)
+
+	testCases := []struct {
+		name   string
+		wm     []byte
+		opCode wiremessage.OpCode
+	}{
+		{"OP_MSG", newWireMessage(wiremessage.OpMsg, msgBody(0, cmd)), wiremessage.OpMsg},
+		{"OP_QUERY", newWireMessage(wiremessage.OpQuery, queryBody(0, "admin.$cmd", 0, -1, cmd, nil)), wiremessage.OpReply},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := Decode(tc.wm)
+			assertError(t, err, "")
+
+			wm := HeartbeatIsMasterResponse(request).Encode()
+			_, _, respTo, opCode, _, ok := wiremessage.ReadHeader(wm)
+			if !ok {
+				t.Fatal("failed to read response header")
+			}
+			if opCode != tc.opCode {
+				t.Fatalf("opcode mismatch; got %v, want %v", opCode, tc.opCode)
+			}
+			if respTo != testRequestID {
+				t.Fatalf("responseTo mismatch; got %d, want %d", respTo, testRequestID)
+			}
+
+			reply, err := Decode(wm)
+			assertError(t, err, "")
+			doc := reply.CommandDocument()
+			if msg, _ := doc.Lookup("msg").StringValueOK(); msg != "isdbgrid" {
+				t.Fatalf("msg mismatch; got %q, want %q", msg, "isdbgrid")
+			}
+			if isMaster, _ := doc.Lookup("ismaster").BooleanOK(); !isMaster {
+				t.Fatalf("expected ismaster to be true in %s", doc)
+			}
+		})
+	}
+}
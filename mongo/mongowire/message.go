@@ -14,6 +14,84 @@ type Message interface {
 	Encode() []byte
 	EncodeFixed(bsoncore.Document) []byte
 	RequestID() int32
+
+	// ExhaustAllowed returns true if the sender is willing to receive multiple replies for this message.
+	ExhaustAllowed() bool
+	// MoreToCome returns true if the sender will send another message without waiting for a reply to this one.
+	MoreToCome() bool
+	// FixDocumentSequences replaces every document in the message's document sequences with the result of calling fix
+	// on it. Messages without document sequences are not changed.
+	FixDocumentSequences(fix func(identifier string, doc bsoncore.Document) (bsoncore.Document, error)) error
+}
+
+// NewCommand creates an OP_MSG request for the provided command document. The document must include a $db field.
+func NewCommand(doc bsoncore.Document) Message {
+	return newOpMsgRequest(doc)
+}
+
+// NewUnacknowledgedCommand creates an OP_MSG request with the moreToCome flag set for the provided command document.
+// The server does not reply to the request. The document must include a $db field.
+func NewUnacknowledgedCommand(doc bsoncore.Document) Message {
+	msg := newOpMsgRequest(doc)
+	msg.flags = wiremessage.MoreToCome
+	return msg
+}
+
+// IsLegacy reports whether msg was translated from a request that used a legacy opcode.
+func IsLegacy(msg Message) bool {
+	switch msg.(type) {
+	case *opQuery, *opGetMore, *legacyWrite, *opKillCursors:
+		return true
+	}
+	return false
+}
+
+// SetStartingFrom sets the startingFrom value of OP_REPLY replies to a legacy OP_GET_MORE request. This is the
+// position of the reply's first document in the cursor's results, which the receiver must track because the getMore
+// command does not report it. Other requests are not changed.
+func SetStartingFrom(request Message, startingFrom int32) {
+	if g, ok := request.(*opGetMore); ok {
+		g.startingFrom = startingFrom
+	}
+}
+
+// SetCommandDocument replaces the command document of a request. It is used to rewrite a request before it is fixed,
+// for example to replace a command alias with its canonical name. Requests without a client-provided command document,
+// such as legacy OP_GET_MORE and OP_KILL_CURSORS requests, are not changed.
+func SetCommandDocument(request Message, doc bsoncore.Document) {
+	switch m := request.(type) {
+	case *opMsg:
+		m.setDocument(doc)
+	case *legacyWrite:
+		m.setDocument(doc)
+	case *opQuery:
+		m.cmd = doc
+	}
+}
+
+// legacyRequest is implemented by legacy OP_QUERY and OP_GET_MORE requests, which are translated to OP_MSG commands but
+// need replies in OP_REPLY format.
+type legacyRequest interface {
+	Message
+	newReply(doc bsoncore.Document) *opReply
+}
+
+// NewReply creates a reply to the provided request containing the given document. The reply uses the wire format that
+// the client expects for the request: OP_REPLY for legacy requests and OP_MSG otherwise.
+func NewReply(request Message, doc bsoncore.Document) Message {
+	if lr, ok := request.(legacyRequest); ok {
+		return lr.newReply(doc)
+	}
+	return newOpMsgResponse(request.RequestID(), doc)
+}
+
+// EncodeReply encodes a fixed reply from the server for the client that sent request. Replies to legacy requests are
+// converted to OP_REPLY. All other replies are encoded in the server's format so flags like moreToCome are kept.
+func EncodeReply(request, reply Message, fixedDocument bsoncore.Document) []byte {
+	if lr, ok := request.(legacyRequest); ok {
+		return lr.newReply(fixedDocument).Encode()
+	}
+	return reply.EncodeFixed(fixedDocument)
 }
 
 // Decode parses the provided wire message into a Message instance.
@@ -31,6 +109,36 @@ func Decode(wm []byte) (Message, error) {
 			return nil, err
 		}
 		return query, nil
+	case wiremessage.OpGetMore:
+		getMore, err := decodeGetMore(reqID, wmBody)
+		if err != nil {
+			return nil, err
+		}
+		return getMore, nil
+	case wiremessage.OpInsert:
+		insert, err := decodeInsert(reqID, wmBody)
+		if err != nil {
+			return nil, err
+		}
+		return insert, nil
+	case wiremessage.OpUpdate:
+		update, err := decodeUpdate(reqID, wmBody)
+		if err != nil {
+			return nil, err
+		}
+		return update, nil
+	case wiremessage.OpDelete:
+		del, err := decodeDelete(reqID, wmBody)
+		if err != nil {
+			return nil, err
+		}
+		return del, nil
+	case wiremessage.OpKillCursors:
+		killCursors, err := decodeKillCursors(reqID, wmBody)
+		if err != nil {
+			return nil, err
+		}
+		return killCursors, nil
 	case wiremessage.OpMsg:
 		msg, err := decodeMsg(reqID, respTo, wmBody)
 		if err != nil {
@@ -0,0 +1,96 @@
+package mongowire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+const testRequestID int32 = 1
+
+func TestDecode(t *testing.T) {
+	valid := newWireMessage(wiremessage.OpGetMore, getMoreBody("db.coll", 0, 1))
+
+	testCases := []struct {
+		name   string
+		wm     []byte
+		errMsg string
+	}{
+		{"empty", nil, "malformed wire message: insufficient bytes"},
+		{"truncated header", valid[:10], "malformed wire message: insufficient bytes"},
+		{"truncated body", valid[:len(valid)-1], "malformed wire message: insufficient bytes"},
+		{"unknown opcode", newWireMessage(wiremessage.OpCode(1234), nil), "unrecognized opcode 1234"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(tc.wm)
+			assertError(t, err, tc.errMsg)
+		})
+	}
+}
+
+// newWireMessage creates a wire message with the given opcode and body.
+func newWireMessage(opCode wiremessage.OpCode, body []byte) []byte {
+	idx, wm := wiremessage.AppendHeaderStart(nil, testRequestID, 0, opCode)
+	wm = append(wm, body...)
+	return bsoncore.UpdateLength(wm, idx, int32(len(wm[idx:])))
+}
+
+func assertDocumentEqual(t *testing.T, got, want bsoncore.Document) {
+	t.Helper()
+
+	if !bytes.Equal(got, want) {
+		t.Fatalf("document mismatch; got %s, want %s", got, want)
+	}
+}
+
+// assertError checks that err has the given message, or that err is nil if errMsg is empty.
+func assertError(t *testing.T, err error, errMsg string) {
+	t.Helper()
+
+	switch {
+	case errMsg == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case errMsg != "" && err == nil:
+		t.Fatalf("expected error %q, got nil", errMsg)
+	case errMsg != "" && err.Error() != errMsg:
+		t.Fatalf("error mismatch; got %q, want %q", err.Error(), errMsg)
+	}
+}
+
+func TestSetCommandDocument(t *testing.T) {
+	original := testutil.Document(t, `{"findandmodify": "coll", "remove": true, "$db": "db"}`)
+	replacement := testutil.Document(t, `{"findAndModify": "coll", "remove": true, "$db": "db"}`)
+
+	opMsgBody := appendi32(nil, 0)
+	opMsgBody = wiremessage.AppendMsgSectionType(opMsgBody, wiremessage.SingleDocument)
+	opMsgBody = append(opMsgBody, original...)
+
+	testCases := []struct {
+		name string
+		wm   []byte
+	}{
+		{"OP_MSG", newWireMessage(wiremessage.OpMsg, opMsgBody)},
+		{"OP_QUERY", newWireMessage(wiremessage.OpQuery, queryBody(0, "db.$cmd", 0, -1, original, nil))},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := Decode(tc.wm)
+			if err != nil {
+				t.Fatalf("error decoding message: %v", err)
+			}
+			SetCommandDocument(msg, replacement)
+			assertDocumentEqual(t, msg.CommandDocument(), replacement)
+
+			// The replacement is also used when the message is encoded.
+			encoded, err := Decode(msg.Encode())
+			if err != nil {
+				t.Fatalf("error decoding encoded message: %v", err)
+			}
+			assertDocumentEqual(t, encoded.CommandDocument(), replacement)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package mongowire
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+// opGetMore is a legacy OP_GET_MORE request. It is translated to a getMore command, and the server's reply is
+// converted back to OP_REPLY.
+type opGetMore struct {
+	reqID          int32
+	dbName         string
+	collName       string
+	numberToReturn int32
+	cursorID       int64
+
+	cmd          bsoncore.Document // the equivalent OP_MSG command
+	startingFrom int32             // the startingFrom value for replies, set by SetStartingFrom
+}
+
+var _ Message = (*opGetMore)(nil)
+
+func (g *opGetMore) CommandDocument() bsoncore.Document {
+	return g.cmd
+}
+
+func (g *opGetMore) Encode() []byte {
+	return g.EncodeFixed(g.cmd)
+}
+
+func (g *opGetMore) EncodeFixed(fixedDocument bsoncore.Document) []byte {
+	return encodeLegacyCommand(g.reqID, 0, fixedDocument)
+}
+
+func (g *opGetMore) RequestID() int32 {
+	return g.reqID
+}
+
+func (g *opGetMore) ExhaustAllowed() bool {
+	return false
+}
+
+func (g *opGetMore) MoreToCome() bool {
+	return false
+}
+
+func (g *opGetMore) FixDocumentSequences(func(string, bsoncore.Document) (bsoncore.Document, error)) error {
+	return nil
+}
+
+func (g *opGetMore) newReply(doc bsoncore.Document) *opReply {
+	reply := newCursorReply(g.reqID, doc, "nextBatch")
+	reply.startingFrom = g.startingFrom
+	return reply
+}
+
+func decodeGetMore(reqID int32, wm []byte) (*opGetMore, error) {
+	var ok bool
+	g := opGetMore{
+		reqID: reqID,
+	}
+
+	_, wm, ok = readi32(wm)
+	if !ok {
+		return nil, errors.New("malformed get more message: missing zero field")
+	}
+
+	var ns string
+	ns, wm, ok = wiremessage.ReadQueryFullCollectionName(wm)
+	if !ok {
+		return nil, errors.New("malformed get more message: full collection name")
+	}
+	g.dbName, g.collName = splitNamespace(ns)
+
+	g.numberToReturn, wm, ok = wiremessage.ReadQueryNumberToReturn(wm)
+	if !ok {
+		return nil, errors.New("malformed get more message: number to return")
+	}
+
+	g.cursorID, _, ok = wiremessage.ReadReplyCursorID(wm)
+	if !ok {
+		return nil, errors.New("malformed get more message: cursor id")
+	}
+
+	idx, cmd := bsoncore.AppendDocumentStart(nil)
+	cmd = bsoncore.AppendInt64Element(cmd, "getMore", g.cursorID)
+	cmd = bsoncore.AppendStringElement(cmd, "collection", g.collName)
+	if g.numberToReturn > 0 {
+		cmd = bsoncore.AppendInt32Element(cmd, "batchSize", g.numberToReturn)
+	}
+	cmd = bsoncore.AppendStringElement(cmd, "$db", g.dbName)
+	g.cmd, _ = bsoncore.AppendDocumentEnd(cmd, idx)
+
+	return &g, nil
+}
@@ -0,0 +1,89 @@
+package mongowire
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+func TestDecodeGetMore(t *testing.T) {
+	testCases := []struct {
+		name           string
+		ns             string
+		numberToReturn int32
+		cursorID       int64
+		expected       string
+	}{
+		{
+			name:     "zero numberToReturn",
+			ns:       "db.coll",
+			cursorID: 12345,
+			expected: `{"getMore": {"$numberLong": "12345"}, "collection": "coll", "$db": "db"}`,
+		},
+		{
+			name:           "positive numberToReturn",
+			ns:             "db.coll",
+			numberToReturn: 10,
+			cursorID:       12345,
+			expected:       `{"getMore": {"$numberLong": "12345"}, "collection": "coll", "batchSize": 10, "$db": "db"}`,
+		},
+		{
+			name:           "negative numberToReturn",
+			ns:             "db.coll",
+			numberToReturn: -10,
+			cursorID:       12345,
+			expected:       `{"getMore": {"$numberLong": "12345"}, "collection": "coll", "$db": "db"}`,
+		},
+		{
+			name:     "collection with dots",
+			ns:       "db.coll.sub",
+			cursorID: 1,
+			expected: `{"getMore": {"$numberLong": "1"}, "collection": "coll.sub", "$db": "db"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := Decode(newWireMessage(wiremessage.OpGetMore, getMoreBody(tc.ns, tc.numberToReturn, tc.cursorID)))
+			assertError(t, err, "")
+			if _, ok := msg.(*opGetMore); !ok {
+				t.Fatalf("expected *opGetMore, got %T", msg)
+			}
+
+			assertDocumentEqual(t, msg.CommandDocument(), testutil.Document(t, tc.expected))
+			if !IsLegacy(msg) {
+				t.Fatal("expected getMore to be legacy")
+			}
+		})
+	}
+}
+
+func TestDecodeGetMoreErrors(t *testing.T) {
+	valid := getMoreBody("db.coll", 0, 1)
+
+	testCases := []struct {
+		name   string
+		body   []byte
+		errMsg string
+	}{
+		{"empty", nil, "malformed get more message: missing zero field"},
+		{"unterminated namespace", valid[:8], "malformed get more message: full collection name"},
+		{"missing numberToReturn", valid[:12], "malformed get more message: number to return"},
+		{"missing cursor ID", valid[:16], "malformed get more message: cursor id"},
+		{"truncated cursor ID", valid[:len(valid)-1], "malformed get more message: cursor id"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(newWireMessage(wiremessage.OpGetMore, tc.body))
+			assertError(t, err, tc.errMsg)
+		})
+	}
+}
+
+// getMoreBody creates the body of an OP_GET_MORE wire message.
+func getMoreBody(ns string, ntr int32, cursorID int64) []byte {
+	body := wiremessage.AppendGetMoreZero(nil)
+	body = wiremessage.AppendGetMoreFullCollectionName(body, ns)
+	body = wiremessage.AppendGetMoreNumberToReturn(body, ntr)
+	return wiremessage.AppendGetMoreCursorID(body, cursorID)
+}
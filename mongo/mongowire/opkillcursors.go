@@ -0,0 +1,95 @@
+package mongowire
+
+import (
+	"errors"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+// opKillCursors is a legacy OP_KILL_CURSORS request. Unlike the killCursors command, it does not name the collection
+// of the cursors, so it cannot be translated on its own. The receiver must look up the namespace of each cursor and
+// send a killCursors command for it (see LegacyKillCursors). The client does not wait for a reply.
+type opKillCursors struct {
+	reqID     int32
+	cursorIDs []int64
+
+	cmd bsoncore.Document // a killCursors command with an empty collection name
+}
+
+var _ Message = (*opKillCursors)(nil)
+
+// LegacyKillCursors returns the cursor IDs in msg and true if msg is a legacy OP_KILL_CURSORS request.
+func LegacyKillCursors(msg Message) ([]int64, bool) {
+	kc, ok := msg.(*opKillCursors)
+	if !ok {
+		return nil, false
+	}
+	return kc.cursorIDs, true
+}
+
+func (k *opKillCursors) CommandDocument() bsoncore.Document {
+	return k.cmd
+}
+
+func (k *opKillCursors) Encode() []byte {
+	return k.EncodeFixed(k.cmd)
+}
+
+func (k *opKillCursors) EncodeFixed(fixedDocument bsoncore.Document) []byte {
+	return encodeLegacyCommand(k.reqID, wiremessage.MoreToCome, fixedDocument)
+}
+
+func (k *opKillCursors) RequestID() int32 {
+	return k.reqID
+}
+
+func (k *opKillCursors) ExhaustAllowed() bool {
+	return false
+}
+
+func (k *opKillCursors) MoreToCome() bool {
+	return true
+}
+
+func (k *opKillCursors) FixDocumentSequences(func(string, bsoncore.Document) (bsoncore.Document, error)) error {
+	return nil
+}
+
+func decodeKillCursors(reqID int32, wm []byte) (*opKillCursors, error) {
+	var ok bool
+	k := opKillCursors{
+		reqID: reqID,
+	}
+
+	_, wm, ok = wiremessage.ReadKillCursorsZero(wm)
+	if !ok {
+		return nil, errors.New("malformed kill cursors message: missing zero field")
+	}
+
+	var numIDs int32
+	numIDs, wm, ok = wiremessage.ReadKillCursorsNumberIDs(wm)
+	if !ok {
+		return nil, errors.New("malformed kill cursors message: number of cursor ids")
+	}
+	if numIDs < 0 {
+		return nil, errors.New("malformed kill cursors message: negative number of cursor ids")
+	}
+
+	k.cursorIDs, _, ok = wiremessage.ReadKillCursorsCursorIDs(wm, numIDs)
+	if !ok {
+		return nil, errors.New("malformed kill cursors message: cursor ids")
+	}
+
+	idx, cmd := bsoncore.AppendDocumentStart(nil)
+	cmd = bsoncore.AppendStringElement(cmd, "killCursors", "")
+	arrIdx, cmd := bsoncore.AppendArrayElementStart(cmd, "cursors")
+	for i, cursorID := range k.cursorIDs {
+		cmd = bsoncore.AppendInt64Element(cmd, strconv.Itoa(i), cursorID)
+	}
+	cmd, _ = bsoncore.AppendArrayEnd(cmd, arrIdx)
+	k.cmd, _ = bsoncore.AppendDocumentEnd(cmd, idx)
+
+	return &k, nil
+}
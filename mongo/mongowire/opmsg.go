@@ -37,6 +37,31 @@ func newOpMsgResponse(requestID int32, doc bsoncore.Document) *opMsg {
 	}
 }
 
+func newOpMsgRequest(doc bsoncore.Document) *opMsg {
+	section := &opMsgSection{
+		sectionType: wiremessage.SingleDocument,
+		document:    doc,
+	}
+	return &opMsg{
+		reqID:    wiremessage.NextRequestID(),
+		doc:      doc,
+		sections: []*opMsgSection{section},
+	}
+}
+
+// encodeLegacyCommand encodes the OP_MSG command that a legacy request was translated to. The original request ID is
+// kept so the server's reply can be matched to the client's request.
+func encodeLegacyCommand(requestID int32, flags wiremessage.MsgFlag, cmd bsoncore.Document) []byte {
+	msg := &opMsg{
+		reqID: requestID,
+		flags: flags,
+		sections: []*opMsgSection{
+			{sectionType: wiremessage.SingleDocument},
+		},
+	}
+	return msg.EncodeFixed(cmd)
+}
+
 func (m *opMsg) CommandDocument() bsoncore.Document {
 	return m.doc
 }
@@ -77,10 +102,45 @@ func (m *opMsg) EncodeFixed(fixedCmd bsoncore.Document) []byte {
 	return buffer
 }
 
+// setDocument replaces the message's command document, which is the document in its body section.
+func (m *opMsg) setDocument(doc bsoncore.Document) {
+	m.doc = doc
+	for _, section := range m.sections {
+		if section.sectionType == wiremessage.SingleDocument {
+			section.document = doc
+		}
+	}
+}
+
 func (m *opMsg) RequestID() int32 {
 	return m.reqID
 }
 
+func (m *opMsg) ExhaustAllowed() bool {
+	return m.flags&wiremessage.ExhaustAllowed == wiremessage.ExhaustAllowed
+}
+
+func (m *opMsg) FixDocumentSequences(fix func(string, bsoncore.Document) (bsoncore.Document, error)) error {
+	for _, section := range m.sections {
+		if section.sectionType != wiremessage.DocumentSequence {
+			continue
+		}
+
+		for i, doc := range section.sequence {
+			fixed, err := fix(section.identifier, doc)
+			if err != nil {
+				return err
+			}
+			section.sequence[i] = fixed
+		}
+	}
+	return nil
+}
+
+func (m *opMsg) MoreToCome() bool {
+	return m.flags&wiremessage.MoreToCome == wiremessage.MoreToCome
+}
+
 // see https://github.com/mongodb/mongo-go-driver/blob/v1.3.4/x/mongo/driver/operation.go#L1191-L1220
 func decodeMsg(reqID, respTo int32, wm []byte) (*opMsg, error) {
 	var ok bool
@@ -144,3 +204,25 @@ func appendCString(b []byte, str string) []byte {
 	b = append(b, str...)
 	return append(b, 0x00)
 }
+
+func readi32(src []byte) (int32, []byte, bool) {
+	if len(src) < 4 {
+		return 0, src, false
+	}
+	return int32(src[0]) | int32(src[1])<<8 | int32(src[2])<<16 | int32(src[3])<<24, src[4:], true
+}
+
+// readDocuments reads documents until src is exhausted. Unlike wiremessage.ReadReplyDocuments, it fails if src ends
+// with a partial document.
+func readDocuments(src []byte) ([]bsoncore.Document, bool) {
+	var docs []bsoncore.Document
+	for len(src) > 0 {
+		doc, rem, ok := bsoncore.ReadDocument(src)
+		if !ok {
+			return nil, false
+		}
+		docs = append(docs, doc)
+		src = rem
+	}
+	return docs, true
+}
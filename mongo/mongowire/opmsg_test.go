@@ -0,0 +1,127 @@
+package mongowire
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+func TestOpMsgFlags(t *testing.T) {
+	doc := testutil.Document(t, `{"isMaster": 1, "$db": "admin"}`)
+
+	testCases := []struct {
+		name           string
+		flags          wiremessage.MsgFlag
+		exhaustAllowed bool
+		moreToCome     bool
+	}{
+		{"no flags", 0, false, false},
+		{"exhaustAllowed", wiremessage.ExhaustAllowed, true, false},
+		{"moreToCome", wiremessage.MoreToCome, false, true},
+		{"both", wiremessage.ExhaustAllowed | wiremessage.MoreToCome, true, true},
+		{"checksumPresent", wiremessage.ChecksumPresent, false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := Decode(newWireMessage(wiremessage.OpMsg, msgBody(tc.flags, doc)))
+			assertError(t, err, "")
+
+			if got := msg.ExhaustAllowed(); got != tc.exhaustAllowed {
+				t.Fatalf("ExhaustAllowed mismatch; got %v, want %v", got, tc.exhaustAllowed)
+			}
+			if got := msg.MoreToCome(); got != tc.moreToCome {
+				t.Fatalf("MoreToCome mismatch; got %v, want %v", got, tc.moreToCome)
+			}
+			assertDocumentEqual(t, msg.CommandDocument(), doc)
+		})
+	}
+}
+
+func TestEncodeReplyOpMsg(t *testing.T) {
+	request, err := Decode(newWireMessage(wiremessage.OpMsg, msgBody(wiremessage.ExhaustAllowed,
+		testutil.Document(t, `{"isMaster": 1, "$db": "admin"}`))))
+	assertError(t, err, "")
+
+	serverDoc := testutil.Document(t, `{"ismaster": true, "ok": 1}`)
+	fixedDoc := testutil.Document(t, `{"ismaster": true, "msg": "isdbgrid", "ok": 1}`)
+
+	testCases := []struct {
+		name      string
+		flags     wiremessage.MsgFlag
+		wantFlags wiremessage.MsgFlag
+	}{
+		{"no flags", 0, 0},
+		{"moreToCome kept", wiremessage.MoreToCome, wiremessage.MoreToCome},
+		{"checksumPresent cleared", wiremessage.MoreToCome | wiremessage.ChecksumPresent, wiremessage.MoreToCome},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reply, err := Decode(newWireMessage(wiremessage.OpMsg, msgBody(tc.flags, serverDoc)))
+			assertError(t, err, "")
+
+			wm := EncodeReply(request, reply, fixedDoc)
+			_, reqID, _, opCode, body, ok := wiremessage.ReadHeader(wm)
+			if !ok {
+				t.Fatal("failed to read reply header")
+			}
+			if opCode != wiremessage.OpMsg {
+				t.Fatalf("opcode mismatch; got %v, want %v", opCode, wiremessage.OpMsg)
+			}
+			if reqID != testRequestID {
+				t.Fatalf("request ID mismatch; got %d, want %d", reqID, testRequestID)
+			}
+			flags, _, _ := wiremessage.ReadMsgFlags(body)
+			if flags != tc.wantFlags {
+				t.Fatalf("flags mismatch; got %v, want %v", flags, tc.wantFlags)
+			}
+
+			decoded, err := Decode(wm)
+			assertError(t, err, "")
+			assertDocumentEqual(t, decoded.CommandDocument(), fixedDoc)
+		})
+	}
+}
+
+// msgBody creates an OP_MSG body with the given flags and a single document section. If the ChecksumPresent flag is
+// set, a placeholder checksum is appended.
+func msgBody(flags wiremessage.MsgFlag, doc bsoncore.Document) []byte {
+	body := wiremessage.AppendMsgFlags(nil, flags)
+	body = wiremessage.AppendMsgSectionType(body, wiremessage.SingleDocument)
+	body = append(body, doc...)
+	if flags&wiremessage.ChecksumPresent == wiremessage.ChecksumPresent {
+		body = appendi32(body, 0)
+	}
+	return body
+}
+
+func TestNewUnacknowledgedCommand(t *testing.T) {
+	doc := testutil.Document(t, `{"insert": "coll", "documents": [{"x": 1}], "$db": "db"}`)
+
+	testCases := []struct {
+		name       string
+		msg        Message
+		moreToCome bool
+	}{
+		{"acknowledged", NewCommand(doc), false},
+		{"unacknowledged", NewUnacknowledgedCommand(doc), true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.msg.MoreToCome(); got != tc.moreToCome {
+				t.Fatalf("MoreToCome mismatch; got %v, want %v", got, tc.moreToCome)
+			}
+
+			decoded, err := Decode(tc.msg.Encode())
+			assertError(t, err, "")
+			if got := decoded.MoreToCome(); got != tc.moreToCome {
+				t.Fatalf("decoded MoreToCome mismatch; got %v, want %v", got, tc.moreToCome)
+			}
+			if decoded.ExhaustAllowed() {
+				t.Fatal("expected ExhaustAllowed to be false")
+			}
+			assertDocumentEqual(t, decoded.CommandDocument(), doc)
+		})
+	}
+}
@@ -8,6 +8,37 @@ import (
 	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
 )
 
+// commandCollection is the pseudo-collection that OP_QUERY commands are sent to.
+const commandCollection = "$cmd"
+
+// queryModifiers maps the legacy $-prefixed query modifiers that can wrap an OP_QUERY filter to the equivalent find
+// command options.
+var queryModifiers = map[string]string{
+	"$comment":     "comment",
+	"$hint":        "hint",
+	"$max":         "max",
+	"$maxTimeMS":   "maxTimeMS",
+	"$min":         "min",
+	"$orderby":     "sort",
+	"$returnKey":   "returnKey",
+	"$showDiskLoc": "showRecordId",
+}
+
+// queryFlagOptions maps OP_QUERY flags to the equivalent boolean find command options.
+var queryFlagOptions = []struct {
+	flag   wiremessage.QueryFlag
+	option string
+}{
+	{wiremessage.TailableCursor, "tailable"},
+	{wiremessage.OplogReplay, "oplogReplay"},
+	{wiremessage.NoCursorTimeout, "noCursorTimeout"},
+	{wiremessage.AwaitData, "awaitData"},
+	{wiremessage.Partial, "allowPartialResults"},
+}
+
+// opQuery is a legacy OP_QUERY request. Queries against the $cmd collection are commands and all other queries are
+// finds. In both cases, the request is translated to an OP_MSG command so it can be sent to servers that no longer
+// support OP_QUERY, and replies are converted back to OP_REPLY.
 type opQuery struct {
 	reqID                int32
 	flags                wiremessage.QueryFlag
@@ -17,38 +48,133 @@ type opQuery struct {
 	numberToReturn       int32
 	query                bsoncore.Document
 	returnFieldsSelector bsoncore.Document
+
+	cmd    bsoncore.Document // the equivalent OP_MSG command
+	isFind bool              // true if cmd is a find command whose results are returned as OP_REPLY documents
 }
 
 var _ Message = (*opQuery)(nil)
 
 func (q *opQuery) CommandDocument() bsoncore.Document {
-	return q.query
+	return q.cmd
 }
 
 func (q *opQuery) Encode() []byte {
-	return q.EncodeFixed(q.query)
+	return q.EncodeFixed(q.cmd)
 }
 
 func (q *opQuery) EncodeFixed(fixedDocument bsoncore.Document) []byte {
-	var buffer []byte
-	idx, buffer := wiremessage.AppendHeaderStart(buffer, q.reqID, 0, wiremessage.OpQuery)
-	buffer = wiremessage.AppendQueryFlags(buffer, q.flags)
-	buffer = wiremessage.AppendQueryFullCollectionName(buffer, q.collName)
-	buffer = wiremessage.AppendQueryNumberToSkip(buffer, q.numberToSkip)
-	buffer = wiremessage.AppendQueryNumberToReturn(buffer, q.numberToReturn)
-	buffer = append(buffer, fixedDocument...)
-	if len(q.returnFieldsSelector) != 0 {
-		// returnFieldsSelector is optional
-		buffer = append(buffer, q.returnFieldsSelector...)
-	}
-	buffer = bsoncore.UpdateLength(buffer, idx, int32(len(buffer[idx:])))
-	return buffer
+	return encodeLegacyCommand(q.reqID, 0, fixedDocument)
 }
 
 func (q *opQuery) RequestID() int32 {
 	return q.reqID
 }
 
+func (q *opQuery) ExhaustAllowed() bool {
+	return false
+}
+
+func (q *opQuery) MoreToCome() bool {
+	return false
+}
+
+func (q *opQuery) FixDocumentSequences(func(string, bsoncore.Document) (bsoncore.Document, error)) error {
+	return nil
+}
+
+func (q *opQuery) newReply(doc bsoncore.Document) *opReply {
+	if q.isFind {
+		return newCursorReply(q.reqID, doc, "firstBatch")
+	}
+	return newOpReply(q.reqID, doc)
+}
+
+// translate sets q.cmd to the OP_MSG command that is equivalent to the query.
+func (q *opQuery) translate() error {
+	filter := q.query
+	var modifiers bsoncore.Document
+	if wrapped, ok := q.query.Lookup("$query").DocumentOK(); ok {
+		filter = wrapped
+		modifiers = q.query
+	}
+
+	idx, cmd := bsoncore.AppendDocumentStart(nil)
+	switch {
+	case q.collName == commandCollection:
+		elems, _ := filter.Elements()
+		for _, elem := range elems {
+			cmd = append(cmd, elem...)
+		}
+	case q.flags&wiremessage.Exhaust == wiremessage.Exhaust:
+		return errors.New("exhaust is not supported for OP_QUERY")
+	case isTrue(modifiers.Lookup("$explain")):
+		// A query with the $explain modifier returns the explain output as its only result document.
+		cmd = bsoncore.AppendDocumentElement(cmd, "explain", q.findCommand(filter, modifiers))
+	default:
+		q.isFind = true
+		find := q.findCommand(filter, modifiers)
+		elems, _ := find.Elements()
+		for _, elem := range elems {
+			cmd = append(cmd, elem...)
+		}
+	}
+
+	// Queries sent to a mongos specify a read preference with the $readPreference modifier. The SlaveOK flag on its own
+	// allows the query to run on a secondary.
+	if rp, err := modifiers.LookupErr("$readPreference"); err == nil {
+		cmd = bsoncore.AppendValueElement(cmd, "$readPreference", rp)
+	} else if q.flags&wiremessage.SlaveOK == wiremessage.SlaveOK {
+		cmd = bsoncore.AppendDocumentElement(cmd, "$readPreference", bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.AppendStringElement(nil, "mode", "secondaryPreferred"),
+		))
+	}
+	cmd = bsoncore.AppendStringElement(cmd, "$db", q.dbName)
+	cmd, _ = bsoncore.AppendDocumentEnd(cmd, idx)
+	q.cmd = cmd
+	return nil
+}
+
+// findCommand returns the find command that is equivalent to the query, without $readPreference or $db.
+func (q *opQuery) findCommand(filter, modifiers bsoncore.Document) bsoncore.Document {
+	idx, dst := bsoncore.AppendDocumentStart(nil)
+	dst = bsoncore.AppendStringElement(dst, "find", q.collName)
+	dst = bsoncore.AppendDocumentElement(dst, "filter", filter)
+	if len(q.returnFieldsSelector) != 0 {
+		dst = bsoncore.AppendDocumentElement(dst, "projection", q.returnFieldsSelector)
+	}
+	if q.numberToSkip > 0 {
+		dst = bsoncore.AppendInt32Element(dst, "skip", q.numberToSkip)
+	}
+
+	// A negative numberToReturn is a limit for a single batch. The server treats 1 the same as -1.
+	switch ntr := q.numberToReturn; {
+	case ntr < 0:
+		dst = bsoncore.AppendInt32Element(dst, "limit", -ntr)
+		dst = bsoncore.AppendBooleanElement(dst, "singleBatch", true)
+	case ntr == 1:
+		dst = bsoncore.AppendInt32Element(dst, "limit", 1)
+		dst = bsoncore.AppendBooleanElement(dst, "singleBatch", true)
+	case ntr > 1:
+		dst = bsoncore.AppendInt32Element(dst, "batchSize", ntr)
+	}
+
+	elems, _ := modifiers.Elements()
+	for _, elem := range elems {
+		if option, ok := queryModifiers[elem.Key()]; ok {
+			dst = bsoncore.AppendValueElement(dst, option, elem.Value())
+		}
+	}
+	for _, fo := range queryFlagOptions {
+		if q.flags&fo.flag == fo.flag {
+			dst = bsoncore.AppendBooleanElement(dst, fo.option, true)
+		}
+	}
+
+	dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst
+}
+
 // see https://github.com/mongodb/mongo-go-driver/blob/v1.3.4/x/mongo/driver/topology/server_test.go#L302-L337
 func decodeQuery(reqID int32, wm []byte) (*opQuery, error) {
 	var ok bool
@@ -66,10 +192,7 @@ func decodeQuery(reqID int32, wm []byte) (*opQuery, error) {
 	if !ok {
 		return nil, errors.New("malformed query message: full collection name")
 	}
-	if idx := strings.IndexByte(ns, '.'); idx != -1 {
-		q.dbName = ns[:idx]
-		q.collName = ns[idx+1:]
-	}
+	q.dbName, q.collName = splitNamespace(ns)
 
 	q.numberToSkip, wm, ok = wiremessage.ReadQueryNumberToSkip(wm)
 	if !ok {
@@ -93,5 +216,25 @@ func decodeQuery(reqID int32, wm []byte) (*opQuery, error) {
 		}
 	}
 
+	if err := q.translate(); err != nil {
+		return nil, err
+	}
 	return &q, nil
 }
+
+// splitNamespace splits a full collection name into its database and collection names.
+func splitNamespace(ns string) (string, string) {
+	if idx := strings.IndexByte(ns, '.'); idx != -1 {
+		return ns[:idx], ns[idx+1:]
+	}
+	return ns, ""
+}
+
+// isTrue returns true if val is true or a non-zero number.
+func isTrue(val bsoncore.Value) bool {
+	if b, ok := val.BooleanOK(); ok {
+		return b
+	}
+	n, ok := val.AsInt64OK()
+	return ok && n != 0
+}
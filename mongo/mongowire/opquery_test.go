@@ -0,0 +1,251 @@
+package mongowire
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+func TestDecodeQuery(t *testing.T) {
+	allFlags := wiremessage.TailableCursor | wiremessage.OplogReplay | wiremessage.NoCursorTimeout |
+		wiremessage.AwaitData | wiremessage.Partial
+
+	testCases := []struct {
+		name           string
+		flags          wiremessage.QueryFlag
+		ns             string
+		numberToSkip   int32
+		numberToReturn int32
+		query          string
+		projection     string
+		expected       string
+		isFind         bool
+	}{
+		{
+			name:     "command",
+			ns:       "admin.$cmd",
+			query:    `{"ping": 1}`,
+			expected: `{"ping": 1, "$db": "admin"}`,
+		},
+		{
+			name:           "command ignores numberToReturn",
+			ns:             "admin.$cmd",
+			numberToReturn: -1,
+			query:          `{"ping": 1}`,
+			expected:       `{"ping": 1, "$db": "admin"}`,
+		},
+		{
+			name:     "command wrapped in $query",
+			ns:       "db.$cmd",
+			query:    `{"$query": {"count": "coll"}, "$readPreference": {"mode": "secondary"}}`,
+			expected: `{"count": "coll", "$readPreference": {"mode": "secondary"}, "$db": "db"}`,
+		},
+		{
+			name:     "command with SlaveOK",
+			flags:    wiremessage.SlaveOK,
+			ns:       "db.$cmd",
+			query:    `{"count": "coll"}`,
+			expected: `{"count": "coll", "$readPreference": {"mode": "secondaryPreferred"}, "$db": "db"}`,
+		},
+		{
+			name:     "find",
+			ns:       "db.coll",
+			query:    `{"x": 1}`,
+			expected: `{"find": "coll", "filter": {"x": 1}, "$db": "db"}`,
+			isFind:   true,
+		},
+		{
+			name:     "find in collection with dots",
+			ns:       "db.coll.sub",
+			query:    `{}`,
+			expected: `{"find": "coll.sub", "filter": {}, "$db": "db"}`,
+			isFind:   true,
+		},
+		{
+			name:         "find with skip and projection",
+			ns:           "db.coll",
+			numberToSkip: 5,
+			query:        `{"x": 1}`,
+			projection:   `{"a": 1}`,
+			expected:     `{"find": "coll", "filter": {"x": 1}, "projection": {"a": 1}, "skip": 5, "$db": "db"}`,
+			isFind:       true,
+		},
+		{
+			name:     "$query is unwrapped",
+			ns:       "db.coll",
+			query:    `{"$query": {"x": 1}}`,
+			expected: `{"find": "coll", "filter": {"x": 1}, "$db": "db"}`,
+			isFind:   true,
+		},
+		{
+			name:  "query modifiers",
+			ns:    "db.coll",
+			query: `{"$query": {"x": 1}, "$orderby": {"y": -1}, "$hint": "y_1", "$maxTimeMS": 100, "$showDiskLoc": true}`,
+			expected: `{"find": "coll", "filter": {"x": 1}, "sort": {"y": -1}, "hint": "y_1", "maxTimeMS": 100,
+				"showRecordId": true, "$db": "db"}`,
+			isFind: true,
+		},
+		{
+			name:     "modifiers are not read from an unwrapped filter",
+			ns:       "db.coll",
+			query:    `{"$orderby": {"y": -1}}`,
+			expected: `{"find": "coll", "filter": {"$orderby": {"y": -1}}, "$db": "db"}`,
+			isFind:   true,
+		},
+		{
+			name:     "$readPreference",
+			flags:    wiremessage.SlaveOK,
+			ns:       "db.coll",
+			query:    `{"$query": {}, "$readPreference": {"mode": "nearest"}}`,
+			expected: `{"find": "coll", "filter": {}, "$readPreference": {"mode": "nearest"}, "$db": "db"}`,
+			isFind:   true,
+		},
+		{
+			name:     "$explain",
+			ns:       "db.coll",
+			query:    `{"$query": {"x": 1}, "$orderby": {"y": 1}, "$explain": true}`,
+			expected: `{"explain": {"find": "coll", "filter": {"x": 1}, "sort": {"y": 1}}, "$db": "db"}`,
+		},
+		{
+			name:     "numeric $explain",
+			ns:       "db.coll",
+			query:    `{"$query": {}, "$explain": 1}`,
+			expected: `{"explain": {"find": "coll", "filter": {}}, "$db": "db"}`,
+		},
+		{
+			name:     "false $explain",
+			ns:       "db.coll",
+			query:    `{"$query": {}, "$explain": false}`,
+			expected: `{"find": "coll", "filter": {}, "$db": "db"}`,
+			isFind:   true,
+		},
+		{
+			name:           "negative numberToReturn",
+			ns:             "db.coll",
+			numberToReturn: -3,
+			query:          `{}`,
+			expected:       `{"find": "coll", "filter": {}, "limit": 3, "singleBatch": true, "$db": "db"}`,
+			isFind:         true,
+		},
+		{
+			name:           "numberToReturn -1",
+			ns:             "db.coll",
+			numberToReturn: -1,
+			query:          `{}`,
+			expected:       `{"find": "coll", "filter": {}, "limit": 1, "singleBatch": true, "$db": "db"}`,
+			isFind:         true,
+		},
+		{
+			name:     "zero numberToReturn",
+			ns:       "db.coll",
+			query:    `{}`,
+			expected: `{"find": "coll", "filter": {}, "$db": "db"}`,
+			isFind:   true,
+		},
+		{
+			name:           "numberToReturn 1",
+			ns:             "db.coll",
+			numberToReturn: 1,
+			query:          `{}`,
+			expected:       `{"find": "coll", "filter": {}, "limit": 1, "singleBatch": true, "$db": "db"}`,
+			isFind:         true,
+		},
+		{
+			name:           "positive numberToReturn",
+			ns:             "db.coll",
+			numberToReturn: 10,
+			query:          `{}`,
+			expected:       `{"find": "coll", "filter": {}, "batchSize": 10, "$db": "db"}`,
+			isFind:         true,
+		},
+		{
+			name:  "flags",
+			flags: allFlags,
+			ns:    "db.coll",
+			query: `{}`,
+			expected: `{"find": "coll", "filter": {}, "tailable": true, "oplogReplay": true, "noCursorTimeout": true,
+				"awaitData": true, "allowPartialResults": true, "$db": "db"}`,
+			isFind: true,
+		},
+		{
+			name:  "SlaveOK flag",
+			flags: wiremessage.SlaveOK,
+			ns:    "db.coll",
+			query: `{}`,
+			expected: `{"find": "coll", "filter": {}, "$readPreference": {"mode": "secondaryPreferred"},
+				"$db": "db"}`,
+			isFind: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var projection bsoncore.Document
+			if tc.projection != "" {
+				projection = testutil.Document(t, tc.projection)
+			}
+			body := queryBody(tc.flags, tc.ns, tc.numberToSkip, tc.numberToReturn, testutil.Document(t, tc.query), projection)
+
+			msg, err := Decode(newWireMessage(wiremessage.OpQuery, body))
+			assertError(t, err, "")
+			query, ok := msg.(*opQuery)
+			if !ok {
+				t.Fatalf("expected *opQuery, got %T", msg)
+			}
+
+			assertDocumentEqual(t, query.CommandDocument(), testutil.Document(t, tc.expected))
+			if query.isFind != tc.isFind {
+				t.Fatalf("isFind mismatch; got %v, want %v", query.isFind, tc.isFind)
+			}
+			if query.RequestID() != testRequestID {
+				t.Fatalf("request ID mismatch; got %d, want %d", query.RequestID(), testRequestID)
+			}
+			if !IsLegacy(query) {
+				t.Fatal("expected query to be legacy")
+			}
+		})
+	}
+}
+
+func TestDecodeQueryErrors(t *testing.T) {
+	query := bsoncore.BuildDocumentFromElements(nil, bsoncore.AppendInt32Element(nil, "x", 1))
+	valid := queryBody(0, "db.coll", 0, 0, query, nil)
+	beforeQuery := len(valid) - len(query)
+
+	testCases := []struct {
+		name   string
+		body   []byte
+		errMsg string
+	}{
+		{"empty", nil, "malformed query message: missing OP_QUERY flags"},
+		{"truncated flags", valid[:2], "malformed query message: missing OP_QUERY flags"},
+		{"unterminated namespace", valid[:8], "malformed query message: full collection name"},
+		{"missing numberToSkip", valid[:12], "malformed query message: number to skip"},
+		{"missing numberToReturn", valid[:16], "malformed query message: number to return"},
+		{"missing query", valid[:beforeQuery], "malformed query message: query document"},
+		{"truncated query", valid[:len(valid)-1], "malformed query message: query document"},
+		{"truncated projection", append(valid, 5, 0, 0), "malformed query message: return fields selector"},
+		{
+			"exhaust",
+			queryBody(wiremessage.Exhaust, "db.coll", 0, 0, query, nil),
+			"exhaust is not supported for OP_QUERY",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(newWireMessage(wiremessage.OpQuery, tc.body))
+			assertError(t, err, tc.errMsg)
+		})
+	}
+}
+
+// queryBody creates the body of an OP_QUERY wire message. The projection is omitted if it is empty.
+func queryBody(flags wiremessage.QueryFlag, ns string, skip, ntr int32, query, projection bsoncore.Document) []byte {
+	body := wiremessage.AppendQueryFlags(nil, flags)
+	body = wiremessage.AppendQueryFullCollectionName(body, ns)
+	body = wiremessage.AppendQueryNumberToSkip(body, skip)
+	body = wiremessage.AppendQueryNumberToReturn(body, ntr)
+	body = append(body, query...)
+	return append(body, projection...)
+}
@@ -8,10 +8,16 @@ import (
 	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
 )
 
+// cursorNotFoundCode is the error code the server returns for a getMore on a cursor that does not exist.
+const cursorNotFoundCode = 43
+
 type opReply struct {
-	respTo   int32
-	flags    wiremessage.ReplyFlag
-	document bsoncore.Document
+	respTo       int32
+	flags        wiremessage.ReplyFlag
+	cursorID     int64
+	startingFrom int32
+	document     bsoncore.Document
+	batch        []bsoncore.Document // documents returned by a query or getMore instead of document
 }
 
 var _ Message = (*opReply)(nil)
@@ -23,6 +29,43 @@ func newOpReply(requestID int32, doc bsoncore.Document) *opReply {
 	}
 }
 
+// newCursorReply creates a reply to a legacy OP_QUERY find or OP_GET_MORE request from the server's reply to the
+// equivalent command. The documents in the cursor batch named by batchField are returned directly. Error replies are
+// converted to a single {$err, code} document with the QueryFailure flag set, or the CursorNotFound flag for unknown
+// cursors.
+func newCursorReply(requestID int32, doc bsoncore.Document, batchField string) *opReply {
+	r := &opReply{
+		respTo: requestID,
+	}
+
+	if !isTrue(doc.Lookup("ok")) {
+		code, _ := doc.Lookup("code").AsInt64OK()
+		if code == cursorNotFoundCode {
+			r.flags = wiremessage.CursorNotFound
+			return r
+		}
+
+		errmsg, _ := doc.Lookup("errmsg").StringValueOK()
+		r.flags = wiremessage.QueryFailure
+		r.document = bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.AppendStringElement(nil, "$err", errmsg),
+			bsoncore.AppendInt32Element(nil, "code", int32(code)),
+		)
+		return r
+	}
+
+	r.cursorID, _ = doc.Lookup("cursor", "id").Int64OK()
+	batch, _ := doc.Lookup("cursor", batchField).ArrayOK()
+	vals, _ := batch.Values()
+	r.batch = make([]bsoncore.Document, 0, len(vals))
+	for _, val := range vals {
+		if batchDoc, ok := val.DocumentOK(); ok {
+			r.batch = append(r.batch, batchDoc)
+		}
+	}
+	return r
+}
+
 func (r *opReply) CommandDocument() bsoncore.Document {
 	return r.document
 }
@@ -35,10 +78,20 @@ func (r *opReply) EncodeFixed(fixedDocument bsoncore.Document) []byte {
 	var buffer []byte
 	idx, buffer := wiremessage.AppendHeaderStart(buffer, 0, r.respTo, wiremessage.OpReply)
 	buffer = wiremessage.AppendReplyFlags(buffer, r.flags)
-	buffer = wiremessage.AppendReplyCursorID(buffer, 0)
-	buffer = wiremessage.AppendReplyStartingFrom(buffer, 0)
-	buffer = wiremessage.AppendReplyNumberReturned(buffer, 1)
-	buffer = append(buffer, fixedDocument...)
+	buffer = wiremessage.AppendReplyCursorID(buffer, r.cursorID)
+	buffer = wiremessage.AppendReplyStartingFrom(buffer, r.startingFrom)
+	switch {
+	case r.batch != nil:
+		buffer = wiremessage.AppendReplyNumberReturned(buffer, int32(len(r.batch)))
+		for _, doc := range r.batch {
+			buffer = append(buffer, doc...)
+		}
+	case len(fixedDocument) == 0:
+		buffer = wiremessage.AppendReplyNumberReturned(buffer, 0)
+	default:
+		buffer = wiremessage.AppendReplyNumberReturned(buffer, 1)
+		buffer = append(buffer, fixedDocument...)
+	}
 	buffer = bsoncore.UpdateLength(buffer, idx, int32(len(buffer[idx:])))
 	return buffer
 }
@@ -47,6 +100,18 @@ func (r *opReply) RequestID() int32 {
 	return 0
 }
 
+func (r *opReply) ExhaustAllowed() bool {
+	return false
+}
+
+func (r *opReply) MoreToCome() bool {
+	return false
+}
+
+func (r *opReply) FixDocumentSequences(func(string, bsoncore.Document) (bsoncore.Document, error)) error {
+	return nil
+}
+
 // see https://github.com/mongodb/mongo-go-driver/blob/v1.3.4/x/mongo/driver/operation.go#L1101-L1162
 func decodeReply(respTo int32, wm []byte) (*opReply, error) {
 	var ok bool
@@ -74,7 +139,7 @@ func decodeReply(respTo int32, wm []byte) (*opReply, error) {
 		return nil, errors.New("malformed reply message: number returned")
 	}
 
-	documents, _, ok := wiremessage.ReadReplyDocuments(wm)
+	documents, ok := readDocuments(wm)
 	if !ok {
 		return nil, errors.New("malformed reply message: could not read documents from reply")
 	}
@@ -0,0 +1,191 @@
+package mongowire
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+func TestLegacyReplies(t *testing.T) {
+	find := newWireMessage(wiremessage.OpQuery, queryBody(0, "db.coll", 0, 0, bsoncore.BuildDocument(nil), nil))
+	command := newWireMessage(wiremessage.OpQuery, queryBody(0, "db.$cmd", 0, 0,
+		bsoncore.BuildDocumentFromElements(nil, bsoncore.AppendInt32Element(nil, "ping", 1)), nil))
+	getMore := newWireMessage(wiremessage.OpGetMore, getMoreBody("db.coll", 0, 7))
+
+	testCases := []struct {
+		name         string
+		request      []byte
+		startingFrom int32
+		reply        string
+		flags        wiremessage.ReplyFlag
+		cursorID     int64
+		documents    []string
+	}{
+		{
+			name:      "command",
+			request:   command,
+			reply:     `{"ok": 1}`,
+			documents: []string{`{"ok": 1}`},
+		},
+		{
+			name:      "command error",
+			request:   command,
+			reply:     `{"ok": 0, "errmsg": "failed", "code": 2}`,
+			documents: []string{`{"ok": 0, "errmsg": "failed", "code": 2}`},
+		},
+		{
+			name:      "find",
+			request:   find,
+			reply:     `{"cursor": {"id": {"$numberLong": "7"}, "ns": "db.coll", "firstBatch": [{"a": 1}, {"a": 2}]}, "ok": 1}`,
+			cursorID:  7,
+			documents: []string{`{"a": 1}`, `{"a": 2}`},
+		},
+		{
+			name:     "find with empty batch",
+			request:  find,
+			reply:    `{"cursor": {"id": {"$numberLong": "0"}, "ns": "db.coll", "firstBatch": []}, "ok": 1}`,
+			cursorID: 0,
+		},
+		{
+			name:      "find QueryFailure",
+			request:   find,
+			reply:     `{"ok": 0, "errmsg": "bad filter", "code": 2, "codeName": "BadValue"}`,
+			flags:     wiremessage.QueryFailure,
+			documents: []string{`{"$err": "bad filter", "code": 2}`},
+		},
+		{
+			name:         "getMore",
+			request:      getMore,
+			startingFrom: 2,
+			reply:        `{"cursor": {"id": {"$numberLong": "7"}, "ns": "db.coll", "nextBatch": [{"a": 3}]}, "ok": 1}`,
+			cursorID:     7,
+			documents:    []string{`{"a": 3}`},
+		},
+		{
+			name:    "getMore CursorNotFound",
+			request: getMore,
+			reply:   `{"ok": 0, "errmsg": "cursor id 7 not found", "code": 43, "codeName": "CursorNotFound"}`,
+			flags:   wiremessage.CursorNotFound,
+		},
+		{
+			name:      "getMore QueryFailure",
+			request:   getMore,
+			reply:     `{"ok": 0, "errmsg": "interrupted", "code": 11601}`,
+			flags:     wiremessage.QueryFailure,
+			documents: []string{`{"$err": "interrupted", "code": 11601}`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := Decode(tc.request)
+			assertError(t, err, "")
+			SetStartingFrom(request, tc.startingFrom)
+
+			reply := testutil.Document(t, tc.reply)
+			wm := EncodeReply(request, newOpMsgResponse(0, reply), reply)
+
+			flags, cursorID, startingFrom, documents := readReply(t, wm)
+			if flags != tc.flags {
+				t.Fatalf("flags mismatch; got %v, want %v", flags, tc.flags)
+			}
+			if cursorID != tc.cursorID {
+				t.Fatalf("cursor ID mismatch; got %d, want %d", cursorID, tc.cursorID)
+			}
+			if startingFrom != tc.startingFrom {
+				t.Fatalf("startingFrom mismatch; got %d, want %d", startingFrom, tc.startingFrom)
+			}
+			if len(documents) != len(tc.documents) {
+				t.Fatalf("expected %d documents, got %d", len(tc.documents), len(documents))
+			}
+			for i, doc := range documents {
+				assertDocumentEqual(t, doc, testutil.Document(t, tc.documents[i]))
+			}
+
+			// NewReply is used for replies generated by the proxy and must match the converted server reply.
+			if newReply := NewReply(request, reply).Encode(); string(newReply) != string(wm) {
+				t.Fatal("expected NewReply and EncodeReply to produce the same reply")
+			}
+		})
+	}
+}
+
+func TestDecodeReplyErrors(t *testing.T) {
+	doc := bsoncore.BuildDocumentFromElements(nil, bsoncore.AppendInt32Element(nil, "ok", 1))
+	valid := replyBody(1, doc)
+
+	testCases := []struct {
+		name   string
+		body   []byte
+		errMsg string
+	}{
+		{"empty", nil, "malformed reply message: missing OP_REPLY flags"},
+		{"missing cursor ID", valid[:4], "malformed reply message: cursor id"},
+		{"missing startingFrom", valid[:12], "malformed reply message: starting from"},
+		{"missing numberReturned", valid[:16], "malformed reply message: number returned"},
+		{"truncated document", valid[:len(valid)-1], "malformed reply message: could not read documents from reply"},
+		{"no documents", replyBody(0), "malformed reply message: reply contains 0 documents, but only 1 is supported"},
+		{
+			"multiple documents",
+			replyBody(2, doc, doc),
+			"malformed reply message: reply contains 2 documents, but only 1 is supported",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(newWireMessage(wiremessage.OpReply, tc.body))
+			assertError(t, err, tc.errMsg)
+		})
+	}
+}
+
+// replyBody creates the body of an OP_REPLY wire message.
+func replyBody(numberReturned int32, documents ...bsoncore.Document) []byte {
+	body := wiremessage.AppendReplyFlags(nil, 0)
+	body = wiremessage.AppendReplyCursorID(body, 0)
+	body = wiremessage.AppendReplyStartingFrom(body, 0)
+	body = wiremessage.AppendReplyNumberReturned(body, numberReturned)
+	for _, doc := range documents {
+		body = append(body, doc...)
+	}
+	return body
+}
+
+// readReply parses an encoded OP_REPLY wire message.
+func readReply(t *testing.T, wm []byte) (wiremessage.ReplyFlag, int64, int32, []bsoncore.Document) {
+	t.Helper()
+
+	_, _, respTo, opCode, body, ok := wiremessage.ReadHeader(wm)
+	if !ok || opCode != wiremessage.OpReply {
+		t.Fatalf("expected OP_REPLY, got opcode %v", opCode)
+	}
+	if respTo != testRequestID {
+		t.Fatalf("responseTo mismatch; got %d, want %d", respTo, testRequestID)
+	}
+
+	flags, body, ok := wiremessage.ReadReplyFlags(body)
+	if !ok {
+		t.Fatal("missing flags")
+	}
+	cursorID, body, ok := wiremessage.ReadReplyCursorID(body)
+	if !ok {
+		t.Fatal("missing cursor ID")
+	}
+	startingFrom, body, ok := wiremessage.ReadReplyStartingFrom(body)
+	if !ok {
+		t.Fatal("missing startingFrom")
+	}
+	numberReturned, body, ok := wiremessage.ReadReplyNumberReturned(body)
+	if !ok {
+		t.Fatal("missing numberReturned")
+	}
+	documents, _, ok := wiremessage.ReadReplyDocuments(body)
+	if !ok {
+		t.Fatal("malformed documents")
+	}
+	if int(numberReturned) != len(documents) {
+		t.Fatalf("numberReturned is %d, but reply has %d documents", numberReturned, len(documents))
+	}
+	return flags, cursorID, startingFrom, documents
+}
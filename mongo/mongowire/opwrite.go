@@ -0,0 +1,169 @@
+package mongowire
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+// Flags for legacy OP_INSERT, OP_UPDATE, and OP_DELETE requests.
+const (
+	insertContinueOnError int32 = 1 << 0
+	updateUpsert          int32 = 1 << 0
+	updateMulti           int32 = 1 << 1
+	deleteSingleRemove    int32 = 1 << 0
+)
+
+// legacyWrite is a legacy OP_INSERT, OP_UPDATE, or OP_DELETE request that has been translated to an acknowledged
+// OP_MSG write command. Clients do not expect a reply to legacy writes. Instead, they send getLastError on the same
+// connection to learn the result, so the server's reply must be kept by the receiver rather than sent to the client.
+type legacyWrite struct {
+	*opMsg
+}
+
+var _ Message = (*legacyWrite)(nil)
+
+// IsLegacyWrite reports whether msg is a translated OP_INSERT, OP_UPDATE, or OP_DELETE request.
+func IsLegacyWrite(msg Message) bool {
+	_, ok := msg.(*legacyWrite)
+	return ok
+}
+
+// newLegacyWrite creates a request for a translated legacy write. The documents for insert requests are sent in a
+// document sequence.
+func newLegacyWrite(reqID int32, cmd bsoncore.Document, identifier string, sequence []bsoncore.Document) *legacyWrite {
+	m := &opMsg{
+		reqID: reqID,
+		doc:   cmd,
+		sections: []*opMsgSection{
+			{sectionType: wiremessage.SingleDocument, document: cmd},
+		},
+	}
+	if identifier != "" {
+		m.sections = append(m.sections, &opMsgSection{
+			sectionType: wiremessage.DocumentSequence,
+			identifier:  identifier,
+			sequence:    sequence,
+		})
+	}
+	return &legacyWrite{m}
+}
+
+// appendWriteCommandEnd appends the elements that are shared by all translated legacy writes and ends the command.
+func appendWriteCommandEnd(cmd []byte, idx int32, dbName string) bsoncore.Document {
+	cmd = bsoncore.AppendStringElement(cmd, "$db", dbName)
+	cmd, _ = bsoncore.AppendDocumentEnd(cmd, idx)
+	return cmd
+}
+
+// decodeInsert translates a legacy OP_INSERT request to an insert command.
+func decodeInsert(reqID int32, wm []byte) (*legacyWrite, error) {
+	flags, wm, ok := readi32(wm)
+	if !ok {
+		return nil, errors.New("malformed insert message: missing OP_INSERT flags")
+	}
+
+	ns, wm, ok := wiremessage.ReadQueryFullCollectionName(wm)
+	if !ok {
+		return nil, errors.New("malformed insert message: full collection name")
+	}
+	dbName, collName := splitNamespace(ns)
+
+	documents, ok := readDocuments(wm)
+	if !ok {
+		return nil, errors.New("malformed insert message: documents")
+	}
+
+	idx, cmd := bsoncore.AppendDocumentStart(nil)
+	cmd = bsoncore.AppendStringElement(cmd, "insert", collName)
+	cmd = bsoncore.AppendBooleanElement(cmd, "ordered", flags&insertContinueOnError == 0)
+	return newLegacyWrite(reqID, appendWriteCommandEnd(cmd, idx, dbName), "documents", documents), nil
+}
+
+// decodeUpdate translates a legacy OP_UPDATE request to an update command with a single update statement.
+func decodeUpdate(reqID int32, wm []byte) (*legacyWrite, error) {
+	_, wm, ok := readi32(wm)
+	if !ok {
+		return nil, errors.New("malformed update message: missing zero field")
+	}
+
+	ns, wm, ok := wiremessage.ReadQueryFullCollectionName(wm)
+	if !ok {
+		return nil, errors.New("malformed update message: full collection name")
+	}
+	dbName, collName := splitNamespace(ns)
+
+	flags, wm, ok := readi32(wm)
+	if !ok {
+		return nil, errors.New("malformed update message: missing OP_UPDATE flags")
+	}
+
+	selector, wm, ok := wiremessage.ReadQueryQuery(wm)
+	if !ok {
+		return nil, errors.New("malformed update message: selector document")
+	}
+
+	update, _, ok := wiremessage.ReadQueryQuery(wm)
+	if !ok {
+		return nil, errors.New("malformed update message: update document")
+	}
+
+	statement := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendDocumentElement(nil, "q", selector),
+		bsoncore.AppendDocumentElement(nil, "u", update),
+		bsoncore.AppendBooleanElement(nil, "upsert", flags&updateUpsert != 0),
+		bsoncore.AppendBooleanElement(nil, "multi", flags&updateMulti != 0),
+	)
+
+	idx, cmd := bsoncore.AppendDocumentStart(nil)
+	cmd = bsoncore.AppendStringElement(cmd, "update", collName)
+	cmd = bsoncore.AppendArrayElement(cmd, "updates", bsoncore.BuildArray(nil, bsoncore.Value{
+		Type: bsontype.EmbeddedDocument,
+		Data: statement,
+	}))
+	return newLegacyWrite(reqID, appendWriteCommandEnd(cmd, idx, dbName), "", nil), nil
+}
+
+// decodeDelete translates a legacy OP_DELETE request to a delete command with a single delete statement.
+func decodeDelete(reqID int32, wm []byte) (*legacyWrite, error) {
+	_, wm, ok := readi32(wm)
+	if !ok {
+		return nil, errors.New("malformed delete message: missing zero field")
+	}
+
+	ns, wm, ok := wiremessage.ReadQueryFullCollectionName(wm)
+	if !ok {
+		return nil, errors.New("malformed delete message: full collection name")
+	}
+	dbName, collName := splitNamespace(ns)
+
+	flags, wm, ok := readi32(wm)
+	if !ok {
+		return nil, errors.New("malformed delete message: missing OP_DELETE flags")
+	}
+
+	selector, _, ok := wiremessage.ReadQueryQuery(wm)
+	if !ok {
+		return nil, errors.New("malformed delete message: selector document")
+	}
+
+	// A limit of 0 deletes every matching document.
+	var limit int32
+	if flags&deleteSingleRemove != 0 {
+		limit = 1
+	}
+	statement := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendDocumentElement(nil, "q", selector),
+		bsoncore.AppendInt32Element(nil, "limit", limit),
+	)
+
+	idx, cmd := bsoncore.AppendDocumentStart(nil)
+	cmd = bsoncore.AppendStringElement(cmd, "delete", collName)
+	cmd = bsoncore.AppendArrayElement(cmd, "deletes", bsoncore.BuildArray(nil, bsoncore.Value{
+		Type: bsontype.EmbeddedDocument,
+		Data: statement,
+	}))
+	return newLegacyWrite(reqID, appendWriteCommandEnd(cmd, idx, dbName), "", nil), nil
+}
@@ -0,0 +1,217 @@
+package mongowire
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+func TestDecodeLegacyWrite(t *testing.T) {
+	selector := `{"x": 1}`
+	update := `{"$set": {"y": 1}}`
+
+	testCases := []struct {
+		name      string
+		opCode    wiremessage.OpCode
+		body      func(t *testing.T) []byte
+		expected  string
+		documents []string // the documents in the command's document sequence
+	}{
+		{
+			name:   "insert",
+			opCode: wiremessage.OpInsert,
+			body: func(t *testing.T) []byte {
+				return insertBody(0, "db.coll", testutil.Document(t, `{"_id": 1}`), testutil.Document(t, `{"_id": 2}`))
+			},
+			expected:  `{"insert": "coll", "ordered": true, "$db": "db"}`,
+			documents: []string{`{"_id": 1}`, `{"_id": 2}`},
+		},
+		{
+			name:   "insert with ContinueOnError",
+			opCode: wiremessage.OpInsert,
+			body: func(t *testing.T) []byte {
+				return insertBody(insertContinueOnError, "db.coll", testutil.Document(t, `{"_id": 1}`))
+			},
+			expected:  `{"insert": "coll", "ordered": false, "$db": "db"}`,
+			documents: []string{`{"_id": 1}`},
+		},
+		{
+			name:   "update",
+			opCode: wiremessage.OpUpdate,
+			body: func(t *testing.T) []byte {
+				return updateBody("db.coll", 0, testutil.Document(t, selector), testutil.Document(t, update))
+			},
+			expected: `{"update": "coll", "updates": [{"q": {"x": 1}, "u": {"$set": {"y": 1}}, "upsert": false,
+				"multi": false}], "$db": "db"}`,
+		},
+		{
+			name:   "update with Upsert",
+			opCode: wiremessage.OpUpdate,
+			body: func(t *testing.T) []byte {
+				return updateBody("db.coll", updateUpsert, testutil.Document(t, selector), testutil.Document(t, update))
+			},
+			expected: `{"update": "coll", "updates": [{"q": {"x": 1}, "u": {"$set": {"y": 1}}, "upsert": true,
+				"multi": false}], "$db": "db"}`,
+		},
+		{
+			name:   "update with MultiUpdate",
+			opCode: wiremessage.OpUpdate,
+			body: func(t *testing.T) []byte {
+				return updateBody("db.coll", updateMulti, testutil.Document(t, selector), testutil.Document(t, update))
+			},
+			expected: `{"update": "coll", "updates": [{"q": {"x": 1}, "u": {"$set": {"y": 1}}, "upsert": false,
+				"multi": true}], "$db": "db"}`,
+		},
+		{
+			name:   "update with Upsert and MultiUpdate",
+			opCode: wiremessage.OpUpdate,
+			body: func(t *testing.T) []byte {
+				return updateBody("db.coll", updateUpsert|updateMulti, testutil.Document(t, selector), testutil.Document(t, update))
+			},
+			expected: `{"update": "coll", "updates": [{"q": {"x": 1}, "u": {"$set": {"y": 1}}, "upsert": true,
+				"multi": true}], "$db": "db"}`,
+		},
+		{
+			name:   "delete",
+			opCode: wiremessage.OpDelete,
+			body: func(t *testing.T) []byte {
+				return deleteBody("db.coll", 0, testutil.Document(t, selector))
+			},
+			expected: `{"delete": "coll", "deletes": [{"q": {"x": 1}, "limit": 0}], "$db": "db"}`,
+		},
+		{
+			name:   "delete with SingleRemove",
+			opCode: wiremessage.OpDelete,
+			body: func(t *testing.T) []byte {
+				return deleteBody("db.coll", deleteSingleRemove, testutil.Document(t, selector))
+			},
+			expected: `{"delete": "coll", "deletes": [{"q": {"x": 1}, "limit": 1}], "$db": "db"}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := Decode(newWireMessage(tc.opCode, tc.body(t)))
+			assertError(t, err, "")
+
+			assertDocumentEqual(t, msg.CommandDocument(), testutil.Document(t, tc.expected))
+			if !IsLegacyWrite(msg) || !IsLegacy(msg) {
+				t.Fatalf("expected %T to be a legacy write", msg)
+			}
+			// Legacy writes are acknowledged so their results can be returned by getLastError.
+			if msg.MoreToCome() {
+				t.Fatal("expected legacy write to be acknowledged")
+			}
+
+			var documents []bsoncore.Document
+			err = msg.FixDocumentSequences(func(identifier string, doc bsoncore.Document) (bsoncore.Document, error) {
+				if identifier != "documents" {
+					t.Fatalf("unexpected document sequence %q", identifier)
+				}
+				documents = append(documents, doc)
+				return doc, nil
+			})
+			assertError(t, err, "")
+			if len(documents) != len(tc.documents) {
+				t.Fatalf("expected %d documents, got %d", len(tc.documents), len(documents))
+			}
+			for i, doc := range documents {
+				assertDocumentEqual(t, doc, testutil.Document(t, tc.documents[i]))
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyWriteErrors(t *testing.T) {
+	doc := bsoncore.BuildDocumentFromElements(nil, bsoncore.AppendInt32Element(nil, "x", 1))
+	insert := insertBody(0, "db.coll", doc)
+	update := updateBody("db.coll", 0, doc, doc)
+	del := deleteBody("db.coll", 0, doc)
+
+	testCases := []struct {
+		name   string
+		opCode wiremessage.OpCode
+		body   []byte
+		errMsg string
+	}{
+		{"insert missing flags", wiremessage.OpInsert, nil, "malformed insert message: missing OP_INSERT flags"},
+		{
+			"insert unterminated namespace",
+			wiremessage.OpInsert,
+			insert[:8],
+			"malformed insert message: full collection name",
+		},
+		{
+			"insert truncated document",
+			wiremessage.OpInsert,
+			insert[:len(insert)-1],
+			"malformed insert message: documents",
+		},
+		{"update missing zero field", wiremessage.OpUpdate, nil, "malformed update message: missing zero field"},
+		{
+			"update unterminated namespace",
+			wiremessage.OpUpdate,
+			update[:8],
+			"malformed update message: full collection name",
+		},
+		{"update missing flags", wiremessage.OpUpdate, update[:12], "malformed update message: missing OP_UPDATE flags"},
+		{"update missing selector", wiremessage.OpUpdate, update[:16], "malformed update message: selector document"},
+		{
+			"update missing update",
+			wiremessage.OpUpdate,
+			update[:len(update)-len(doc)],
+			"malformed update message: update document",
+		},
+		{
+			"update truncated update",
+			wiremessage.OpUpdate,
+			update[:len(update)-1],
+			"malformed update message: update document",
+		},
+		{"delete missing zero field", wiremessage.OpDelete, nil, "malformed delete message: missing zero field"},
+		{
+			"delete unterminated namespace",
+			wiremessage.OpDelete,
+			del[:8],
+			"malformed delete message: full collection name",
+		},
+		{"delete missing flags", wiremessage.OpDelete, del[:12], "malformed delete message: missing OP_DELETE flags"},
+		{
+			"delete truncated selector",
+			wiremessage.OpDelete,
+			del[:len(del)-1],
+			"malformed delete message: selector document",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(newWireMessage(tc.opCode, tc.body))
+			assertError(t, err, tc.errMsg)
+		})
+	}
+}
+
+func insertBody(flags int32, ns string, documents ...bsoncore.Document) []byte {
+	body := appendi32(nil, flags)
+	body = appendCString(body, ns)
+	for _, doc := range documents {
+		body = append(body, doc...)
+	}
+	return body
+}
+
+func updateBody(ns string, flags int32, selector, update bsoncore.Document) []byte {
+	body := appendi32(nil, 0)
+	body = appendCString(body, ns)
+	body = appendi32(body, flags)
+	body = append(body, selector...)
+	return append(body, update...)
+}
+
+func deleteBody(ns string, flags int32, selector bsoncore.Document) []byte {
+	body := appendi32(nil, 0)
+	body = appendCString(body, ns)
+	body = appendi32(body, flags)
+	return append(body, selector...)
+}
@@ -0,0 +1,78 @@
+package mongo
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// ReadPrefFromCommand returns the read preference specified by the $readPreference field in the provided command
+// document. If the field is not present, the primary read preference is returned.
+func ReadPrefFromCommand(cmd bsoncore.Document) (*readpref.ReadPref, error) {
+	rpVal, err := cmd.LookupErr("$readPreference")
+	if err != nil {
+		return readpref.Primary(), nil
+	}
+	rpDoc, ok := rpVal.DocumentOK()
+	if !ok {
+		return nil, fmt.Errorf("expected $readPreference value to be document, got %s", rpVal.Type)
+	}
+
+	modeStr, ok := rpDoc.Lookup("mode").StringValueOK()
+	if !ok {
+		return nil, fmt.Errorf("expected $readPreference.mode to be a string")
+	}
+	mode, err := readpref.ModeFromString(modeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []readpref.Option
+	if maxStaleness, ok := rpDoc.Lookup("maxStalenessSeconds").AsInt64OK(); ok && maxStaleness > 0 {
+		opts = append(opts, readpref.WithMaxStaleness(time.Duration(maxStaleness)*time.Second))
+	}
+	if tagsArr, ok := rpDoc.Lookup("tags").ArrayOK(); ok {
+		tagSets, err := tagSetsFromArray(tagsArr)
+		if err != nil {
+			return nil, err
+		}
+		if len(tagSets) > 0 {
+			opts = append(opts, readpref.WithTagSets(tagSets...))
+		}
+	}
+
+	return readpref.New(mode, opts...)
+}
+
+func tagSetsFromArray(arr bsoncore.Array) ([]tag.Set, error) {
+	values, err := arr.Values()
+	if err != nil {
+		return nil, err
+	}
+
+	tagSets := make([]tag.Set, 0, len(values))
+	for _, val := range values {
+		tagDoc, ok := val.DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("expected $readPreference.tags element to be document, got %s", val.Type)
+		}
+		elems, err := tagDoc.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		set := make(tag.Set, 0, len(elems))
+		for _, elem := range elems {
+			tagValue, ok := elem.Value().StringValueOK()
+			if !ok {
+				return nil, fmt.Errorf("expected tag value for %s to be string, got %s", elem.Key(), elem.Value().Type)
+			}
+			set = append(set, tag.Tag{Name: elem.Key(), Value: tagValue})
+		}
+		tagSets = append(tagSets, set)
+	}
+	return tagSets, nil
+}
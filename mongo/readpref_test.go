@@ -0,0 +1,119 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+func TestReadPrefFromCommand(t *testing.T) {
+	testCases := []struct {
+		name         string
+		cmd          string
+		mode         readpref.Mode
+		tagSets      []tag.Set
+		maxStaleness time.Duration
+		errMsg       string
+	}{
+		{
+			name: "missing",
+			cmd:  `{"find": "coll"}`,
+			mode: readpref.PrimaryMode,
+		},
+		{
+			name: "mode only",
+			cmd:  `{"find": "coll", "$readPreference": {"mode": "secondaryPreferred"}}`,
+			mode: readpref.SecondaryPreferredMode,
+		},
+		{
+			name: "tags",
+			cmd: `{"find": "coll", "$readPreference": {"mode": "nearest", ` +
+				`"tags": [{"dc": "east", "rack": "1"}, {}]}}`,
+			mode:    readpref.NearestMode,
+			tagSets: []tag.Set{{{Name: "dc", Value: "east"}, {Name: "rack", Value: "1"}}, {}},
+		},
+		{
+			name: "empty tags",
+			cmd:  `{"find": "coll", "$readPreference": {"mode": "secondary", "tags": []}}`,
+			mode: readpref.SecondaryMode,
+		},
+		{
+			name:         "maxStalenessSeconds",
+			cmd:          `{"find": "coll", "$readPreference": {"mode": "secondary", "maxStalenessSeconds": 120}}`,
+			mode:         readpref.SecondaryMode,
+			maxStaleness: 120 * time.Second,
+		},
+		{
+			name: "no maxStalenessSeconds",
+			cmd:  `{"find": "coll", "$readPreference": {"mode": "secondary", "maxStalenessSeconds": -1}}`,
+			mode: readpref.SecondaryMode,
+		},
+		{
+			name:   "non-document",
+			cmd:    `{"find": "coll", "$readPreference": "secondary"}`,
+			errMsg: "expected $readPreference value to be document, got string",
+		},
+		{
+			name:   "missing mode",
+			cmd:    `{"find": "coll", "$readPreference": {}}`,
+			errMsg: "expected $readPreference.mode to be a string",
+		},
+		{
+			name:   "non-string mode",
+			cmd:    `{"find": "coll", "$readPreference": {"mode": 1}}`,
+			errMsg: "expected $readPreference.mode to be a string",
+		},
+		{
+			name:   "unknown mode",
+			cmd:    `{"find": "coll", "$readPreference": {"mode": "fastest"}}`,
+			errMsg: "unknown read preference fastest",
+		},
+		{
+			name:   "non-document tag set",
+			cmd:    `{"find": "coll", "$readPreference": {"mode": "nearest", "tags": ["east"]}}`,
+			errMsg: "expected $readPreference.tags element to be document, got string",
+		},
+		{
+			name:   "non-string tag value",
+			cmd:    `{"find": "coll", "$readPreference": {"mode": "nearest", "tags": [{"rack": 1}]}}`,
+			errMsg: "expected tag value for rack to be string, got 32-bit integer",
+		},
+		{
+			name:   "tags with primary",
+			cmd:    `{"find": "coll", "$readPreference": {"mode": "primary", "tags": [{"dc": "east"}]}}`,
+			errMsg: "can not specify tags or max staleness on primary",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rp, err := ReadPrefFromCommand(testutil.Document(t, tc.cmd))
+			if tc.errMsg != "" {
+				if err == nil {
+					t.Fatalf("expected error %q, got nil", tc.errMsg)
+				}
+				if err.Error() != tc.errMsg {
+					t.Fatalf("error mismatch; got %q, want %q", err.Error(), tc.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if rp.Mode() != tc.mode {
+				t.Fatalf("mode mismatch; got %v, want %v", rp.Mode(), tc.mode)
+			}
+			if tagSets := rp.TagSets(); !reflect.DeepEqual(tagSets, tc.tagSets) {
+				t.Fatalf("tag sets mismatch; got %v, want %v", tagSets, tc.tagSets)
+			}
+			maxStaleness, _ := rp.MaxStaleness()
+			if maxStaleness != tc.maxStaleness {
+				t.Fatalf("max staleness mismatch; got %v, want %v", maxStaleness, tc.maxStaleness)
+			}
+		})
+	}
+}
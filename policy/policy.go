@@ -0,0 +1,79 @@
+package policy
+
+import "strings"
+
+// Role names used by the built-in profiles.
+const (
+	// RoleTenant is the role for ordinary tenants. Tenants can use their own databases but cannot run commands that
+	// affect or reveal information about the shared deployment.
+	RoleTenant = "tenant"
+	// RoleOperator is the role for operators of the proxy. Operators can run diagnostic and maintenance commands but
+	// cannot run commands that shut down or reconfigure the deployment.
+	RoleOperator = "operator"
+)
+
+// Effect is the result of applying a Rule to a command.
+type Effect uint8
+
+// Effect constants
+const (
+	Deny Effect = iota
+	Allow
+)
+
+// String implements fmt.Stringer.
+func (e Effect) String() string {
+	if e == Allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// Rule allows or denies the commands that it matches. Each of the Commands, Databases, and Roles fields matches any
+// value if it is empty. Command names are compared case-insensitively. Commands that the server accepts under several
+// names are evaluated using their canonical names (see command.CanonicalName), so rules only need to list those.
+type Rule struct {
+	Effect    Effect
+	Commands  []string
+	Databases []string
+	Roles     []string
+}
+
+func (r *Rule) matches(role, cmdName, db string) bool {
+	return matchesAny(r.Roles, role) && matchesAny(r.Commands, cmdName) && matchesAny(r.Databases, db)
+}
+
+func matchesAny(values []string, target string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy decides whether a command can be run. Rules are evaluated in order and the first matching rule determines
+// the result. If no rules match, the Default effect is used.
+type Policy struct {
+	Rules   []Rule
+	Default Effect
+}
+
+// Evaluate returns the effect of the policy for a command run by a tenant with the given role against the given
+// database. The database is the name sent by the client, before any tenant prefix is applied.
+func (p *Policy) Evaluate(role, cmdName, db string) Effect {
+	for i := range p.Rules {
+		if p.Rules[i].matches(role, cmdName, db) {
+			return p.Rules[i].Effect
+		}
+	}
+	return p.Default
+}
+
+// Allowed is a convenience wrapper around Evaluate that reports whether the command is allowed.
+func (p *Policy) Allowed(role, cmdName, db string) bool {
+	return p.Evaluate(role, cmdName, db) == Allow
+}
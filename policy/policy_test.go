@@ -0,0 +1,72 @@
+package policy
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	custom := &Policy{
+		Rules: []Rule{
+			{Effect: Deny, Commands: []string{"drop"}, Databases: []string{"important"}},
+			{Effect: Allow, Roles: []string{"reader"}, Commands: []string{"find", "aggregate"}},
+			{Effect: Deny, Roles: []string{"reader"}},
+			{Effect: Allow, Commands: []string{"drop"}},
+		},
+		Default: Deny,
+	}
+
+	testCases := []struct {
+		name     string
+		policy   *Policy
+		role     string
+		cmdName  string
+		db       string
+		expected Effect
+	}{
+		{"no rules uses default allow", &Policy{Default: Allow}, "any", "find", "db", Allow},
+		{"no rules uses default deny", &Policy{Default: Deny}, "any", "find", "db", Deny},
+		{"first matching rule wins", custom, "writer", "drop", "important", Deny},
+		{"database must match", custom, "writer", "drop", "other", Allow},
+		{"role must match", custom, "writer", "find", "db", Deny},
+		{"allowed for role", custom, "reader", "find", "db", Allow},
+		{"command names are case-insensitive", custom, "reader", "FIND", "db", Allow},
+		{"roles are case-insensitive", custom, "READER", "aggregate", "db", Allow},
+		{"catch-all for role", custom, "reader", "insert", "db", Deny},
+		{"catch-all for role applies before later rules", custom, "reader", "drop", "db", Deny},
+
+		// Default policy: tenants can only run allow-listed commands.
+		{"tenant CRUD", Default(), RoleTenant, "insert", "db", Allow},
+		{"tenant cursor", Default(), RoleTenant, "getMore", "db", Allow},
+		{"tenant user management", Default(), RoleTenant, "createUser", "db", Allow},
+		{"tenant sessions", Default(), RoleTenant, "killSessions", "admin", Allow},
+		{"tenant intercepted command", Default(), RoleTenant, "getTenantUsage", "admin", Allow},
+		{"tenant case-insensitive", Default(), RoleTenant, "ISMASTER", "admin", Allow},
+		{"tenant hello", Default(), RoleTenant, "hello", "admin", Deny},
+		{"tenant shutdown", Default(), RoleTenant, "shutdown", "admin", Deny},
+		{"tenant applyOps", Default(), RoleTenant, "applyOps", "db", Deny},
+		{"tenant serverStatus", Default(), RoleTenant, "serverStatus", "admin", Deny},
+		{"tenant setParameter", Default(), RoleTenant, "setParameter", "admin", Deny},
+		{"tenant unknown command", Default(), RoleTenant, "someFutureCommand", "db", Deny},
+
+		// Default policy: operators can run anything that their profile does not deny.
+		{"operator serverStatus", Default(), RoleOperator, "serverStatus", "admin", Allow},
+		{"operator applyOps", Default(), RoleOperator, "applyOps", "db", Allow},
+		{"operator shutdown", Default(), RoleOperator, "shutdown", "admin", Deny},
+		{"operator replSetStepDown", Default(), RoleOperator, "replSetStepDown", "admin", Deny},
+		{"operator eval", Default(), RoleOperator, "eval", "db", Deny},
+		{"operator unknown command", Default(), RoleOperator, "someFutureCommand", "db", Allow},
+
+		// Default policy: roles without a profile are denied everything.
+		{"unknown role", Default(), "custom", "shutdown", "admin", Deny},
+		{"misspelled role", Default(), "tenat", "find", "db", Deny},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.policy.Evaluate(tc.role, tc.cmdName, tc.db)
+			if got != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+			if allowed := tc.policy.Allowed(tc.role, tc.cmdName, tc.db); allowed != (tc.expected == Allow) {
+				t.Fatalf("Allowed returned %v for effect %v", allowed, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package policy
+
+var (
+	// operatorDeniedCommands contains commands that shut down or reconfigure the deployment or run arbitrary code on
+	// the server.
+	operatorDeniedCommands = []string{
+		"addShard",
+		"configureFailPoint",
+		"eval",
+		"removeShard",
+		"replSetFreeze",
+		"replSetInitiate",
+		"replSetReconfig",
+		"replSetStepDown",
+		"setFeatureCompatibilityVersion",
+		"setParameter",
+		"shutdown",
+	}
+
+	// tenantAllowedCommands contains the commands that tenants can run. They read and write the tenant's own
+	// databases, manage its users, roles, sessions, and transactions, or only describe the proxy or the server's
+	// version. Commands that are not listed, including ones added in later server versions, are denied.
+	tenantAllowedCommands = []string{
+		// CRUD and cursors
+		"aggregate",
+		"count",
+		"delete",
+		"distinct",
+		"explain",
+		"find",
+		"findAndModify",
+		"getLastError",
+		"getMore",
+		"insert",
+		"killCursors",
+		"mapReduce",
+		"update",
+
+		// Databases, collections, and indexes
+		"cloneCollectionAsCapped",
+		"collMod",
+		"collStats",
+		"convertToCapped",
+		"create",
+		"createIndexes",
+		"dataSize",
+		"dbStats",
+		"drop",
+		"dropDatabase",
+		"dropIndexes",
+		"listCollections",
+		"listDatabases",
+		"listIndexes",
+		"reIndex",
+		"renameCollection",
+		"validate",
+
+		// Users and roles
+		"createRole",
+		"createUser",
+		"dropAllRolesFromDatabase",
+		"dropAllUsersFromDatabase",
+		"dropRole",
+		"dropUser",
+		"grantPrivilegesToRole",
+		"grantRolesToRole",
+		"grantRolesToUser",
+		"revokePrivilegesFromRole",
+		"revokeRolesFromRole",
+		"revokeRolesFromUser",
+		"rolesInfo",
+		"updateRole",
+		"updateUser",
+		"usersInfo",
+
+		// Sessions, transactions, and operations
+		"abortTransaction",
+		"commitTransaction",
+		"currentOp",
+		"endSessions",
+		"killOp",
+		"killSessions",
+		"refreshSessions",
+		"startSession",
+
+		// Connection and server information
+		"buildInfo",
+		"connectionStatus",
+		"getTenantUsage",
+		"isMaster",
+		"listCommands",
+		"ping",
+		"whatsmyuri",
+	}
+)
+
+// TenantProfile returns the rules for the built-in "tenant" role. The profile is an allow-list: the last rule denies
+// every command that the tenant role is not explicitly allowed to run, regardless of the policy's default.
+func TenantProfile() []Rule {
+	return []Rule{
+		{Effect: Allow, Roles: []string{RoleTenant}, Commands: tenantAllowedCommands},
+		{Effect: Deny, Roles: []string{RoleTenant}},
+	}
+}
+
+// OperatorProfile returns the rules for the built-in "operator" role. Operators can run every command except the
+// denied ones, regardless of the policy's default.
+func OperatorProfile() []Rule {
+	return []Rule{
+		{Effect: Deny, Roles: []string{RoleOperator}, Commands: operatorDeniedCommands},
+		{Effect: Allow, Roles: []string{RoleOperator}},
+	}
+}
+
+// Default returns the policy used when no policy is configured. It applies the tenant and operator profiles. Tenants
+// can only run the commands in the tenant profile, and operators can run any command that their profile does not
+// deny. Roles without a profile, including misspelled roles, cannot run any commands.
+func Default() *Policy {
+	var rules []Rule
+	rules = append(rules, TenantProfile()...)
+	rules = append(rules, OperatorProfile()...)
+	return &Policy{
+		Rules:   rules,
+		Default: Deny,
+	}
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/divjotarora/proxy/connection"
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// handleListDatabases responds to a listDatabases command with the tenant's databases. The command is run against the
+// backend with an additional filter that only matches databases with the tenant's prefix and the prefix is removed
+// from the returned names. Predicates on name in a filter provided by the client are rewritten to match the prefixed
+// names (see prefixNameFilter).
+func (p *Proxy) handleListDatabases(requestMsg mongowire.Message, ts *tenantState) (mongowire.Message, error) {
+	cmd := requestMsg.CommandDocument()
+	if db, _ := cmd.Lookup("$db").StringValueOK(); db != "admin" {
+		return nil, mongo.NewCommandError(mongo.CodeUnauthorized, "listDatabases may only be run against the admin database")
+	}
+
+	prefixFilter := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendRegexElement(nil, "name", "^"+regexp.QuoteMeta(ts.tenant.DatabasePrefix()), ""),
+	)
+	filter := prefixFilter
+	if clientFilter, ok := cmd.Lookup("filter").DocumentOK(); ok {
+		clientFilter, err := prefixNameFilter(clientFilter, ts.tenant.DatabasePrefix())
+		if err != nil {
+			return nil, err
+		}
+		filter = bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.BuildArrayElement(nil, "$and",
+				bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: prefixFilter},
+				bsoncore.Value{Type: bsontype.EmbeddedDocument, Data: clientFilter},
+			),
+		)
+	}
+
+	idx, backendCmd := bsoncore.AppendDocumentStart(nil)
+	backendCmd = bsoncore.AppendInt32Element(backendCmd, "listDatabases", 1)
+	backendCmd = bsoncore.AppendDocumentElement(backendCmd, "filter", filter)
+	if nameOnly, ok := cmd.Lookup("nameOnly").BooleanOK(); ok {
+		backendCmd = bsoncore.AppendBooleanElement(backendCmd, "nameOnly", nameOnly)
+	}
+	backendCmd = bsoncore.AppendStringElement(backendCmd, "$db", "admin")
+	backendCmd, _ = bsoncore.AppendDocumentEnd(backendCmd, idx)
+
+	reply, err := ts.backend.client.RunCommand(context.TODO(), backendCmd)
+	if err != nil {
+		return nil, mongo.AsCommandError(err, mongo.CodeHostUnreachable)
+	}
+
+	dbsArr, _ := reply.Lookup("databases").ArrayOK()
+	dbs, err := dbsArr.Values()
+	if err != nil {
+		return nil, err
+	}
+
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	arrIdx, doc := bsoncore.AppendArrayElementStart(doc, "databases")
+	for i, dbVal := range dbs {
+		dbDoc, ok := dbVal.DocumentOK()
+		if !ok {
+			return nil, mongo.NewCommandError(mongo.CodeInternalError, "expected database entry to be document, got %s",
+				dbVal.Type)
+		}
+
+		dbIdx, fixed := bsoncore.AppendDocumentElementStart(doc, strconv.Itoa(i))
+		elems, _ := dbDoc.Elements()
+		for _, elem := range elems {
+			if name, ok := elem.Value().StringValueOK(); ok && elem.Key() == "name" {
+				fixed = bsoncore.AppendStringElement(fixed, "name", strings.TrimPrefix(name, ts.tenant.DatabasePrefix()))
+				continue
+			}
+			fixed = append(fixed, elem...)
+		}
+		doc, _ = bsoncore.AppendDocumentEnd(fixed, dbIdx)
+	}
+	doc, _ = bsoncore.AppendArrayEnd(doc, arrIdx)
+	if totalSize, ok := reply.Lookup("totalSize").AsInt64OK(); ok {
+		doc = bsoncore.AppendInt64Element(doc, "totalSize", totalSize)
+	}
+	doc = bsoncore.AppendInt32Element(doc, "ok", 1)
+	doc, _ = bsoncore.AppendDocumentEnd(doc, idx)
+
+	return mongowire.NewReply(requestMsg, doc), nil
+}
+
+// prefixNameFilter rewrites the predicates on name in a listDatabases filter so they match the prefixed database
+// names on the server. Strings compared against name by equality, comparison, $in, and $nin are prefixed, which keeps
+// their order because every name has the same prefix. Logical operators are rewritten recursively. Predicates whose
+// meaning would change, such as regular expressions or $expr, are rejected.
+func prefixNameFilter(filter bsoncore.Document, prefix string) (bsoncore.Document, error) {
+	elems, err := filter.Elements()
+	if err != nil {
+		return nil, mongo.AsCommandError(err, mongo.CodeBadValue)
+	}
+
+	idx, dst := bsoncore.AppendDocumentStart(nil)
+	for _, elem := range elems {
+		switch key := elem.Key(); key {
+		case "name":
+			dst, err = appendPrefixedNamePredicate(dst, elem.Value(), prefix)
+		case "$and", "$or", "$nor":
+			dst, err = appendPrefixedFilters(dst, key, elem.Value(), prefix)
+		case "$expr", "$where", "$text":
+			err = mongo.NewCommandError(mongo.CodeBadValue, "%s is not supported in listDatabases filters", key)
+		default:
+			dst = append(dst, elem...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst, nil
+}
+
+// appendPrefixedFilters appends the array of filters for a logical operator with name predicates rewritten.
+func appendPrefixedFilters(dst []byte, key string, val bsoncore.Value, prefix string) ([]byte, error) {
+	arr, ok := val.ArrayOK()
+	if !ok {
+		return nil, mongo.NewCommandError(mongo.CodeBadValue, "%s must be an array", key)
+	}
+	vals, err := arr.Values()
+	if err != nil {
+		return nil, mongo.AsCommandError(err, mongo.CodeBadValue)
+	}
+
+	arrIdx, dst := bsoncore.AppendArrayElementStart(dst, key)
+	for i, filterVal := range vals {
+		filter, ok := filterVal.DocumentOK()
+		if !ok {
+			return nil, mongo.NewCommandError(mongo.CodeBadValue, "%s entries must be documents", key)
+		}
+		fixed, err := prefixNameFilter(filter, prefix)
+		if err != nil {
+			return nil, err
+		}
+		dst = bsoncore.AppendDocumentElement(dst, strconv.Itoa(i), fixed)
+	}
+	dst, _ = bsoncore.AppendArrayEnd(dst, arrIdx)
+	return dst, nil
+}
+
+// appendPrefixedNamePredicate appends the predicate for the name field with string operands prefixed.
+func appendPrefixedNamePredicate(dst []byte, val bsoncore.Value, prefix string) ([]byte, error) {
+	switch val.Type {
+	case bsontype.String:
+		return bsoncore.AppendStringElement(dst, "name", prefix+val.StringValue()), nil
+	case bsontype.Regex:
+		return nil, errUnsupportedNamePredicate("a regular expression")
+	case bsontype.EmbeddedDocument:
+	default:
+		// Other types never match a database name, so the predicate does not need to change.
+		return bsoncore.AppendValueElement(dst, "name", val), nil
+	}
+
+	// A document is either a set of query operators or an exact match against a document, which no name matches.
+	ops, _ := val.Document().Elements()
+	if len(ops) == 0 || !strings.HasPrefix(ops[0].Key(), "$") {
+		return bsoncore.AppendValueElement(dst, "name", val), nil
+	}
+
+	docIdx, dst := bsoncore.AppendDocumentElementStart(dst, "name")
+	for _, op := range ops {
+		switch key := op.Key(); key {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			switch op.Value().Type {
+			case bsontype.String:
+				dst = bsoncore.AppendStringElement(dst, key, prefix+op.Value().StringValue())
+			case bsontype.Regex:
+				return nil, errUnsupportedNamePredicate("a regular expression")
+			default:
+				dst = append(dst, op...)
+			}
+		case "$in", "$nin":
+			arr, ok := op.Value().ArrayOK()
+			if !ok {
+				return nil, mongo.NewCommandError(mongo.CodeBadValue, "%s needs an array", key)
+			}
+			vals, err := arr.Values()
+			if err != nil {
+				return nil, mongo.AsCommandError(err, mongo.CodeBadValue)
+			}
+
+			arrIdx, fixed := bsoncore.AppendArrayElementStart(dst, key)
+			for i, v := range vals {
+				switch v.Type {
+				case bsontype.String:
+					fixed = bsoncore.AppendStringElement(fixed, strconv.Itoa(i), prefix+v.StringValue())
+				case bsontype.Regex:
+					return nil, errUnsupportedNamePredicate("a regular expression")
+				default:
+					fixed = bsoncore.AppendValueElement(fixed, strconv.Itoa(i), v)
+				}
+			}
+			dst, _ = bsoncore.AppendArrayEnd(fixed, arrIdx)
+		case "$exists", "$type":
+			dst = append(dst, op...)
+		default:
+			return nil, errUnsupportedNamePredicate(key)
+		}
+	}
+	dst, _ = bsoncore.AppendDocumentEnd(dst, docIdx)
+	return dst, nil
+}
+
+func errUnsupportedNamePredicate(predicate string) error {
+	return mongo.NewCommandError(mongo.CodeBadValue, "%s on name is not supported in listDatabases filters", predicate)
+}
+
+// handleConnectionStatus responds to a connectionStatus command. Clients are not authenticated against the backend, so
+// the response has no users or roles. The real response would describe the proxy's own credentials.
+func (p *Proxy) handleConnectionStatus(requestMsg mongowire.Message) mongowire.Message {
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	authIdx, doc := bsoncore.AppendDocumentElementStart(doc, "authInfo")
+	doc = bsoncore.BuildArrayElement(doc, "authenticatedUsers")
+	doc = bsoncore.BuildArrayElement(doc, "authenticatedUserRoles")
+	if showPrivileges, _ := requestMsg.CommandDocument().Lookup("showPrivileges").BooleanOK(); showPrivileges {
+		doc = bsoncore.BuildArrayElement(doc, "authenticatedUserPrivileges")
+	}
+	doc, _ = bsoncore.AppendDocumentEnd(doc, authIdx)
+	doc = bsoncore.AppendInt32Element(doc, "ok", 1)
+	doc, _ = bsoncore.AppendDocumentEnd(doc, idx)
+
+	return mongowire.NewReply(requestMsg, doc)
+}
+
+// handleWhatsMyURI responds to a whatsmyuri command with the client's address as seen by the proxy rather than the
+// proxy's address as seen by the backend.
+func (p *Proxy) handleWhatsMyURI(requestMsg mongowire.Message, conn *connection.Connection) mongowire.Message {
+	doc := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendStringElement(nil, "you", conn.RemoteAddr().String()),
+		bsoncore.AppendInt32Element(nil, "ok", 1),
+	)
+	return mongowire.NewReply(requestMsg, doc)
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/divjotarora/proxy/connection"
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+)
+
+func TestPrefixNameFilter(t *testing.T) {
+	const prefix = "t1_"
+
+	testCases := []struct {
+		name     string
+		filter   string
+		expected string // empty if the filter is rejected
+	}{
+		{"equality", `{"name": "db"}`, `{"name": "t1_db"}`},
+		{"another tenant's prefix", `{"name": "t11_db"}`, `{"name": "t1_t11_db"}`},
+		{
+			"prefix without delimiter",
+			`{"name": {"$gte": "t1", "$lt": "t2"}}`,
+			`{"name": {"$gte": "t1_t1", "$lt": "t1_t2"}}`,
+		},
+		{"own prefix", `{"name": "t1_db"}`, `{"name": "t1_t1_db"}`},
+		{
+			"shared databases",
+			`{"name": {"$in": ["admin", "config", "local"]}}`,
+			`{"name": {"$in": ["t1_admin", "t1_config", "t1_local"]}}`,
+		},
+		{"$nin", `{"name": {"$nin": ["admin", 1]}}`, `{"name": {"$nin": ["t1_admin", 1]}}`},
+		{"$ne", `{"name": {"$ne": "local"}}`, `{"name": {"$ne": "t1_local"}}`},
+		{"$exists", `{"name": {"$exists": true}}`, `{"name": {"$exists": true}}`},
+		{"non-string", `{"name": 1}`, `{"name": 1}`},
+		{"document", `{"name": {"a": 1}}`, `{"name": {"a": 1}}`},
+		{"other fields", `{"sizeOnDisk": {"$gt": 0}, "empty": false}`, `{"sizeOnDisk": {"$gt": 0}, "empty": false}`},
+		{
+			"logical operators",
+			`{"$or": [{"name": "a"}, {"$and": [{"name": {"$ne": "config"}}, {"empty": false}]}],
+				"$nor": [{"name": "b"}]}`,
+			`{"$or": [{"name": "t1_a"}, {"$and": [{"name": {"$ne": "t1_config"}}, {"empty": false}]}],
+				"$nor": [{"name": "t1_b"}]}`,
+		},
+		{"regex", `{"name": {"$regularExpression": {"pattern": "^admin", "options": ""}}}`, ""},
+		{"$regex", `{"name": {"$regex": "^admin"}}`, ""},
+		{"$in regex", `{"name": {"$in": [{"$regularExpression": {"pattern": "^a", "options": ""}}]}}`, ""},
+		{"$not", `{"name": {"$not": {"$eq": "admin"}}}`, ""},
+		{"$expr", `{"$expr": {"$eq": ["$name", "admin"]}}`, ""},
+		{"$where", `{"$where": "true"}`, ""},
+		{"nested $expr", `{"$or": [{"$expr": true}]}`, ""},
+		{"logical operator without array", `{"$or": {"name": "a"}}`, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := prefixNameFilter(testutil.Document(t, tc.filter), prefix)
+			if tc.expected == "" {
+				cerr, ok := err.(mongo.CommandError)
+				if !ok || cerr.Code != mongo.CodeBadValue {
+					t.Fatalf("expected BadValue error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(got, expected) {
+				t.Fatalf("expected %s, got %s", expected, got)
+			}
+		})
+	}
+}
+
+func TestInterceptedAdminCommands(t *testing.T) {
+	p := &Proxy{}
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	conn := &connection.Connection{Conn: server}
+
+	testCases := []struct {
+		name     string
+		request  string
+		handle   func(mongowire.Message) mongowire.Message
+		expected string
+	}{
+		{
+			"connectionStatus",
+			`{"connectionStatus": 1, "$db": "admin"}`,
+			p.handleConnectionStatus,
+			`{"authInfo": {"authenticatedUsers": [], "authenticatedUserRoles": []}, "ok": 1}`,
+		},
+		{
+			"connectionStatus with privileges",
+			`{"connectionStatus": 1, "showPrivileges": true, "$db": "admin"}`,
+			p.handleConnectionStatus,
+			`{"authInfo": {"authenticatedUsers": [], "authenticatedUserRoles": [], ` +
+				`"authenticatedUserPrivileges": []}, "ok": 1}`,
+		},
+		{
+			"whatsmyuri",
+			`{"whatsmyuri": 1, "$db": "admin"}`,
+			func(request mongowire.Message) mongowire.Message {
+				return p.handleWhatsMyURI(request, conn)
+			},
+			`{"you": "` + server.RemoteAddr().String() + `", "ok": 1}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := mongowire.NewCommand(testutil.Document(t, tc.request))
+			reply := tc.handle(request)
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(reply.CommandDocument(), expected) {
+				t.Fatalf("expected %s, got %s", expected, reply.CommandDocument())
+			}
+		})
+	}
+}
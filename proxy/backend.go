@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/divjotarora/proxy/mongo"
+)
+
+// backendRetryDelay is how long a failure to connect to a backend is returned to new connections before the proxy
+// tries to connect again.
+const backendRetryDelay = 5 * time.Second
+
+// backend represents a MongoDB deployment that requests can be routed to.
+type backend struct {
+	uri     string
+	client  *mongo.Client
+	cursors *cursorTable
+}
+
+// backendEntry holds the result of connecting to a backend. The other fields must not be read until done is closed.
+type backendEntry struct {
+	done     chan struct{}
+	backend  *backend
+	err      error
+	failedAt time.Time
+}
+
+// getBackend returns the backend for the deployment at the given URI, connecting to it if this is the first time it
+// has been used. An empty URI refers to the proxy's default deployment. Only one connection attempt is made per URI at
+// a time, and backendsMu is not held while connecting so other backends can be used in the meantime. A failed attempt
+// is reused for backendRetryDelay so an unreachable deployment does not cause a new attempt for every connection.
+func (p *Proxy) getBackend(uri string) (*backend, error) {
+	if uri == "" {
+		uri = p.defaultURI
+	}
+
+	p.backendsMu.Lock()
+	entry, ok := p.backends[uri]
+	if ok && entry.expired() {
+		ok = false
+	}
+	if !ok {
+		entry = &backendEntry{done: make(chan struct{})}
+		p.backends[uri] = entry
+	}
+	p.backendsMu.Unlock()
+
+	if ok {
+		<-entry.done
+		return entry.backend, entry.err
+	}
+
+	client, err := mongo.NewClient(context.TODO(), uri, p.backendOpts)
+	if err != nil {
+		entry.err = err
+		entry.failedAt = time.Now()
+	} else {
+		entry.backend = &backend{
+			uri:     uri,
+			client:  client,
+			cursors: newCursorTable(),
+		}
+	}
+	close(entry.done)
+	return entry.backend, entry.err
+}
+
+// expired returns true if the entry is for a failed connection attempt that is older than backendRetryDelay.
+func (e *backendEntry) expired() bool {
+	select {
+	case <-e.done:
+		return e.err != nil && time.Since(e.failedAt) >= backendRetryDelay
+	default:
+		return false
+	}
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/divjotarora/proxy/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// checkKillOp returns an Unauthorized error if the operation targeted by a killOp request does not belong to the
+// tenant. The operation is looked up on the backend with currentOp. The same error is returned if the operation does
+// not exist so tenants cannot learn about other tenants' operations.
+func (p *Proxy) checkKillOp(ts *tenantState, cmd bsoncore.Document) error {
+	opid, err := cmd.LookupErr("op")
+	if err != nil {
+		return mongo.NewCommandError(mongo.CodeBadValue, "killOp requires an op value")
+	}
+
+	idx, currentOpCmd := bsoncore.AppendDocumentStart(nil)
+	currentOpCmd = bsoncore.AppendInt32Element(currentOpCmd, "currentOp", 1)
+	currentOpCmd = bsoncore.AppendBooleanElement(currentOpCmd, "$all", true)
+	currentOpCmd = bsoncore.AppendValueElement(currentOpCmd, "opid", opid)
+	currentOpCmd = bsoncore.AppendStringElement(currentOpCmd, "$db", "admin")
+	currentOpCmd, _ = bsoncore.AppendDocumentEnd(currentOpCmd, idx)
+
+	reply, err := ts.backend.client.RunCommand(context.TODO(), currentOpCmd)
+	if err != nil {
+		return mongo.AsCommandError(err, mongo.CodeHostUnreachable)
+	}
+
+	inprog, _ := reply.Lookup("inprog").ArrayOK()
+	ops, _ := inprog.Values()
+	for _, opVal := range ops {
+		if op, ok := opVal.DocumentOK(); ok && ts.parser.OwnsOperation(op) {
+			return nil
+		}
+	}
+	return mongo.NewCommandError(mongo.CodeUnauthorized, "operation %s does not belong to tenant %s", opid,
+		ts.tenant.Name)
+}
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/divjotarora/proxy/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/address"
+)
+
+// cursorInfo holds the information needed to route and fix requests for an open cursor.
+type cursorInfo struct {
+	owner     string          // name of the tenant that created the cursor
+	fixerName string          // name of the FixerSet for the command that created the cursor
+	addr      address.Address // address of the server that owns the cursor
+	ns        string          // namespace of the cursor as seen by the client, used to kill legacy cursors
+	returned  int32           // number of documents returned so far, only tracked for legacy cursors
+}
+
+// cursorTable tracks the open cursors created through the proxy on a single backend. Cursor IDs are only unique within
+// a deployment, so each backend has its own table. Tenants that share a backend also share its table, so every cursor
+// records the tenant that created it and other tenants cannot see or remove it. This type is safe for concurrent use.
+type cursorTable struct {
+	mu      sync.Mutex
+	cursors map[int64]cursorInfo // cursor ID -> cursor
+}
+
+func newCursorTable() *cursorTable {
+	return &cursorTable{
+		cursors: make(map[int64]cursorInfo),
+	}
+}
+
+// get returns the cursor with the given ID if it is owned by the given tenant.
+func (ct *cursorTable) get(owner string, cursorID int64) (cursorInfo, bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	cursor, ok := ct.cursors[cursorID]
+	if !ok || cursor.owner != owner {
+		return cursorInfo{}, false
+	}
+	return cursor, true
+}
+
+func (ct *cursorTable) put(cursorID int64, cursor cursorInfo) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.cursors[cursorID] = cursor
+}
+
+// advance adds n to the number of documents returned by a cursor and returns the previous number. If the cursor is
+// unknown, 0 is returned.
+func (ct *cursorTable) advance(cursorID int64, n int32) int32 {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	cursor, ok := ct.cursors[cursorID]
+	if !ok {
+		return 0
+	}
+	start := cursor.returned
+	cursor.returned += n
+	ct.cursors[cursorID] = cursor
+	return start
+}
+
+// remove removes the cursors with the given IDs that are owned by the given tenant.
+func (ct *cursorTable) remove(owner string, cursorIDs ...int64) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	for _, cursorID := range cursorIDs {
+		if cursor, ok := ct.cursors[cursorID]; ok && cursor.owner == owner {
+			delete(ct.cursors, cursorID)
+		}
+	}
+}
+
+// lookup returns the cursor referenced by a getMore or killCursors request sent by the given tenant. Cursors owned by
+// other tenants are treated as unknown. For getMore, an error is returned if the cursor is unknown. For killCursors,
+// nil is returned if none of the cursors are known. For all other commands, nil is returned.
+func (ct *cursorTable) lookup(owner, cmdName string, doc bsoncore.Document) (*cursorInfo, error) {
+	switch cmdName {
+	case "getMore":
+		cursorIDVal := doc.Index(0).Value()
+		cursorID, ok := cursorIDVal.Int64OK()
+		if !ok {
+			return nil, mongo.NewCommandError(mongo.CodeTypeMismatch, "expected getMore value to be int64, got %s",
+				cursorIDVal.Type)
+		}
+
+		cursor, ok := ct.get(owner, cursorID)
+		if !ok {
+			return nil, mongo.NewCommandError(mongo.CodeCursorNotFound, "cursor id %v not found", cursorID)
+		}
+		return &cursor, nil
+	case "killCursors":
+		for _, cursorID := range killCursorsIDs(doc) {
+			if cursor, ok := ct.get(owner, cursorID); ok {
+				return &cursor, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// killCursorsIDs returns the cursor IDs in the cursors array of a killCursors command.
+func killCursorsIDs(doc bsoncore.Document) []int64 {
+	return cursorIDArray(doc, "cursors")
+}
+
+// killedCursorIDs returns the cursor IDs in the cursorsKilled array of a killCursors reply.
+func killedCursorIDs(doc bsoncore.Document) []int64 {
+	return cursorIDArray(doc, "cursorsKilled")
+}
+
+// cursorIDArray returns the cursor IDs in the array with the given key. Values that are not int64 are skipped.
+func cursorIDArray(doc bsoncore.Document, key string) []int64 {
+	arr, ok := doc.Lookup(key).ArrayOK()
+	if !ok {
+		return nil
+	}
+	values, err := arr.Values()
+	if err != nil {
+		return nil
+	}
+
+	cursorIDs := make([]int64, 0, len(values))
+	for _, val := range values {
+		if cursorID, ok := val.Int64OK(); ok {
+			cursorIDs = append(cursorIDs, cursorID)
+		}
+	}
+	return cursorIDs
+}
+
+// getBatchLength returns the number of documents in the firstBatch or nextBatch array of a cursor reply.
+func getBatchLength(doc bsoncore.Document) int32 {
+	batch, ok := doc.Lookup("cursor", "firstBatch").ArrayOK()
+	if !ok {
+		batch, _ = doc.Lookup("cursor", "nextBatch").ArrayOK()
+	}
+	values, _ := batch.Values()
+	return int32(len(values))
+}
+
+func getCursorID(doc bsoncore.Document) int64 {
+	cursorIDVal, err := doc.LookupErr("cursor", "id")
+	if err != nil {
+		return 0
+	}
+
+	cursorID, ok := cursorIDVal.Int64OK()
+	if !ok {
+		return 0
+	}
+	return cursorID
+}
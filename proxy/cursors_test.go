@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/mongo"
+)
+
+func TestCursorTableOwnership(t *testing.T) {
+	ct := newCursorTable()
+	ct.put(1, cursorInfo{owner: "t1", fixerName: "find"})
+	ct.put(2, cursorInfo{owner: "t2", fixerName: "aggregate"})
+
+	if _, ok := ct.get("t1", 1); !ok {
+		t.Fatal("expected tenant to get its own cursor")
+	}
+	if _, ok := ct.get("t1", 2); ok {
+		t.Fatal("expected tenant not to get another tenant's cursor")
+	}
+
+	testCases := []struct {
+		name      string
+		request   string
+		fixerName string // empty if no cursor is expected
+		code      int32  // expected error code, or 0 if no error is expected
+	}{
+		{"getMore own cursor", `{"getMore": {"$numberLong": "1"}, "collection": "coll", "$db": "db"}`, "find", 0},
+		{
+			"getMore other tenant's cursor",
+			`{"getMore": {"$numberLong": "2"}, "collection": "coll", "$db": "db"}`,
+			"",
+			mongo.CodeCursorNotFound,
+		},
+		{
+			"getMore unknown cursor",
+			`{"getMore": {"$numberLong": "3"}, "collection": "coll", "$db": "db"}`,
+			"",
+			mongo.CodeCursorNotFound,
+		},
+		{
+			"killCursors own cursor",
+			`{"killCursors": "coll", "cursors": [{"$numberLong": "2"}, {"$numberLong": "1"}], "$db": "db"}`,
+			"find",
+			0,
+		},
+		{
+			"killCursors other tenant's cursor",
+			`{"killCursors": "coll", "cursors": [{"$numberLong": "2"}], "$db": "db"}`,
+			"",
+			0,
+		},
+		{"other command", `{"find": "coll", "$db": "db"}`, "", 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := testutil.Document(t, tc.request)
+			cursor, err := ct.lookup("t1", request.Index(0).Key(), request)
+			if tc.code != 0 {
+				cerr, ok := err.(mongo.CommandError)
+				if !ok || cerr.Code != tc.code {
+					t.Fatalf("expected error with code %d, got %v", tc.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			switch {
+			case tc.fixerName == "" && cursor != nil:
+				t.Fatalf("expected no cursor, got %+v", cursor)
+			case tc.fixerName != "" && (cursor == nil || cursor.fixerName != tc.fixerName):
+				t.Fatalf("expected cursor created by %s, got %+v", tc.fixerName, cursor)
+			}
+		})
+	}
+
+	// Removing another tenant's cursor has no effect.
+	ct.remove("t1", 1, 2)
+	if _, ok := ct.get("t1", 1); ok {
+		t.Fatal("expected cursor to be removed")
+	}
+	if _, ok := ct.get("t2", 2); !ok {
+		t.Fatal("expected other tenant's cursor not to be removed")
+	}
+}
+
+func TestKilledCursorIDs(t *testing.T) {
+	reply := testutil.Document(t, `{
+		"cursorsKilled": [{"$numberLong": "1"}],
+		"cursorsNotFound": [{"$numberLong": "2"}],
+		"cursorsAlive": [{"$numberLong": "3"}],
+		"ok": 1
+	}`)
+	got := killedCursorIDs(reply)
+	if len(got) != 1 || got[0] != 1 {
+		t.Fatalf("expected killed cursors [1], got %v", got)
+	}
+}
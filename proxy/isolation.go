@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/divjotarora/proxy/command"
+	"github.com/divjotarora/proxy/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+var (
+	// systemDatabaseCommands maps the names of shared system databases to the commands that can reference them.
+	// These databases are not prefixed, so any other command that references them is rejected.
+	systemDatabaseCommands = map[string]map[string]struct{}{
+		"admin":  adminDatabaseCommands(),
+		"config": {},
+		// Oplog reads are restricted to the tenant's entries.
+		"local": {
+			"find":    {},
+			"getMore": {},
+		},
+	}
+)
+
+// adminDatabaseCommands returns the commands that can reference the server's admin database: the commands that the
+// parser runs against admin and aggregate, because $currentOp aggregations run against admin. The parser restricts
+// the stages that can follow $currentOp.
+func adminDatabaseCommands() map[string]struct{} {
+	cmds := map[string]struct{}{
+		"aggregate": {},
+	}
+	for _, cmdName := range command.AdminCommands() {
+		cmds[cmdName] = struct{}{}
+	}
+	return cmds
+}
+
+// checkIsolation returns an Unauthorized error if the fixed request references a database that does not belong to
+// the tenant. Shared system databases can only be referenced by the commands in systemDatabaseCommands.
+func (p *Proxy) checkIsolation(ts *tenantState, cmdName string, fixedRequest bsoncore.Document) error {
+	for _, db := range command.ReferencedDatabases(cmdName, fixedRequest) {
+		if strings.HasPrefix(db, ts.tenant.DatabasePrefix()) {
+			continue
+		}
+		if allowed, ok := systemDatabaseCommands[db]; ok {
+			if _, ok := allowed[cmdName]; ok {
+				continue
+			}
+		}
+		return mongo.NewCommandError(mongo.CodeUnauthorized,
+			"command %s references database %s, which does not belong to tenant %s", cmdName, db, ts.tenant.Name)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/command"
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/tenant"
+)
+
+func TestCheckIsolation(t *testing.T) {
+	p := &Proxy{}
+	ts := &tenantState{
+		tenant: &tenant.Tenant{Name: "t1", Prefix: "t1"},
+	}
+
+	testCases := []struct {
+		name    string
+		request string // the fixed request
+		allowed bool
+	}{
+		{"tenant database", `{"find": "coll", "$db": "t1_db"}`, true},
+		{"tenant admin database", `{"create": "coll", "$db": "t1_admin"}`, true},
+		{"prefix of another tenant", `{"find": "coll", "$db": "t11_db"}`, false},
+		{"prefix without delimiter", `{"find": "coll", "$db": "t1db"}`, false},
+		{"unprefixed database", `{"find": "coll", "$db": "db"}`, false},
+		{
+			"stage referencing another tenant",
+			`{"aggregate": "coll", "pipeline": [{"$lookup": {"from": {"db": "t11_db", "coll": "c"}, "as": "x"}}],
+				"$db": "t1_db"}`,
+			false,
+		},
+		{
+			"admin command",
+			`{"renameCollection": "t1_db.a", "to": "t1_db.b", "$db": "admin"}`,
+			true,
+		},
+		{
+			"admin command renaming into another tenant",
+			`{"renameCollection": "t1_db.a", "to": "t11_db.b", "$db": "admin"}`,
+			false,
+		},
+		{"endSessions", `{"endSessions": [], "$db": "admin"}`, true},
+		{"$currentOp aggregate", `{"aggregate": 1, "pipeline": [{"$currentOp": {}}], "$db": "admin"}`, true},
+		{"other command on admin", `{"find": "system.users", "$db": "admin"}`, false},
+		{"oplog find", `{"find": "oplog.rs", "$db": "local"}`, true},
+		{"other command on local", `{"insert": "oplog.rs", "$db": "local"}`, false},
+		{"config", `{"find": "chunks", "$db": "config"}`, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := testutil.Document(t, tc.request)
+			err := p.checkIsolation(ts, request.Index(0).Key(), request)
+			if tc.allowed && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.allowed && err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+// TestAdminDatabaseCommands checks that every command the parser sends to the server's admin database passes the
+// isolation check.
+func TestAdminDatabaseCommands(t *testing.T) {
+	allowed := systemDatabaseCommands["admin"]
+	for _, cmdName := range command.AdminCommands() {
+		if _, ok := allowed[cmdName]; !ok {
+			t.Fatalf("admin command %s cannot reference the admin database", cmdName)
+		}
+	}
+}
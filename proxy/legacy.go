@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/divjotarora/proxy/connection"
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/address"
+)
+
+// noLastError is the getLastError reply for connections that have not sent a legacy write.
+var noLastError = bsoncore.BuildDocumentFromElements(nil,
+	bsoncore.AppendNullElement(nil, "err"),
+	bsoncore.AppendInt32Element(nil, "n", 0),
+	bsoncore.AppendInt32Element(nil, "ok", 1),
+)
+
+// handleGetLastError responds to a getLastError command with the result of the last legacy write on the connection.
+// Legacy writes are sent to the server as acknowledged write commands on pooled connections, so the server's own
+// getLastError would not know about them. Writes sent as OP_MSG commands are always acknowledged, so they do not
+// change the reply.
+func (p *Proxy) handleGetLastError(requestMsg mongowire.Message, conn *connection.Connection) mongowire.Message {
+	doc := conn.LastError()
+	if doc == nil {
+		doc = noLastError
+	}
+	return mongowire.NewReply(requestMsg, doc)
+}
+
+// lastErrorDocument converts the reply to a translated legacy write into the reply that getLastError returns for it.
+// The reply can be the server's reply or an error reply generated by the proxy. As on the server, getLastError itself
+// succeeds even if the write failed, and the failure is reported in the err and code fields.
+func lastErrorDocument(cmdName string, reply bsoncore.Document) bsoncore.Document {
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	if cerr, ok := writeReplyError(reply); ok {
+		doc = bsoncore.AppendStringElement(doc, "err", cerr.Message)
+		doc = bsoncore.AppendInt32Element(doc, "code", cerr.Code)
+		if cerr.CodeName != "" {
+			doc = bsoncore.AppendStringElement(doc, "codeName", cerr.CodeName)
+		}
+	} else {
+		doc = bsoncore.AppendNullElement(doc, "err")
+	}
+
+	n, _ := reply.Lookup("n").AsInt64OK()
+	doc = bsoncore.AppendInt32Element(doc, "n", int32(n))
+	if cmdName == "update" {
+		// An update statement that upserted a document is reported with the _id of the new document. Otherwise, n
+		// is the number of existing documents that matched.
+		if upsertedID, err := reply.LookupErr("upserted", "0", "_id"); err == nil {
+			doc = bsoncore.AppendBooleanElement(doc, "updatedExisting", false)
+			doc = bsoncore.AppendValueElement(doc, "upserted", upsertedID)
+		} else {
+			doc = bsoncore.AppendBooleanElement(doc, "updatedExisting", n > 0)
+		}
+	}
+	doc = bsoncore.AppendInt32Element(doc, "ok", 1)
+	doc, _ = bsoncore.AppendDocumentEnd(doc, idx)
+	return doc
+}
+
+// writeReplyError returns the error reported by a write command reply. This is the command error if the command
+// failed, otherwise the first write error, otherwise the write concern error.
+func writeReplyError(reply bsoncore.Document) (mongo.CommandError, bool) {
+	if err := mongo.ReplyDocumentError(reply); err != nil {
+		return mongo.AsCommandError(err, mongo.CodeInternalError), true
+	}
+	if writeErr, err := reply.LookupErr("writeErrors", "0"); err == nil {
+		if doc, ok := writeErr.DocumentOK(); ok {
+			return writeErrorDocument(doc), true
+		}
+	}
+	if wcErr, ok := reply.Lookup("writeConcernError").DocumentOK(); ok {
+		return writeErrorDocument(wcErr), true
+	}
+	return mongo.CommandError{}, false
+}
+
+// writeErrorDocument converts a writeErrors entry or writeConcernError document to a CommandError.
+func writeErrorDocument(doc bsoncore.Document) mongo.CommandError {
+	var cerr mongo.CommandError
+	cerr.Code, _ = doc.Lookup("code").AsInt32OK()
+	cerr.CodeName, _ = doc.Lookup("codeName").StringValueOK()
+	cerr.Message, _ = doc.Lookup("errmsg").StringValueOK()
+	return cerr
+}
+
+// handleLegacyKillCursors kills the cursors in a legacy OP_KILL_CURSORS request. The request does not name a
+// collection, so the cursors are grouped by the namespace and server recorded when they were created, and a
+// killCursors command is forwarded for each group without waiting for a reply. Unknown cursors are ignored. The client
+// does not wait for a reply, so errors are only logged.
+func (p *Proxy) handleLegacyKillCursors(cursorIDs []int64, conn *connection.Connection, ts *tenantState) error {
+	type cursorGroup struct {
+		ns   string
+		addr address.Address
+	}
+	var groups []cursorGroup
+	groupIDs := make(map[cursorGroup][]int64)
+	for _, cursorID := range cursorIDs {
+		cursor, ok := ts.backend.cursors.get(ts.tenant.Name, cursorID)
+		if !ok || cursor.ns == "" {
+			continue
+		}
+		group := cursorGroup{ns: cursor.ns, addr: cursor.addr}
+		if _, ok := groupIDs[group]; !ok {
+			groups = append(groups, group)
+		}
+		groupIDs[group] = append(groupIDs[group], cursorID)
+	}
+
+	for _, group := range groups {
+		db, coll := group.ns, ""
+		if idx := strings.IndexByte(group.ns, '.'); idx != -1 {
+			db, coll = group.ns[:idx], group.ns[idx+1:]
+		}
+
+		idx, cmd := bsoncore.AppendDocumentStart(nil)
+		cmd = bsoncore.AppendStringElement(cmd, "killCursors", coll)
+		arrIdx, cmd := bsoncore.AppendArrayElementStart(cmd, "cursors")
+		for i, cursorID := range groupIDs[group] {
+			cmd = bsoncore.AppendInt64Element(cmd, strconv.Itoa(i), cursorID)
+		}
+		cmd, _ = bsoncore.AppendArrayEnd(cmd, arrIdx)
+		cmd = bsoncore.AppendStringElement(cmd, "$db", db)
+		cmd, _ = bsoncore.AppendDocumentEnd(cmd, idx)
+
+		// The server does not reply to the command, so the cursors are removed once it has been sent. Their namespace
+		// and server were recorded when they were created, so the server will kill them.
+		msg := mongowire.NewUnacknowledgedCommand(cmd)
+		if err := p.handleProxiedRequest(msg, "killCursors", conn, ts); err != nil {
+			if err := p.handleRequestError(conn, msg, err); err != nil {
+				return err
+			}
+			continue
+		}
+		ts.backend.cursors.remove(ts.tenant.Name, groupIDs[group]...)
+	}
+	return nil
+}
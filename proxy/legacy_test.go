@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/divjotarora/proxy/connection"
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+)
+
+func TestLastErrorDocument(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cmdName  string
+		reply    string
+		expected string
+	}{
+		{
+			"insert",
+			"insert",
+			`{"n": 3, "ok": 1}`,
+			`{"err": null, "n": 3, "ok": 1}`,
+		},
+		{
+			"update matched",
+			"update",
+			`{"n": 2, "nModified": 1, "ok": 1}`,
+			`{"err": null, "n": 2, "updatedExisting": true, "ok": 1}`,
+		},
+		{
+			"update no match",
+			"update",
+			`{"n": 0, "nModified": 0, "ok": 1}`,
+			`{"err": null, "n": 0, "updatedExisting": false, "ok": 1}`,
+		},
+		{
+			"upsert",
+			"update",
+			`{"n": 1, "nModified": 0, "upserted": [{"index": 0, "_id": 7}], "ok": 1}`,
+			`{"err": null, "n": 1, "updatedExisting": false, "upserted": 7, "ok": 1}`,
+		},
+		{
+			"write error",
+			"insert",
+			`{"n": 0, "writeErrors": [{"index": 0, "code": 11000, "codeName": "DuplicateKey", ` +
+				`"errmsg": "duplicate key"}], "ok": 1}`,
+			`{"err": "duplicate key", "code": 11000, "codeName": "DuplicateKey", "n": 0, "ok": 1}`,
+		},
+		{
+			"write concern error",
+			"delete",
+			`{"n": 1, "writeConcernError": {"code": 64, "errmsg": "waiting for replication timed out"}, "ok": 1}`,
+			`{"err": "waiting for replication timed out", "code": 64, "n": 1, "ok": 1}`,
+		},
+		{
+			"command error",
+			"insert",
+			`{"ok": 0, "errmsg": "not authorized", "code": 13, "codeName": "Unauthorized"}`,
+			`{"err": "not authorized", "code": 13, "codeName": "Unauthorized", "n": 0, "ok": 1}`,
+		},
+		{
+			"proxy error reply",
+			"insert",
+			`{"ok": 0, "errmsg": "quota exceeded", "code": 12501, "codeName": "QuotaExceeded"}`,
+			`{"err": "quota exceeded", "code": 12501, "codeName": "QuotaExceeded", "n": 0, "ok": 1}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := lastErrorDocument(tc.cmdName, testutil.Document(t, tc.reply))
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(got, expected) {
+				t.Fatalf("expected %s, got %s", expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleGetLastError(t *testing.T) {
+	p := &Proxy{}
+	request := mongowire.NewCommand(testutil.Document(t, `{"getLastError": 1, "$db": "db"}`))
+
+	testCases := []struct {
+		name      string
+		lastError string // empty if the connection has not sent a legacy write
+		expected  string
+	}{
+		{"no legacy write", "", `{"err": null, "n": 0, "ok": 1}`},
+		{"legacy write", `{"err": null, "n": 1, "ok": 1}`, `{"err": null, "n": 1, "ok": 1}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &connection.Connection{}
+			if tc.lastError != "" {
+				conn.SetLastError(testutil.Document(t, tc.lastError))
+			}
+
+			reply := p.handleGetLastError(request, conn)
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(reply.CommandDocument(), expected) {
+				t.Fatalf("expected %s, got %s", expected, reply.CommandDocument())
+			}
+		})
+	}
+}
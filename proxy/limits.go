@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/ratelimit"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+var (
+	// writeCommands contains the names of commands that are limited using a tenant's write limits. All other commands
+	// are limited using the read limits.
+	writeCommands = map[string]struct{}{
+		"abortTransaction":  {},
+		"applyOps":          {},
+		"collMod":           {},
+		"commitTransaction": {},
+		"create":            {},
+		"createIndexes":     {},
+		"delete":            {},
+		"drop":              {},
+		"dropDatabase":      {},
+		"dropIndexes":       {},
+		"findAndModify":     {},
+		"insert":            {},
+		"mapReduce":         {},
+		"renameCollection":  {},
+		"update":            {},
+	}
+)
+
+// isWriteCommand returns true if the command should be limited using a tenant's write limits. An aggregate with a $out
+// or $merge stage is considered a write.
+func isWriteCommand(cmdName string, cmd bsoncore.Document) bool {
+	if cmdName == "aggregate" {
+		return hasWriteStage(cmd)
+	}
+
+	_, ok := writeCommands[cmdName]
+	return ok
+}
+
+// acquireLimit waits until the request can be admitted under the tenant's read or write limits. If the request is
+// admitted, the returned Limiter must be released once the request is complete. If the request is not admitted within
+// the tenant's LimitWait, a retryable IngressRequestRateLimitExceeded error is returned.
+func (p *Proxy) acquireLimit(ts *tenantState, cmdName string, cmd bsoncore.Document) (*ratelimit.Limiter, error) {
+	limiter := ts.readLimiter
+	if isWriteCommand(cmdName, cmd) {
+		limiter = ts.writeLimiter
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ts.tenant.LimitWait)
+	defer cancel()
+
+	if err := limiter.Acquire(ctx); err != nil {
+		cerr := mongo.NewCommandError(mongo.CodeIngressRequestRateLimitExceeded,
+			"%s request exceeded the rate limit for tenant %s", cmdName, ts.tenant.Name)
+		return nil, cerr.WithLabel(mongo.SystemOverloadedErrorLabel).WithLabel(mongo.RetryableErrorLabel)
+	}
+	return limiter, nil
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"sync/atomic"
+)
+
+// Metrics contains counters describing the traffic handled by a Proxy.
+type Metrics struct {
+	// UnacknowledgedWrites is the number of write requests with the moreToCome flag set that were forwarded to the
+	// server. Other requests with the flag set, such as killCursors, are not counted.
+	UnacknowledgedWrites int64
+	// UnacknowledgedWriteErrors is the number of write requests with the moreToCome flag set that could not be
+	// forwarded to the server. Clients are not notified of these errors because they do not wait for a reply.
+	UnacknowledgedWriteErrors int64
+}
+
+// metrics is the internal, concurrency-safe version of Metrics. All fields must be accessed atomically.
+type metrics struct {
+	unacknowledgedWrites      int64
+	unacknowledgedWriteErrors int64
+}
+
+func (m *metrics) snapshot() Metrics {
+	return Metrics{
+		UnacknowledgedWrites:      atomic.LoadInt64(&m.unacknowledgedWrites),
+		UnacknowledgedWriteErrors: atomic.LoadInt64(&m.unacknowledgedWriteErrors),
+	}
+}
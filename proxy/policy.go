@@ -0,0 +1,21 @@
+package proxy
+
+import (
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/policy"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// checkPolicy returns an Unauthorized error if the proxy's policy does not allow the tenant to run the command.
+func (p *Proxy) checkPolicy(ts *tenantState, cmdName string, cmd bsoncore.Document) error {
+	role := ts.tenant.Role
+	if role == "" {
+		role = policy.RoleTenant
+	}
+	db, _ := cmd.Lookup("$db").StringValueOK()
+
+	if !p.policy.Allowed(role, cmdName, db) {
+		return mongo.NewCommandError(mongo.CodeUnauthorized, "not authorized on %s to execute command %s", db, cmdName)
+	}
+	return nil
+}
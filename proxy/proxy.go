@@ -7,43 +7,88 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/divjotarora/proxy/command"
 	"github.com/divjotarora/proxy/connection"
 	conn "github.com/divjotarora/proxy/connection"
 	"github.com/divjotarora/proxy/mongo"
 	"github.com/divjotarora/proxy/mongo/mongowire"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/divjotarora/proxy/policy"
+	"github.com/divjotarora/proxy/tenant"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/address"
 )
 
-var (
-	emptyFixerSet = command.FixerSet{}
-)
+// Options configures a Proxy.
+type Options struct {
+	// Tenants determines the tenant for each client connection. If nil, all connections belong to tenant.Default.
+	Tenants tenant.Resolver
+	// Backend configures the clients used to connect to MongoDB deployments. If nil, the default options are used.
+	Backend *mongo.Options
+	// QuotaInterval is how often the storage usage of each tenant is computed to enforce storage quotas. If zero, a
+	// default of one minute is used.
+	QuotaInterval time.Duration
+	// Policy decides which commands tenants can run. If nil, policy.Default() is used.
+	Policy *policy.Policy
+}
 
 // Proxy represents a network proxy that sits between a client and a MongoDB server.
 type Proxy struct {
-	network   string
-	address   string
-	client    *mongo.Client
-	parser    *command.Parser
-	wg        sync.WaitGroup
-	cursorMap map[int64]string // cursor ID -> originating command name
+	network  string
+	address  string
+	resolver tenant.Resolver
+	policy   *policy.Policy
+	wg       sync.WaitGroup
+
+	quotaInterval time.Duration
+	metrics       metrics
+
+	defaultURI  string
+	backendOpts *mongo.Options
+	backendsMu  sync.Mutex
+	backends    map[string]*backendEntry // URI -> backend
+
+	tenantsMu sync.Mutex
+	tenants   map[string]*tenantState // tenant name -> state
 }
 
-// NewProxy creates a new Proxy instance.
-func NewProxy(network, address string, clientOpts *options.ClientOptions) (*Proxy, error) {
-	client, err := mongo.NewClient(context.TODO(), clientOpts)
-	if err != nil {
-		return nil, err
+// NewProxy creates a new Proxy instance. Requests are proxied to the MongoDB deployment at the given URI unless the
+// connection's tenant specifies a different deployment. The opts parameter can be nil to use the default options.
+func NewProxy(network, address, uri string, opts *Options) (*Proxy, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	resolver := opts.Tenants
+	if resolver == nil {
+		resolver = tenant.StaticResolver(tenant.Default)
 	}
 
 	p := &Proxy{
-		network:   network,
-		address:   address,
-		client:    client,
-		parser:    command.NewParser(),
-		cursorMap: make(map[int64]string),
+		network:     network,
+		address:     address,
+		resolver:    resolver,
+		defaultURI:  uri,
+		backendOpts: opts.Backend,
+		backends:    make(map[string]*backendEntry),
+		tenants:     make(map[string]*tenantState),
+	}
+	if opts.Policy != nil {
+		p.policy = opts.Policy
+	} else {
+		p.policy = policy.Default()
+	}
+	p.quotaInterval = opts.QuotaInterval
+	if p.quotaInterval == 0 {
+		p.quotaInterval = defaultQuotaInterval
+	}
+
+	// Connect to the default deployment immediately so configuration errors are reported at startup. Other
+	// deployments are connected to when they are first used by a tenant.
+	if _, err := p.getBackend(uri); err != nil {
+		return nil, err
 	}
 	return p, nil
 }
@@ -59,6 +104,8 @@ func (p *Proxy) Run() error {
 		_ = listener.Close()
 	}()
 
+	go p.accountStorage(p.quotaInterval)
+
 	log.Println("waiting for new connections")
 	for {
 		nc, err := listener.Accept()
@@ -74,12 +121,25 @@ func (p *Proxy) Run() error {
 				_ = nc.Close()
 			}()
 
+			t, err := p.resolver.Resolve(nc)
+			if err != nil {
+				log.Printf("error resolving tenant for connection: %v\n", err)
+				return
+			}
+			ts, err := p.getTenantState(t)
+			if err != nil {
+				log.Printf("error setting up tenant %s: %v\n", t.Name, err)
+				return
+			}
+
 			userConn, err := conn.NewConn(nc)
 			if err != nil {
 				log.Printf("error establishing user connection: %v\n", err)
 				return
 			}
-			if err := p.handleConnection(userConn); err != nil {
+			defer p.closeConnection(userConn, ts)
+
+			if err := p.handleConnection(userConn, ts); err != nil {
 				if errors.Is(err, connection.ErrClientHungUp) {
 					log.Println("connection closed by client")
 					return
@@ -91,15 +151,29 @@ func (p *Proxy) Run() error {
 	}
 }
 
-func (p *Proxy) handleConnection(conn *conn.Connection) error {
+// Metrics returns a snapshot of the proxy's traffic counters.
+func (p *Proxy) Metrics() Metrics {
+	return p.metrics.snapshot()
+}
+
+func (p *Proxy) handleConnection(conn *conn.Connection, ts *tenantState) error {
 	for {
-		if err := p.handleRequest(conn); err != nil {
+		if err := p.handleRequest(conn, ts); err != nil {
 			return err
 		}
 	}
 }
 
-func (p *Proxy) handleRequest(conn *conn.Connection) error {
+// closeConnection cleans up the state associated with a client connection after it has been closed. Server sessions
+// that are no longer used by any of the tenant's connections are ended after aborting any transactions in progress on
+// them.
+func (p *Proxy) closeConnection(conn *conn.Connection, ts *tenantState) {
+	unusedSessions := ts.sessions.Detach(conn.ID())
+	p.abortTransactions(ts, unusedSessions)
+	p.endSessions(ts, unusedSessions)
+}
+
+func (p *Proxy) handleRequest(conn *conn.Connection, ts *tenantState) error {
 	msgBytes, err := conn.ReadWireMessage(nil)
 	if err != nil {
 		return err
@@ -110,89 +184,394 @@ func (p *Proxy) handleRequest(conn *conn.Connection) error {
 		return err
 	}
 
-	cmd := msg.CommandDocument()
+	if cursorIDs, ok := mongowire.LegacyKillCursors(msg); ok {
+		return p.handleLegacyKillCursors(cursorIDs, conn, ts)
+	}
+
+	// Commands that the server accepts under several names are renamed once here so the policy, fixers, isolation
+	// checks, quotas, and limits only need to handle the canonical name.
+	cmd := command.Canonicalize(msg.CommandDocument())
+	mongowire.SetCommandDocument(msg, cmd)
 
 	switch cmdName := cmd.Index(0).Key(); cmdName {
-	case "isMaster", "ismaster":
-		heartbeatResponse := mongowire.HeartbeatIsMasterResponse(msg.RequestID())
+	case "isMaster":
+		heartbeatResponse := mongowire.HeartbeatIsMasterResponse(msg)
 		return conn.WriteWireMessage(heartbeatResponse.Encode())
+	case "getTenantUsage", "connectionStatus", "whatsmyuri", "getLastError", "listDatabases":
+		if err := p.handleInterceptedRequest(msg, cmdName, conn, ts); err != nil {
+			return p.handleRequestError(conn, msg, err)
+		}
+		return nil
 	default:
-		return p.handleProxiedRequest(msg, cmdName, conn)
+		if err := p.handleProxiedRequest(msg, cmdName, conn, ts); err != nil {
+			return p.handleRequestError(conn, msg, err)
+		}
+		return nil
 	}
 }
 
-func (p *Proxy) handleProxiedRequest(requestMsg mongowire.Message, cmdName string, conn *connection.Connection) error {
-	fixerSet, err := p.getFixerSet(cmdName, requestMsg.CommandDocument())
+// handleInterceptedRequest answers a command that the proxy handles itself instead of forwarding it to the server. The
+// command is subject to the same policy and rate limits as proxied commands.
+func (p *Proxy) handleInterceptedRequest(requestMsg mongowire.Message, cmdName string, conn *connection.Connection,
+	ts *tenantState) error {
+
+	if err := p.checkPolicy(ts, cmdName, requestMsg.CommandDocument()); err != nil {
+		return err
+	}
+	limiter, err := p.acquireLimit(ts, cmdName, requestMsg.CommandDocument())
 	if err != nil {
 		return err
 	}
+	defer limiter.Release()
+
+	var reply mongowire.Message
+	switch cmdName {
+	case "getTenantUsage":
+		reply = p.handleTenantUsage(requestMsg, ts)
+	case "connectionStatus":
+		reply = p.handleConnectionStatus(requestMsg)
+	case "whatsmyuri":
+		reply = p.handleWhatsMyURI(requestMsg, conn)
+	case "getLastError":
+		reply = p.handleGetLastError(requestMsg, conn)
+	case "listDatabases":
+		if reply, err = p.handleListDatabases(requestMsg, ts); err != nil {
+			return err
+		}
+	default:
+		return mongo.NewCommandError(mongo.CodeInternalError, "no handler for command %s", cmdName)
+	}
+	return conn.WriteWireMessage(reply.Encode())
+}
+
+// handleRequestError sends the client an error reply for a request that failed so the connection can continue to be
+// used. Errors that are not CommandErrors are reported as InternalError. If the error was caused by a failure to write
+// to the client, it is returned so the connection is closed.
+func (p *Proxy) handleRequestError(conn *connection.Connection, requestMsg mongowire.Message, err error) error {
+	var writeErr connection.WriteError
+	if errors.As(err, &writeErr) {
+		return err
+	}
+
+	cerr := mongo.AsCommandError(err, mongo.CodeInternalError)
+	log.Printf("error handling request: %v\n", cerr)
+
+	// The client does not wait for a reply to a moreToCome request, so sending one would desync the connection.
+	// Failed legacy writes are reported by the next getLastError instead.
+	if requestMsg.MoreToCome() {
+		return nil
+	}
+	if mongowire.IsLegacyWrite(requestMsg) {
+		cmdName := requestMsg.CommandDocument().Index(0).Key()
+		conn.SetLastError(lastErrorDocument(cmdName, cerr.Document()))
+		return nil
+	}
+	return conn.WriteWireMessage(mongowire.NewReply(requestMsg, cerr.Document()).Encode())
+}
+
+// proxiedRequest holds the state for a single request that is being proxied to the server.
+type proxiedRequest struct {
+	msg          mongowire.Message
+	cmdName      string
+	fixerName    string
+	fixerSet     command.FixerSet
+	fixedRequest bsoncore.Document
+	conn         *connection.Connection
+	tenant       *tenantState
+	txn          *transaction
+	cursor       *cursorInfo // cursor referenced by a getMore or killCursors request
+	serverAddr   address.Address
+}
+
+func (p *Proxy) handleProxiedRequest(requestMsg mongowire.Message, cmdName string, conn *connection.Connection,
+	ts *tenantState) error {
+
+	if err := p.checkPolicy(ts, cmdName, requestMsg.CommandDocument()); err != nil {
+		return err
+	}
+	if cmdName == "killOp" {
+		if err := p.checkKillOp(ts, requestMsg.CommandDocument()); err != nil {
+			return err
+		}
+	}
+
+	cursor, err := ts.backend.cursors.lookup(ts.tenant.Name, cmdName, requestMsg.CommandDocument())
+	if err != nil {
+		return err
+	}
+
+	if err := p.checkQuota(ts, cmdName, requestMsg.CommandDocument()); err != nil {
+		return err
+	}
+
+	limiter, err := p.acquireLimit(ts, cmdName, requestMsg.CommandDocument())
+	if err != nil {
+		return err
+	}
+	defer limiter.Release()
+	fixerName, fixerSet := p.getFixerSet(ts, cmdName, requestMsg.CommandDocument(), cursor)
+
+	// Record that this connection is using the request's session so the server session can be ended once no
+	// connections are using it.
+	if _, clientSessionID, ok := requestMsg.CommandDocument().Lookup("lsid", "id").BinaryOK(); ok {
+		ts.sessions.Attach(conn.ID(), clientSessionID)
+	}
 
 	// Get a wire message for the fixed request.
 	fixedRequest, err := fixerSet.FixRequest(requestMsg.CommandDocument())
 	if err != nil {
+		return mongo.AsCommandError(err, mongo.CodeBadValue)
+	}
+	if err := requestMsg.FixDocumentSequences(fixerSet.FixRequestSequenceDocument); err != nil {
+		return mongo.AsCommandError(err, mongo.CodeBadValue)
+	}
+	if err := p.checkIsolation(ts, cmdName, fixedRequest); err != nil {
 		return err
 	}
-	encodedRequest := requestMsg.EncodeFixed(fixedRequest)
 
-	// Send the fixed request to the server and get a response.
-	responseBytes, err := p.client.RoundTrip(context.TODO(), encodedRequest)
+	txn, err := p.trackTransaction(ts, fixedRequest)
 	if err != nil {
+		return mongo.AsCommandError(err, mongo.CodeHostUnreachable)
+	}
+
+	req := &proxiedRequest{
+		msg:          requestMsg,
+		cmdName:      cmdName,
+		fixerName:    fixerName,
+		fixerSet:     fixerSet,
+		fixedRequest: fixedRequest,
+		conn:         conn,
+		tenant:       ts,
+		txn:          txn,
+		cursor:       cursor,
+	}
+	if err := p.forwardRequest(req); err != nil {
+		return err
+	}
+
+	if cmdName == "endSessions" {
+		// Sessions ended by the client no longer need to be tracked.
+		forgetSessions(ts, requestMsg.CommandDocument())
+	}
+	return nil
+}
+
+// checkoutConnection returns the connection that should be used to send req to the server and a function that must
+// be called to release it after the request is complete. Requests in a transaction that is pinned to a connection use
+// the pinned connection, requests for an existing cursor go to the server that owns the cursor, and all other
+// requests go to a server selected using the request's read preference.
+func (p *Proxy) checkoutConnection(req *proxiedRequest) (*mongo.Conn, func(), error) {
+	if req.txn != nil {
+		req.txn.mu.Lock()
+		if req.txn.conn != nil {
+			return req.txn.conn, req.txn.mu.Unlock, nil
+		}
+		req.txn.mu.Unlock()
+	}
+
+	var conn *mongo.Conn
+	var err error
+	if req.cursor != nil {
+		conn, err = req.tenant.backend.client.CheckoutAddress(context.TODO(), req.cursor.addr)
+	} else {
+		var rp *readpref.ReadPref
+		if rp, err = mongo.ReadPrefFromCommand(req.msg.CommandDocument()); err != nil {
+			return nil, nil, mongo.AsCommandError(err, mongo.CodeBadValue)
+		}
+		conn, err = req.tenant.backend.client.Checkout(context.TODO(), rp)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, func() { _ = conn.Close() }, nil
+}
+
+// forwardRequest sends a fixed request to the server and writes the fixed response(s) back to the client. If the
+// request fails because of a network error or a transient server error and it is retryable, it is retried once on a
+// newly selected server. If communication with the server fails, a HostUnreachable CommandError is returned.
+func (p *Proxy) forwardRequest(req *proxiedRequest) error {
+	retryable := isRetryable(req)
+
+	err := p.forwardRequestOnce(req, retryable)
+	if retryable && isRetryableError(err) {
+		err = p.forwardRequestOnce(req, false)
+	}
+
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case retryableReplyError:
+		// The retry was skipped or also failed, so forward the server's error reply as-is.
+		return p.handleResponse(req, e.reply)
+	case backendError:
+		// Drivers treat HostUnreachable errors as transient. Network errors in a transaction also need the
+		// TransientTransactionError label so the driver knows it can retry the whole transaction.
+		cerr := mongo.AsCommandError(e.err, mongo.CodeHostUnreachable)
+		if req.txn != nil && mongo.IsNetworkError(e.err) {
+			cerr = cerr.WithLabel(mongo.TransientTransactionErrorLabel)
+		}
+		return cerr
+	default:
+		return err
+	}
+}
+
+// forwardRequestOnce makes a single attempt to forward a request. If allowRetry is true and the server replies with a
+// transient error, the reply is returned in a retryableReplyError rather than being forwarded to the client. If
+// communication with the server fails before any reply has been forwarded, the error is returned as a backendError.
+func (p *Proxy) forwardRequestOnce(req *proxiedRequest, allowRetry bool) error {
+	serverConn, release, err := p.checkoutConnection(req)
+	if err != nil {
+		return backendError{err}
+	}
+	defer release()
+	req.serverAddr = serverConn.Address()
+
+	encodedRequest := req.msg.EncodeFixed(req.fixedRequest)
+
+	// If the request has the moreToCome flag set (e.g. a w:0 write), the client will not wait for a reply, so the
+	// request is forwarded without reading anything back from the server. Errors are logged rather than returned
+	// because there is no way to report them to the client and they should not cause the connection to be closed. Only
+	// writes are counted in the metrics, not other requests such as the killCursors commands sent for OP_KILL_CURSORS.
+	if req.msg.MoreToCome() {
+		isWrite := isWriteCommand(req.cmdName, req.fixedRequest)
+		if isWrite {
+			atomic.AddInt64(&p.metrics.unacknowledgedWrites, 1)
+		}
+		if err := serverConn.Send(context.TODO(), encodedRequest); err != nil {
+			if isWrite {
+				atomic.AddInt64(&p.metrics.unacknowledgedWriteErrors, 1)
+			}
+			log.Printf("error sending unacknowledged %s request: %v\n", req.cmdName, err)
+		}
+		return nil
+	}
+
+	// Send the fixed request to the server and handle each response. The server can stream multiple responses back if
+	// the request allowed exhaust, so every response is fixed and forwarded as it arrives.
+	var forwarded bool
+	var retryReply []byte
+	err = serverConn.Stream(context.TODO(), encodedRequest, func(responseBytes []byte) error {
+		if allowRetry && !forwarded {
+			if cerr, ok := mongo.ReplyError(responseBytes).(mongo.CommandError); ok && cerr.Retryable() {
+				// Error replies never have moreToCome set, so this is the last reply in the stream.
+				retryReply = responseBytes
+				return nil
+			}
+		}
+
+		forwarded = true
+		return p.handleResponse(req, responseBytes)
+	})
+
+	switch {
+	case err == nil && retryReply != nil:
+		return retryableReplyError{retryReply}
+	case err != nil && !forwarded && mongo.IsNetworkError(err):
+		return backendError{err}
+	default:
 		return err
 	}
+}
+
+func (p *Proxy) handleResponse(req *proxiedRequest, responseBytes []byte) error {
 	responseMsg, err := mongowire.Decode(responseBytes)
 	if err != nil {
 		return err
 	}
 
+	fixedResponse, err := req.fixerSet.FixResponse(responseMsg.CommandDocument())
+	if err != nil {
+		return mongo.AsCommandError(err, mongo.CodeInternalError)
+	}
+
+	// Legacy clients need the position of each getMore batch in the cursor's results, so the number of documents
+	// returned by legacy cursors is tracked.
+	var batchLength int32
+	if mongowire.IsLegacy(req.msg) {
+		batchLength = getBatchLength(fixedResponse)
+	}
+
 	cursorID := getCursorID(responseMsg.CommandDocument())
-	if cmdName == "getMore" {
+	if req.cmdName == "getMore" {
+		requestedID := req.fixedRequest.Index(0).Value().Int64()
+		mongowire.SetStartingFrom(req.msg, req.tenant.backend.cursors.advance(requestedID, batchLength))
+
 		// If this is the last getMore on the cursor, remove the cursor from the map.
 		if cursorID == 0 {
-			delete(p.cursorMap, fixedRequest.Index(0).Value().Int64())
+			req.tenant.backend.cursors.remove(req.tenant.tenant.Name, requestedID)
 		}
+	} else if req.cmdName == "killCursors" {
+		// Only cursors that the server reports as killed are removed. Cursors that could not be killed are still open.
+		req.tenant.backend.cursors.remove(req.tenant.tenant.Name, killedCursorIDs(responseMsg.CommandDocument())...)
 	} else if cursorID != 0 {
-		// If the response has a cursor ID, this is a cursor-creating command. Track the ID and command name so we
-		// know how to fix future getMore responses.
-		p.cursorMap[cursorID] = cmdName
+		// If the response has a cursor ID, this is a cursor-creating command. Track the ID, FixerSet name, server, and
+		// namespace so we know how to route, fix, and kill the cursor in future requests.
+		ns, _ := fixedResponse.Lookup("cursor", "ns").StringValueOK()
+		req.tenant.backend.cursors.put(cursorID, cursorInfo{
+			owner:     req.tenant.tenant.Name,
+			fixerName: req.fixerName,
+			addr:      req.serverAddr,
+			ns:        ns,
+			returned:  batchLength,
+		})
 	}
 
-	// Get a wire message for the fixed response and send that back to the client.
-	fixedResponse, err := fixerSet.FixResponse(responseMsg.CommandDocument())
-	if err != nil {
-		return err
+	switch req.cmdName {
+	case "commitTransaction", "abortTransaction":
+		// Stop tracking a committed or aborted transaction unless the client can still retry the command.
+		if req.txn != nil && transactionFinished(responseMsg.CommandDocument()) {
+			p.finishTransaction(req.tenant, req.txn)
+		}
+	case "startSession":
+		// The server chose the session ID, so it is registered as-is and ended when this connection closes unless
+		// other connections are using it or the client ends it first.
+		if _, serverSessionID, ok := fixedResponse.Lookup("id", "id").BinaryOK(); ok {
+			req.tenant.sessions.AttachStarted(req.conn.ID(), serverSessionID)
+		}
 	}
-	encodedResponse := responseMsg.EncodeFixed(fixedResponse)
-	return conn.WriteWireMessage(encodedResponse)
+
+	// Send the fixed response back to the client. Responses to legacy requests are converted to OP_REPLY. Clients do
+	// not wait for a reply to legacy writes, so the result is kept for getLastError instead.
+	if mongowire.IsLegacyWrite(req.msg) {
+		req.conn.SetLastError(lastErrorDocument(req.cmdName, fixedResponse))
+		return nil
+	}
+	encodedResponse := mongowire.EncodeReply(req.msg, responseMsg, fixedResponse)
+	return req.conn.WriteWireMessage(encodedResponse)
 }
 
-func (p *Proxy) getFixerSet(cmdName string, doc bsoncore.Document) (command.FixerSet, error) {
-	// For getMore requests, get the fixer set for the originating command.
-	if cmdName == "getMore" {
-		cursorIDVal := doc.Index(0).Value()
-		cursorID, ok := cursorIDVal.Int64OK()
-		if !ok {
-			return emptyFixerSet, fmt.Errorf("expected getMore value to be int64, got %s", cursorIDVal.Type)
-		}
+// getFixerSet returns the FixerSet for a request and the name it was registered under. For getMore requests, the
+// FixerSet for the command that created the cursor is used.
+func (p *Proxy) getFixerSet(ts *tenantState, cmdName string, cmd bsoncore.Document,
+	cursor *cursorInfo) (string, command.FixerSet) {
 
-		originalCmdName, ok := p.cursorMap[cursorID]
-		if !ok {
-			return emptyFixerSet, fmt.Errorf("dangling cursor ID %v", cursorID)
-		}
-		cmdName = originalCmdName
+	fixerName := command.FixerName(cmdName, cmd)
+	if cmdName == "getMore" {
+		fixerName = cursor.fixerName
 	}
-
-	return p.parser.Parse(cmdName), nil
+	return fixerName, ts.parser.Parse(fixerName)
 }
 
-func getCursorID(doc bsoncore.Document) int64 {
-	cursorIDVal, err := doc.LookupErr("cursor", "id")
+// forgetSessions removes the sessions in an endSessions command from the tenant's session registry.
+func forgetSessions(ts *tenantState, doc bsoncore.Document) {
+	lsids, ok := doc.Index(0).Value().ArrayOK()
+	if !ok {
+		return
+	}
+	values, err := lsids.Values()
 	if err != nil {
-		return 0
+		return
 	}
 
-	cursorID, ok := cursorIDVal.Int64OK()
-	if !ok {
-		return 0
+	for _, lsidVal := range values {
+		lsid, ok := lsidVal.DocumentOK()
+		if !ok {
+			continue
+		}
+		if _, clientSessionID, ok := lsid.Lookup("id").BinaryOK(); ok {
+			ts.sessions.Forget(clientSessionID)
+		}
 	}
-	return cursorID
 }
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/divjotarora/proxy/connection"
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+func TestHandleRequestError(t *testing.T) {
+	find := mongowire.NewCommand(testutil.Document(t, `{"find": "coll", "$db": "db"}`))
+	unacknowledged := mongowire.NewUnacknowledgedCommand(testutil.Document(t, `{"insert": "coll", "$db": "db"}`))
+	legacyInsert, err := mongowire.Decode(legacyInsertMessage(t, "db.coll", testutil.Document(t, `{"x": 1}`)))
+	if err != nil {
+		t.Fatalf("error decoding OP_INSERT: %v", err)
+	}
+
+	cerr := mongo.NewCommandError(mongo.CodeQuotaExceeded, "storage quota exceeded")
+	writeErr := connection.WriteError{Err: errors.New("broken pipe")}
+
+	testCases := []struct {
+		name      string
+		request   mongowire.Message
+		err       error
+		reply     bsoncore.Document // expected reply to the client, or nil if none should be sent
+		lastError bsoncore.Document // expected getLastError document for the connection
+		returned  error             // expected error returned to close the connection
+	}{
+		{
+			name:    "command error",
+			request: find,
+			err:     cerr,
+			reply:   cerr.Document(),
+		},
+		{
+			name:    "wrapped command error",
+			request: find,
+			err:     fmt.Errorf("fixing request: %w", cerr),
+			reply:   cerr.Document(),
+		},
+		{
+			name:    "other error",
+			request: find,
+			err:     errors.New("boom"),
+			reply:   mongo.NewCommandError(mongo.CodeInternalError, "boom").Document(),
+		},
+		{
+			name:    "moreToCome",
+			request: unacknowledged,
+			err:     cerr,
+		},
+		{
+			name:    "legacy write",
+			request: legacyInsert,
+			err:     cerr,
+			lastError: testutil.Document(t, `{"err": "storage quota exceeded", "code": 12501, `+
+				`"codeName": "QuotaExceeded", "n": 0, "ok": 1}`),
+		},
+		{
+			name:     "client write error",
+			request:  find,
+			err:      writeErr,
+			returned: writeErr,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			written := make(chan []byte, 1)
+			go func() {
+				wm, _ := ioutil.ReadAll(client)
+				written <- wm
+			}()
+
+			conn := &connection.Connection{Conn: server}
+			err := (&Proxy{}).handleRequestError(conn, tc.request, tc.err)
+			_ = server.Close()
+			wm := <-written
+
+			if err != tc.returned {
+				t.Fatalf("returned error mismatch; got %v, want %v", err, tc.returned)
+			}
+			if !bytes.Equal(conn.LastError(), tc.lastError) {
+				t.Fatalf("last error mismatch; got %s, want %s", conn.LastError(), tc.lastError)
+			}
+			if tc.reply == nil {
+				if len(wm) != 0 {
+					t.Fatalf("expected no reply, got %d bytes", len(wm))
+				}
+				return
+			}
+
+			reply, err := mongowire.Decode(wm)
+			if err != nil {
+				t.Fatalf("error decoding reply: %v", err)
+			}
+			if got := reply.CommandDocument(); !bytes.Equal(got, tc.reply) {
+				t.Fatalf("reply mismatch; got %s, want %s", got, tc.reply)
+			}
+		})
+	}
+}
+
+// legacyInsertMessage creates an OP_INSERT wire message that inserts the given documents into ns.
+func legacyInsertMessage(t *testing.T, ns string, documents ...bsoncore.Document) []byte {
+	t.Helper()
+
+	idx, wm := wiremessage.AppendHeaderStart(nil, 1, 0, wiremessage.OpInsert)
+	wm = append(wm, 0, 0, 0, 0) // flags
+	wm = append(append(wm, ns...), 0)
+	for _, doc := range documents {
+		wm = append(wm, doc...)
+	}
+	return bsoncore.UpdateLength(wm, idx, int32(len(wm[idx:])))
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+var (
+	defaultQuotaInterval = time.Minute
+
+	// storageUsageTimeout bounds the time spent computing the storage usage of a single tenant.
+	storageUsageTimeout = 30 * time.Second
+
+	// quotaWriteCommands contains the names of commands that are rejected once a tenant exceeds its storage quota.
+	quotaWriteCommands = map[string]struct{}{
+		"findAndModify": {},
+		"insert":        {},
+		"update":        {},
+	}
+
+	// databaseCreatingCommands contains the names of commands that are rejected if they would create a new database
+	// once a tenant has reached its database limit.
+	databaseCreatingCommands = map[string]struct{}{
+		"create":        {},
+		"createIndexes": {},
+	}
+)
+
+// storageUsage holds the most recently computed storage usage for a tenant. This type is safe for concurrent use.
+type storageUsage struct {
+	mu        sync.Mutex
+	bytes     int64
+	databases map[string]struct{} // prefixed database names
+	updated   time.Time
+}
+
+func (su *storageUsage) set(bytes int64, databases map[string]struct{}) {
+	su.mu.Lock()
+	defer su.mu.Unlock()
+
+	su.bytes = bytes
+	su.databases = databases
+	su.updated = time.Now()
+}
+
+// accountStorage periodically updates the storage usage for every tenant that has connected to the proxy. Each
+// tenant's usage is first computed when its state is created (see getTenantState). This method blocks indefinitely.
+func (p *Proxy) accountStorage(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.updateAllStorageUsage()
+	}
+}
+
+// updateAllStorageUsage runs one accounting pass over every tenant that has connected to the proxy.
+func (p *Proxy) updateAllStorageUsage() {
+	p.tenantsMu.Lock()
+	tenants := make([]*tenantState, 0, len(p.tenants))
+	for _, ts := range p.tenants {
+		tenants = append(tenants, ts)
+	}
+	p.tenantsMu.Unlock()
+
+	for _, ts := range tenants {
+		p.updateStorageUsageWithTimeout(ts)
+	}
+}
+
+// updateStorageUsageWithTimeout updates the tenant's storage usage, giving up after storageUsageTimeout so a slow
+// tenant does not hold up accounting for the others. Errors are logged and the previous usage is kept.
+func (p *Proxy) updateStorageUsageWithTimeout(ts *tenantState) {
+	ctx, cancel := context.WithTimeout(context.Background(), storageUsageTimeout)
+	defer cancel()
+
+	if err := p.updateStorageUsage(ctx, ts); err != nil {
+		log.Printf("error computing storage usage for tenant %s: %v\n", ts.tenant.Name, err)
+	}
+}
+
+// updateStorageUsage sums the storage and index sizes reported by dbStats for all of the tenant's databases.
+func (p *Proxy) updateStorageUsage(ctx context.Context, ts *tenantState) error {
+	client := ts.backend.client
+	listDatabasesCmd := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendInt32Element(nil, "listDatabases", 1),
+		bsoncore.AppendBooleanElement(nil, "nameOnly", true),
+		bsoncore.AppendDocumentElement(nil, "filter", bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.AppendRegexElement(nil, "name", "^"+regexp.QuoteMeta(ts.tenant.DatabasePrefix()), ""),
+		)),
+		bsoncore.AppendStringElement(nil, "$db", "admin"),
+	)
+	reply, err := client.RunCommand(ctx, listDatabasesCmd)
+	if err != nil {
+		return err
+	}
+
+	dbsArr, _ := reply.Lookup("databases").ArrayOK()
+	dbs, err := dbsArr.Values()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	databases := make(map[string]struct{}, len(dbs))
+	for _, dbVal := range dbs {
+		dbDoc, ok := dbVal.DocumentOK()
+		if !ok {
+			continue
+		}
+		name, ok := dbDoc.Lookup("name").StringValueOK()
+		if !ok {
+			continue
+		}
+		databases[name] = struct{}{}
+
+		dbStatsCmd := bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.AppendInt32Element(nil, "dbStats", 1),
+			bsoncore.AppendStringElement(nil, "$db", name),
+		)
+		stats, err := client.RunCommand(ctx, dbStatsCmd)
+		if err != nil {
+			return err
+		}
+		storageSize, _ := stats.Lookup("storageSize").AsInt64OK()
+		indexSize, _ := stats.Lookup("indexSize").AsInt64OK()
+		total += storageSize + indexSize
+	}
+
+	ts.usage.set(total, databases)
+	return nil
+}
+
+// checkQuota returns a QuotaExceeded error if the request is a write and the tenant has exceeded its storage quota or
+// the request would create a new database and the tenant has reached its database limit.
+func (p *Proxy) checkQuota(ts *tenantState, cmdName string, cmd bsoncore.Document) error {
+	_, isQuotaWrite := quotaWriteCommands[cmdName]
+	_, isDatabaseCreating := databaseCreatingCommands[cmdName]
+	if !isQuotaWrite && !isDatabaseCreating {
+		return nil
+	}
+
+	ts.usage.mu.Lock()
+	defer ts.usage.mu.Unlock()
+
+	if quota := ts.tenant.StorageQuota; isQuotaWrite && quota > 0 && ts.usage.bytes >= quota {
+		return mongo.NewCommandError(mongo.CodeQuotaExceeded, "storage quota of %d bytes exceeded: %d bytes used",
+			quota, ts.usage.bytes)
+	}
+
+	if maxDBs := ts.tenant.MaxDatabases; isDatabaseCreating && maxDBs > 0 && len(ts.usage.databases) >= maxDBs {
+		db, _ := cmd.Lookup("$db").StringValueOK()
+		if _, exists := ts.usage.databases[ts.tenant.DatabasePrefix()+db]; !exists {
+			return mongo.NewCommandError(mongo.CodeQuotaExceeded, "database limit of %d reached", maxDBs)
+		}
+	}
+	return nil
+}
+
+// handleTenantUsage responds to a getTenantUsage command with the tenant's most recently computed storage usage and
+// its limits.
+func (p *Proxy) handleTenantUsage(requestMsg mongowire.Message, ts *tenantState) mongowire.Message {
+	ts.usage.mu.Lock()
+	defer ts.usage.mu.Unlock()
+
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	doc = bsoncore.AppendStringElement(doc, "tenant", ts.tenant.Name)
+	doc = bsoncore.AppendInt64Element(doc, "storageSize", ts.usage.bytes)
+	doc = bsoncore.AppendInt64Element(doc, "storageQuota", ts.tenant.StorageQuota)
+	doc = bsoncore.AppendInt32Element(doc, "databases", int32(len(ts.usage.databases)))
+	doc = bsoncore.AppendInt32Element(doc, "maxDatabases", int32(ts.tenant.MaxDatabases))
+	if !ts.usage.updated.IsZero() {
+		doc = bsoncore.AppendDateTimeElement(doc, "lastUpdated", ts.usage.updated.UnixNano()/int64(time.Millisecond))
+	}
+	doc = bsoncore.AppendInt32Element(doc, "ok", 1)
+	doc, _ = bsoncore.AppendDocumentEnd(doc, idx)
+
+	return mongowire.NewReply(requestMsg, doc)
+}
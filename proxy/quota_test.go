@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+	"github.com/divjotarora/proxy/tenant"
+)
+
+func TestCheckQuota(t *testing.T) {
+	p := &Proxy{}
+	limited := &tenant.Tenant{Name: "t1", Prefix: "t1", StorageQuota: 100, MaxDatabases: 2}
+	unlimited := &tenant.Tenant{Name: "t1", Prefix: "t1"}
+
+	testCases := []struct {
+		name      string
+		tenant    *tenant.Tenant
+		bytes     int64
+		databases []string // prefixed database names
+		request   string
+		allowed   bool
+	}{
+		{"write under quota", limited, 99, nil, `{"insert": "coll", "$db": "db"}`, true},
+		{"insert at quota", limited, 100, nil, `{"insert": "coll", "$db": "db"}`, false},
+		{"update over quota", limited, 101, nil, `{"update": "coll", "$db": "db"}`, false},
+		{"findAndModify over quota", limited, 101, nil, `{"findAndModify": "coll", "$db": "db"}`, false},
+		{"delete over quota", limited, 101, nil, `{"delete": "coll", "$db": "db"}`, true},
+		{"read over quota", limited, 101, nil, `{"find": "coll", "$db": "db"}`, true},
+		{"no quota", unlimited, 1 << 40, []string{"t1_a", "t1_b", "t1_c"}, `{"insert": "coll", "$db": "db"}`, true},
+		{"create under database limit", limited, 0, []string{"t1_a"}, `{"create": "coll", "$db": "db"}`, true},
+		{"create at database limit", limited, 0, []string{"t1_a", "t1_b"}, `{"create": "coll", "$db": "db"}`, false},
+		{
+			"createIndexes at database limit",
+			limited, 0, []string{"t1_a", "t1_b"}, `{"createIndexes": "coll", "$db": "db"}`, false,
+		},
+		{"create in existing database", limited, 0, []string{"t1_a", "t1_b"}, `{"create": "coll", "$db": "a"}`, true},
+		{"no database limit", unlimited, 0, []string{"t1_a", "t1_b"}, `{"create": "coll", "$db": "db"}`, true},
+		{"write at database limit", limited, 0, []string{"t1_a", "t1_b"}, `{"insert": "coll", "$db": "db"}`, true},
+		{"create over quota", limited, 101, nil, `{"create": "coll", "$db": "db"}`, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := &tenantState{tenant: tc.tenant}
+			databases := make(map[string]struct{}, len(tc.databases))
+			for _, db := range tc.databases {
+				databases[db] = struct{}{}
+			}
+			ts.usage.set(tc.bytes, databases)
+
+			request := testutil.Document(t, tc.request)
+			err := p.checkQuota(ts, request.Index(0).Key(), request)
+			if tc.allowed && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.allowed {
+				cerr, ok := err.(mongo.CommandError)
+				if !ok || cerr.Code != mongo.CodeQuotaExceeded {
+					t.Fatalf("expected QuotaExceeded error, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleTenantUsage(t *testing.T) {
+	p := &Proxy{}
+	request := mongowire.NewCommand(testutil.Document(t, `{"getTenantUsage": 1, "$db": "admin"}`))
+	limited := &tenant.Tenant{Name: "t1", Prefix: "t1", StorageQuota: 100, MaxDatabases: 2}
+
+	testCases := []struct {
+		name     string
+		usage    func(*storageUsage)
+		expected string
+	}{
+		{
+			"not computed",
+			func(*storageUsage) {},
+			`{"tenant": "t1", "storageSize": {"$numberLong": "0"}, "storageQuota": {"$numberLong": "100"}, ` +
+				`"databases": 0, "maxDatabases": 2, "ok": 1}`,
+		},
+		{
+			"computed",
+			func(su *storageUsage) {
+				su.bytes = 42
+				su.databases = map[string]struct{}{"t1_a": {}}
+				su.updated = time.Unix(1600000000, 0)
+			},
+			`{"tenant": "t1", "storageSize": {"$numberLong": "42"}, "storageQuota": {"$numberLong": "100"}, ` +
+				`"databases": 1, "maxDatabases": 2, "lastUpdated": {"$date": {"$numberLong": "1600000000000"}}, "ok": 1}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := &tenantState{tenant: limited}
+			tc.usage(&ts.usage)
+
+			reply, err := mongowire.Decode(p.handleTenantUsage(request, ts).Encode())
+			if err != nil {
+				t.Fatalf("error decoding reply: %v", err)
+			}
+			if expected := testutil.Document(t, tc.expected); !bytes.Equal(reply.CommandDocument(), expected) {
+				t.Fatalf("expected %s, got %s", expected, reply.CommandDocument())
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"github.com/divjotarora/proxy/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+var (
+	// retryableReadCommands contains the names of read commands that can be safely retried on another server.
+	retryableReadCommands = map[string]struct{}{
+		"aggregate":       {},
+		"count":           {},
+		"distinct":        {},
+		"find":            {},
+		"listCollections": {},
+		"listIndexes":     {},
+	}
+)
+
+// isRetryable returns true if req can be retried on a newly selected server after a transient error. Reads outside of
+// a transaction are retryable, as are retryable writes, which carry a txnNumber outside of a transaction. Because the
+// retry sends the same lsid and txnNumber, the server guarantees that a retryable write is only applied once.
+func isRetryable(req *proxiedRequest) bool {
+	if req.msg.MoreToCome() || req.cursor != nil {
+		return false
+	}
+	// Requests in a transaction that is pinned to a connection cannot be sent to a different server.
+	if req.txn != nil && req.txn.conn != nil {
+		return false
+	}
+
+	switch req.cmdName {
+	case "commitTransaction", "abortTransaction":
+		return true
+	}
+	// Any other request with autocommit is part of a transaction. Even reads cannot be retried on a different server,
+	// because the transaction's state only exists on the server that started it.
+	if _, err := req.fixedRequest.LookupErr("autocommit"); err == nil {
+		return false
+	}
+
+	if req.cmdName == "aggregate" {
+		return !hasWriteStage(req.fixedRequest)
+	}
+	if _, ok := retryableReadCommands[req.cmdName]; ok {
+		return true
+	}
+
+	_, hasTxnNumber := req.fixedRequest.Lookup("txnNumber").Int64OK()
+	return hasTxnNumber
+}
+
+// hasWriteStage returns true if the pipeline in an aggregate command ends with a $out or $merge stage.
+func hasWriteStage(cmd bsoncore.Document) bool {
+	pipeline, ok := cmd.Lookup("pipeline").ArrayOK()
+	if !ok {
+		return false
+	}
+	stages, err := pipeline.Values()
+	if err != nil || len(stages) == 0 {
+		return false
+	}
+
+	lastStage, ok := stages[len(stages)-1].DocumentOK()
+	if !ok {
+		return false
+	}
+	elem, err := lastStage.IndexErr(0)
+	if err != nil {
+		return false
+	}
+	key := elem.Key()
+	return key == "$out" || key == "$merge"
+}
+
+// backendError wraps an error that occurred while communicating with the server, before any reply was forwarded to the
+// client.
+type backendError struct {
+	err error
+}
+
+func (e backendError) Error() string {
+	return e.err.Error()
+}
+
+// retryableReplyError is returned when the server replies to a retryable request with a transient error.
+type retryableReplyError struct {
+	reply []byte
+}
+
+func (e retryableReplyError) Error() string {
+	return "server returned a retryable error"
+}
+
+// isRetryableError returns true if err is a network error or a transient error reply from the server.
+func isRetryableError(err error) bool {
+	switch e := err.(type) {
+	case retryableReplyError:
+		return true
+	case backendError:
+		return mongo.IsNetworkError(e.err)
+	default:
+		return false
+	}
+}
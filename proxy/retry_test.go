@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/mongo"
+	"github.com/divjotarora/proxy/mongo/mongowire"
+)
+
+func TestIsRetryable(t *testing.T) {
+	const lsid = `"lsid": {"id": {"$binary": {"base64": "AAAAAAAAAAAAAAAAAAAAAA==", "subType": "04"}}}`
+	unpinned := &transaction{}
+	pinned := &transaction{conn: &mongo.Conn{}}
+
+	testCases := []struct {
+		name       string
+		request    string
+		moreToCome bool
+		cursor     *cursorInfo
+		txn        *transaction
+		retryable  bool
+	}{
+		{name: "find", request: `{"find": "coll", "$db": "db"}`, retryable: true},
+		{name: "count", request: `{"count": "coll", "$db": "db"}`, retryable: true},
+		{name: "distinct", request: `{"distinct": "coll", "key": "a", "$db": "db"}`, retryable: true},
+		{name: "listCollections", request: `{"listCollections": 1, "$db": "db"}`, retryable: true},
+		{name: "listIndexes", request: `{"listIndexes": "coll", "$db": "db"}`, retryable: true},
+		{name: "listDatabases", request: `{"listDatabases": 1, "$db": "admin"}`, retryable: false},
+		{
+			name:      "aggregate",
+			request:   `{"aggregate": "coll", "pipeline": [{"$match": {}}], "$db": "db"}`,
+			retryable: true,
+		},
+		{name: "aggregate with $out", request: `{"aggregate": "coll", "pipeline": [{"$out": "c"}], "$db": "db"}`},
+		{
+			name:    "getMore",
+			request: `{"getMore": {"$numberLong": "1"}, "collection": "coll", "$db": "db"}`,
+			cursor:  &cursorInfo{},
+		},
+		{name: "insert without txnNumber", request: `{"insert": "coll", "$db": "db"}`},
+		{
+			name:      "retryable write",
+			request:   `{"insert": "coll", ` + lsid + `, "txnNumber": {"$numberLong": "1"}, "$db": "db"}`,
+			retryable: true,
+		},
+		{
+			name:       "unacknowledged write",
+			request:    `{"insert": "coll", ` + lsid + `, "txnNumber": {"$numberLong": "1"}, "$db": "db"}`,
+			moreToCome: true,
+		},
+		{
+			name: "read in transaction",
+			request: `{"find": "coll", ` + lsid + `, "txnNumber": {"$numberLong": "1"}, "autocommit": false,
+				"$db": "db"}`,
+			txn: unpinned,
+		},
+		{
+			name:      "commitTransaction",
+			request:   `{"commitTransaction": 1, ` + lsid + `, "txnNumber": {"$numberLong": "1"}, "autocommit": false}`,
+			txn:       unpinned,
+			retryable: true,
+		},
+		{
+			name:      "abortTransaction",
+			request:   `{"abortTransaction": 1, ` + lsid + `, "txnNumber": {"$numberLong": "1"}, "autocommit": false}`,
+			txn:       unpinned,
+			retryable: true,
+		},
+		{
+			name:    "commitTransaction on pinned connection",
+			request: `{"commitTransaction": 1, ` + lsid + `, "txnNumber": {"$numberLong": "1"}, "autocommit": false}`,
+			txn:     pinned,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := testutil.Document(t, tc.request)
+			msg := mongowire.NewCommand(request)
+			if tc.moreToCome {
+				msg = mongowire.NewUnacknowledgedCommand(request)
+			}
+			req := &proxiedRequest{
+				msg:          msg,
+				cmdName:      request.Index(0).Key(),
+				fixedRequest: request,
+				cursor:       tc.cursor,
+				txn:          tc.txn,
+			}
+			if got := isRetryable(req); got != tc.retryable {
+				t.Fatalf("expected %v, got %v", tc.retryable, got)
+			}
+		})
+	}
+}
+
+func TestHasWriteStage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cmd      string
+		expected bool
+	}{
+		{"$out", `{"aggregate": "coll", "pipeline": [{"$match": {}}, {"$out": "c"}]}`, true},
+		{"$merge", `{"aggregate": "coll", "pipeline": [{"$merge": {"into": "c"}}]}`, true},
+		{"read-only", `{"aggregate": "coll", "pipeline": [{"$match": {}}, {"$project": {"a": 1}}]}`, false},
+		{"write stage not last", `{"aggregate": "coll", "pipeline": [{"$out": "c"}, {"$match": {}}]}`, false},
+		{"empty pipeline", `{"aggregate": "coll", "pipeline": []}`, false},
+		{"no pipeline", `{"aggregate": "coll"}`, false},
+		{"pipeline not array", `{"aggregate": "coll", "pipeline": {"$out": "c"}}`, false},
+		{"stage not document", `{"aggregate": "coll", "pipeline": ["$out"]}`, false},
+		{"empty stage", `{"aggregate": "coll", "pipeline": [{}]}`, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hasWriteStage(testutil.Document(t, tc.cmd)); got != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/divjotarora/proxy/command"
+	"github.com/divjotarora/proxy/ratelimit"
+	"github.com/divjotarora/proxy/session"
+	"github.com/divjotarora/proxy/tenant"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// tenantState holds the state the proxy keeps for a single tenant. It is shared by all of the tenant's connections.
+type tenantState struct {
+	tenant   *tenant.Tenant
+	backend  *backend
+	parser   *command.Parser
+	sessions *session.Registry
+	txns     *transactionTable
+
+	readLimiter  *ratelimit.Limiter
+	writeLimiter *ratelimit.Limiter
+
+	usage storageUsage
+}
+
+func newTenantState(t *tenant.Tenant, b *backend) *tenantState {
+	sessions := session.NewRegistry()
+	parserOpts := command.ParserOptions{
+		Prefix:    t.DatabasePrefix(),
+		Sessions:  sessions,
+		FixDBRefs: t.FixDBRefs,
+	}
+
+	return &tenantState{
+		tenant:   t,
+		backend:  b,
+		parser:   command.NewParser(parserOpts),
+		sessions: sessions,
+		txns:     newTransactionTable(),
+
+		readLimiter:  ratelimit.NewLimiter(t.ReadLimits),
+		writeLimiter: ratelimit.NewLimiter(t.WriteLimits),
+	}
+}
+
+// getTenantState returns the state for the given tenant, creating it if this is the tenant's first connection. An
+// error is returned if the tenant's configuration is invalid.
+func (p *Proxy) getTenantState(t *tenant.Tenant) (*tenantState, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	p.tenantsMu.Lock()
+	ts, ok := p.tenants[t.Name]
+	p.tenantsMu.Unlock()
+	if ok {
+		return ts, nil
+	}
+
+	// Connecting to a new backend can take a while, so do it without holding tenantsMu.
+	b, err := p.getBackend(t.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.tenantsMu.Lock()
+	if ts, ok := p.tenants[t.Name]; ok {
+		p.tenantsMu.Unlock()
+		return ts, nil
+	}
+	ts = newTenantState(t, b)
+	p.tenants[t.Name] = ts
+	p.tenantsMu.Unlock()
+
+	// Compute the tenant's usage now so quotas are enforced before the first periodic accounting pass.
+	p.updateStorageUsageWithTimeout(ts)
+	return ts, nil
+}
+
+// endSessions ends the server sessions with the given IDs. Errors are logged rather than returned because this is
+// called during connection cleanup and the server will eventually time out the sessions anyway.
+func (p *Proxy) endSessions(ts *tenantState, serverIDs [][]byte) {
+	if len(serverIDs) == 0 {
+		return
+	}
+
+	idx, ids := bsoncore.AppendArrayStart(nil)
+	for i, id := range serverIDs {
+		lsid := bsoncore.BuildDocumentFromElements(nil, bsoncore.AppendBinaryElement(nil, "id", 0x04, id))
+		ids = bsoncore.AppendDocumentElement(ids, strconv.Itoa(i), lsid)
+	}
+	ids, _ = bsoncore.AppendArrayEnd(ids, idx)
+
+	cmd := bsoncore.BuildDocumentFromElements(nil,
+		bsoncore.AppendArrayElement(nil, "endSessions", ids),
+		bsoncore.AppendStringElement(nil, "$db", "admin"),
+	)
+	if _, err := ts.backend.client.RunCommand(context.TODO(), cmd); err != nil {
+		log.Printf("error ending %d sessions: %v\n", len(serverIDs), err)
+	}
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+	"github.com/divjotarora/proxy/tenant"
+)
+
+// TestDefaultTenantMapping pins the server database names used by the default tenant. Changing them hides existing
+// data from clients, so any change needs a documented migration (see the README).
+func TestDefaultTenantMapping(t *testing.T) {
+	ts := newTenantState(tenant.Default, nil)
+
+	testCases := []struct {
+		db       string
+		expected string
+	}{
+		{"database", "fixed_database"},
+		{"admin", "fixed_admin"},
+		{"fixed", "fixed_fixed"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.db, func(t *testing.T) {
+			request := testutil.Document(t, `{"find": "coll", "$db": "`+tc.db+`"}`)
+			fixed, err := ts.parser.Parse("find").FixRequest(request)
+			if err != nil {
+				t.Fatalf("error fixing request: %v", err)
+			}
+			if got := fixed.Lookup("$db").StringValue(); got != tc.expected {
+				t.Fatalf("expected database %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
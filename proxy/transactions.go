@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/divjotarora/proxy/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/description"
+)
+
+// transaction represents a multi-document transaction that is in progress on a session.
+type transaction struct {
+	mu              sync.Mutex // serializes use of conn
+	serverSessionID []byte
+	txnNumber       int64
+	conn            *mongo.Conn // pinned connection, or nil if the transaction is not pinned
+}
+
+// transactionTable tracks the in-progress transactions for a tenant. This type is safe for concurrent use.
+type transactionTable struct {
+	mu   sync.Mutex
+	txns map[string]*transaction // server session ID -> transaction
+}
+
+func newTransactionTable() *transactionTable {
+	return &transactionTable{
+		txns: make(map[string]*transaction),
+	}
+}
+
+// get returns the transaction for the given session if its transaction number matches.
+func (tt *transactionTable) get(serverSessionID []byte, txnNumber int64) *transaction {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	txn, ok := tt.txns[string(serverSessionID)]
+	if !ok || txn.txnNumber != txnNumber {
+		return nil
+	}
+	return txn
+}
+
+// put stores txn as the current transaction for its session and returns the transaction it replaced, if any.
+func (tt *transactionTable) put(txn *transaction) *transaction {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	old := tt.txns[string(txn.serverSessionID)]
+	tt.txns[string(txn.serverSessionID)] = txn
+	return old
+}
+
+// remove removes and returns the current transaction for the given session, if any.
+func (tt *transactionTable) remove(serverSessionID []byte) *transaction {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	txn, ok := tt.txns[string(serverSessionID)]
+	if !ok {
+		return nil
+	}
+	delete(tt.txns, string(serverSessionID))
+	return txn
+}
+
+// release returns the transaction's pinned connection, if any, to the pool.
+func (txn *transaction) release() {
+	if txn == nil {
+		return
+	}
+
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.conn != nil {
+		_ = txn.conn.Close()
+		txn.conn = nil
+	}
+}
+
+// trackTransaction updates the transaction state for the session used by a request and returns the transaction the
+// request belongs to, or nil if it is not part of a tracked transaction. The request must already have been fixed so
+// the lsid value is the server session ID.
+func (p *Proxy) trackTransaction(ts *tenantState, fixedRequest bsoncore.Document) (*transaction, error) {
+	_, serverSessionID, ok := fixedRequest.Lookup("lsid", "id").BinaryOK()
+	if !ok {
+		return nil, nil
+	}
+	txnNumber, hasTxnNumber := fixedRequest.Lookup("txnNumber").Int64OK()
+	_, hasAutocommit := fixedRequest.Lookup("autocommit").BooleanOK()
+
+	// A request that uses the session outside of a transaction ends any transaction that was previously pinned.
+	if !hasTxnNumber || !hasAutocommit {
+		ts.txns.remove(serverSessionID).release()
+		return nil, nil
+	}
+
+	if startTxn, _ := fixedRequest.Lookup("startTransaction").BooleanOK(); !startTxn {
+		return ts.txns.get(serverSessionID, txnNumber), nil
+	}
+
+	// Starting a new transaction replaces the previous one for the session. Transactions on sharded clusters must be
+	// pinned to a single mongos for their entire duration.
+	txn := &transaction{
+		serverSessionID: serverSessionID,
+		txnNumber:       txnNumber,
+	}
+	if ts.backend.client.Kind() == description.Sharded {
+		conn, err := ts.backend.client.Checkout(context.TODO(), nil)
+		if err != nil {
+			return nil, err
+		}
+		txn.conn = conn
+	}
+	ts.txns.put(txn).release()
+	return txn, nil
+}
+
+// transactionFinished reports whether a reply to commitTransaction or abortTransaction means the transaction is over.
+// The transaction is kept if the reply has a write concern error, a retryable error, or an error with the
+// UnknownTransactionCommitResult or TransientTransactionError label, because the client can then retry the command,
+// and the retry must use the same pinned connection.
+func transactionFinished(reply bsoncore.Document) bool {
+	if _, err := reply.LookupErr("writeConcernError"); err == nil {
+		return false
+	}
+
+	cerr, ok := mongo.ReplyDocumentError(reply).(mongo.CommandError)
+	if !ok {
+		return true
+	}
+	return !cerr.Retryable() && !cerr.HasLabel(mongo.UnknownTransactionCommitResultLabel) &&
+		!cerr.HasLabel(mongo.TransientTransactionErrorLabel)
+}
+
+// finishTransaction stops tracking txn after the client has committed or aborted it.
+func (p *Proxy) finishTransaction(ts *tenantState, txn *transaction) {
+	if current := ts.txns.get(txn.serverSessionID, txn.txnNumber); current == txn {
+		ts.txns.remove(txn.serverSessionID).release()
+	}
+}
+
+// abortTransactions aborts any in-progress transactions on the given sessions. This is called when no client
+// connections are using the sessions anymore, so the transactions can never be committed. Errors are logged rather
+// than returned because this is called during connection cleanup.
+func (p *Proxy) abortTransactions(ts *tenantState, serverSessionIDs [][]byte) {
+	for _, serverSessionID := range serverSessionIDs {
+		txn := ts.txns.remove(serverSessionID)
+		if txn == nil {
+			continue
+		}
+
+		cmd := bsoncore.BuildDocumentFromElements(nil,
+			bsoncore.AppendInt32Element(nil, "abortTransaction", 1),
+			bsoncore.AppendDocumentElement(nil, "lsid", bsoncore.BuildDocumentFromElements(nil,
+				bsoncore.AppendBinaryElement(nil, "id", 0x04, serverSessionID),
+			)),
+			bsoncore.AppendInt64Element(nil, "txnNumber", txn.txnNumber),
+			bsoncore.AppendBooleanElement(nil, "autocommit", false),
+			bsoncore.AppendStringElement(nil, "$db", "admin"),
+		)
+
+		var err error
+		txn.mu.Lock()
+		if txn.conn != nil {
+			_, err = txn.conn.RunCommand(context.TODO(), cmd)
+		} else {
+			_, err = ts.backend.client.RunCommand(context.TODO(), cmd)
+		}
+		txn.mu.Unlock()
+		txn.release()
+
+		if err != nil {
+			log.Printf("error aborting transaction %d: %v\n", txn.txnNumber, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/divjotarora/proxy/internal/testutil"
+)
+
+func TestTransactionFinished(t *testing.T) {
+	testCases := []struct {
+		name     string
+		reply    string
+		finished bool
+	}{
+		{"ok", `{"ok": 1}`, true},
+		{
+			"write concern error",
+			`{"ok": 1, "writeConcernError": {"code": 64, "errmsg": "waiting for replication timed out"}}`,
+			false,
+		},
+		{"non-transient error", `{"ok": 0, "code": 251, "codeName": "NoSuchTransaction"}`, true},
+		{
+			"unknown commit result",
+			`{"ok": 0, "code": 50, "codeName": "MaxTimeMSExpired", "errorLabels": ["UnknownTransactionCommitResult"]}`,
+			false,
+		},
+		{
+			"transient error",
+			`{"ok": 0, "code": 112, "codeName": "WriteConflict", "errorLabels": ["TransientTransactionError"]}`,
+			false,
+		},
+		{"retryable error", `{"ok": 0, "code": 10107, "codeName": "NotMaster"}`, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := transactionFinished(testutil.Document(t, tc.reply)); got != tc.finished {
+				t.Fatalf("expected %v, got %v", tc.finished, got)
+			}
+		})
+	}
+}
+
+func TestTrackTransaction(t *testing.T) {
+	// The tracked transaction's server session ID is 16 zero bytes.
+	const sessionID = `{"$binary": {"base64": "AAAAAAAAAAAAAAAAAAAAAA==", "subType": "04"}}`
+	p := &Proxy{}
+
+	testCases := []struct {
+		name    string
+		request string
+		tracked bool // whether the request belongs to the tracked transaction
+		kept    bool // whether the transaction is still tracked afterwards
+	}{
+		{
+			"same transaction",
+			`{"find": "coll", "lsid": {"id": ` + sessionID + `}, "txnNumber": {"$numberLong": "1"}, "autocommit": false}`,
+			true,
+			true,
+		},
+		{
+			"other transaction number",
+			`{"find": "coll", "lsid": {"id": ` + sessionID + `}, "txnNumber": {"$numberLong": "2"}, "autocommit": false}`,
+			false,
+			true,
+		},
+		{
+			"retryable write on session",
+			`{"insert": "coll", "lsid": {"id": ` + sessionID + `}, "txnNumber": {"$numberLong": "2"}}`,
+			false,
+			false,
+		},
+		{"session without transaction", `{"find": "coll", "lsid": {"id": ` + sessionID + `}}`, false, false},
+		{"no session", `{"find": "coll"}`, false, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := &tenantState{txns: newTransactionTable()}
+			request := testutil.Document(t, tc.request)
+			txn := &transaction{serverSessionID: make([]byte, 16), txnNumber: 1}
+			ts.txns.put(txn)
+
+			got, err := p.trackTransaction(ts, request)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tracked := got == txn; tracked != tc.tracked {
+				t.Fatalf("expected request tracked to be %v, got %v", tc.tracked, tracked)
+			}
+			if kept := ts.txns.get(txn.serverSessionID, txn.txnNumber) == txn; kept != tc.kept {
+				t.Fatalf("expected transaction kept to be %v, got %v", tc.kept, kept)
+			}
+		})
+	}
+}
+
+func TestFinishTransaction(t *testing.T) {
+	p := &Proxy{}
+	ts := &tenantState{txns: newTransactionTable()}
+	serverSessionID := []byte("session")
+
+	// Finishing a transaction that has been replaced by a newer one on the same session keeps the newer one.
+	old := &transaction{serverSessionID: serverSessionID, txnNumber: 1}
+	current := &transaction{serverSessionID: serverSessionID, txnNumber: 2}
+	ts.txns.put(old)
+	ts.txns.put(current)
+	p.finishTransaction(ts, old)
+	if ts.txns.get(serverSessionID, 2) != current {
+		t.Fatal("expected newer transaction to still be tracked")
+	}
+
+	p.finishTransaction(ts, current)
+	if ts.txns.get(serverSessionID, 2) != nil {
+		t.Fatal("expected finished transaction not to be tracked")
+	}
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLimitExceeded is returned by Limiter.Acquire when an operation could not be admitted before its context
+	// expired.
+	ErrLimitExceeded = errors.New("rate limit exceeded")
+)
+
+// Limits configures a Limiter.
+type Limits struct {
+	// OpsPerSecond is the sustained number of operations per second that are admitted. If zero, the rate is not
+	// limited.
+	OpsPerSecond float64
+	// Burst is the number of operations that can be admitted at once when no operations have been run recently. If
+	// less than one, one is used.
+	Burst int
+	// MaxInFlight is the maximum number of admitted operations that can be in progress at once. If zero, concurrency
+	// is not limited.
+	MaxInFlight int
+}
+
+// Limiter limits the rate and concurrency of operations using a token bucket and a semaphore. This type is safe for
+// concurrent use.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	inFlight chan struct{} // nil if concurrency is not limited
+}
+
+// NewLimiter creates a new Limiter instance. The token bucket starts out full.
+func NewLimiter(limits Limits) *Limiter {
+	burst := float64(limits.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	l := &Limiter{
+		rate:   limits.OpsPerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+	if limits.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, limits.MaxInFlight)
+	}
+	return l
+}
+
+// Acquire blocks until an operation can be admitted. If the operation cannot be admitted before ctx expires,
+// ErrLimitExceeded is returned. If Acquire returns nil, Release must be called when the operation is complete.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if err := l.waitForToken(ctx); err != nil {
+		return err
+	}
+
+	if l.inFlight == nil {
+		return nil
+	}
+
+	// Try to admit the operation without waiting first so an expired context does not cause an operation to be
+	// rejected when there is a free slot.
+	select {
+	case l.inFlight <- struct{}{}:
+		return nil
+	default:
+	}
+	select {
+	case l.inFlight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		// The operation was not admitted, so it should not count against the rate.
+		l.refundToken()
+		return ErrLimitExceeded
+	}
+}
+
+// Release marks an operation admitted by Acquire as complete.
+func (l *Limiter) Release() {
+	if l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// waitForToken takes a token from the bucket, waiting for one to become available if necessary.
+func (l *Limiter) waitForToken(ctx context.Context) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.refill(now)
+
+	// Reserve a token even if the bucket is empty. A negative balance is how long the caller has to wait.
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	if deadline, ok := ctx.Deadline(); ok && wait > 0 && now.Add(wait).After(deadline) {
+		l.tokens++
+		l.mu.Unlock()
+		return ErrLimitExceeded
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give the reserved token back so it can be used by another operation.
+		l.refundToken()
+		return ErrLimitExceeded
+	}
+}
+
+// refundToken returns a token taken by waitForToken to the bucket.
+func (l *Limiter) refundToken() {
+	if l.rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// refill adds the tokens that have accumulated since the last refill. This must be called with l.mu held.
+func (l *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+
+	l.tokens += elapsed * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	const short = 20 * time.Millisecond
+
+	acquire := func(err error) step {
+		return step{timeout: short, err: err}
+	}
+	release := step{release: true}
+
+	testCases := []struct {
+		name   string
+		limits Limits
+		steps  []step
+	}{
+		{
+			name:   "no limits",
+			limits: Limits{},
+			steps:  []step{acquire(nil), acquire(nil), acquire(nil), acquire(nil)},
+		},
+		{
+			name:   "burst",
+			limits: Limits{OpsPerSecond: 1, Burst: 3},
+			steps:  []step{acquire(nil), acquire(nil), acquire(nil), acquire(ErrLimitExceeded)},
+		},
+		{
+			name:   "burst less than one",
+			limits: Limits{OpsPerSecond: 1, Burst: -1},
+			steps:  []step{acquire(nil), acquire(ErrLimitExceeded)},
+		},
+		{
+			name:   "wait for token",
+			limits: Limits{OpsPerSecond: 100, Burst: 1},
+			steps:  []step{acquire(nil), {timeout: time.Second}},
+		},
+		{
+			name:   "token not refilled before deadline",
+			limits: Limits{OpsPerSecond: 1, Burst: 1},
+			steps:  []step{acquire(nil), acquire(ErrLimitExceeded), acquire(ErrLimitExceeded)},
+		},
+		{
+			name:   "max in flight",
+			limits: Limits{MaxInFlight: 2},
+			steps:  []step{acquire(nil), acquire(nil), acquire(ErrLimitExceeded), release, acquire(nil)},
+		},
+		{
+			name:   "release does not refill tokens",
+			limits: Limits{OpsPerSecond: 1, Burst: 1, MaxInFlight: 1},
+			steps:  []step{acquire(nil), release, acquire(ErrLimitExceeded)},
+		},
+		{
+			// A token taken by an operation that then times out waiting for a free slot is returned to the bucket.
+			name:   "token refunded when in-flight wait times out",
+			limits: Limits{OpsPerSecond: 1, Burst: 2, MaxInFlight: 1},
+			steps:  []step{acquire(nil), acquire(ErrLimitExceeded), release, acquire(nil)},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := NewLimiter(tc.limits)
+			for i, s := range tc.steps {
+				if s.release {
+					l.Release()
+					continue
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+				err := l.Acquire(ctx)
+				cancel()
+				if err != s.err {
+					t.Fatalf("step %d: expected error %v, got %v", i, s.err, err)
+				}
+			}
+		})
+	}
+}
+
+// step is an action in a Limiter test: either Release or Acquire with a timeout and an expected error.
+type step struct {
+	release bool
+	timeout time.Duration
+	err     error
+}
@@ -0,0 +1,171 @@
+package session
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/divjotarora/proxy/command"
+)
+
+// Registry maps the logical session IDs used by a tenant's clients to the session IDs sent to the server. Server
+// session IDs are generated by the proxy so two tenants that happen to use the same session ID do not share a session
+// on the server. The registry also tracks which client connections have used each session so sessions can be ended
+// once no connections refer to them. This type is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	sessions map[string]*entry // client session ID -> session
+	toClient map[string]string // server session ID -> client session ID
+	conns    map[uint64]map[string]struct{}
+}
+
+type entry struct {
+	serverID []byte
+	conns    map[uint64]struct{}
+}
+
+var _ command.SessionMapper = (*Registry)(nil)
+
+// NewRegistry creates a new Registry instance.
+func NewRegistry() *Registry {
+	return &Registry{
+		sessions: make(map[string]*entry),
+		toClient: make(map[string]string),
+		conns:    make(map[uint64]map[string]struct{}),
+	}
+}
+
+// ServerID returns the server session ID for the given client session ID, generating a new one if necessary.
+func (r *Registry) ServerID(clientID []byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.getOrCreate(string(clientID)).serverID
+}
+
+// ClientID returns the client session ID for the given server session ID. Server session IDs that are not in the
+// registry are returned unchanged.
+func (r *Registry) ClientID(serverID []byte) []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if clientID, ok := r.toClient[string(serverID)]; ok {
+		return []byte(clientID)
+	}
+	return serverID
+}
+
+// Owns reports whether the given server session ID belongs to a session in the registry.
+func (r *Registry) Owns(serverID []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.toClient[string(serverID)]
+	return ok
+}
+
+// Attach records that the connection with the given ID used the given client session ID.
+func (r *Registry) Attach(connID uint64, clientID []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attach(connID, string(clientID))
+}
+
+// AttachStarted registers a server session ID that was not generated by the proxy, such as one returned by a
+// startSession command, as its own client session ID and records that the connection with the given ID used it. Like
+// other sessions, it is ended once no connections use it or forgotten once the client ends it.
+func (r *Registry) AttachStarted(connID uint64, serverID []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.toClient[string(serverID)]; !ok {
+		r.add(string(serverID), serverID)
+	}
+	r.attach(connID, r.toClient[string(serverID)])
+}
+
+// Detach removes all references to the connection with the given ID. Sessions that are no longer used by any
+// connection are removed from the registry and their server session IDs are returned so they can be ended.
+func (r *Registry) Detach(connID uint64) [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ended [][]byte
+	for clientID := range r.conns[connID] {
+		e, ok := r.sessions[clientID]
+		if !ok {
+			continue
+		}
+
+		delete(e.conns, connID)
+		if len(e.conns) == 0 {
+			r.remove(clientID, e)
+			ended = append(ended, e.serverID)
+		}
+	}
+	delete(r.conns, connID)
+	return ended
+}
+
+// Forget removes the session with the given client session ID from the registry. This should be called after the
+// session has been ended on the server by the client.
+func (r *Registry) Forget(clientID []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.sessions[string(clientID)]; ok {
+		r.remove(string(clientID), e)
+	}
+}
+
+// attach must be called with r.mu held.
+func (r *Registry) attach(connID uint64, clientID string) {
+	e := r.getOrCreate(clientID)
+	e.conns[connID] = struct{}{}
+
+	connSessions, ok := r.conns[connID]
+	if !ok {
+		connSessions = make(map[string]struct{})
+		r.conns[connID] = connSessions
+	}
+	connSessions[clientID] = struct{}{}
+}
+
+// getOrCreate must be called with r.mu held.
+func (r *Registry) getOrCreate(clientID string) *entry {
+	if e, ok := r.sessions[clientID]; ok {
+		return e
+	}
+	return r.add(clientID, newSessionID())
+}
+
+// add must be called with r.mu held.
+func (r *Registry) add(clientID string, serverID []byte) *entry {
+	e := &entry{
+		serverID: serverID,
+		conns:    make(map[uint64]struct{}),
+	}
+	r.sessions[clientID] = e
+	r.toClient[string(serverID)] = clientID
+	return e
+}
+
+// remove must be called with r.mu held.
+func (r *Registry) remove(clientID string, e *entry) {
+	delete(r.sessions, clientID)
+	delete(r.toClient, string(e.serverID))
+	for connID := range e.conns {
+		delete(r.conns[connID], clientID)
+	}
+}
+
+// newSessionID generates a random version 4 UUID.
+func newSessionID() []byte {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err) // crypto/rand only fails if the system's secure random number generator is unavailable.
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id
+}
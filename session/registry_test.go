@@ -0,0 +1,161 @@
+package session
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+func TestRegistryIDs(t *testing.T) {
+	r := NewRegistry()
+	clientA := []byte("client-session-a")
+	clientB := []byte("client-session-b")
+
+	serverA := r.ServerID(clientA)
+	if len(serverA) != 16 {
+		t.Fatalf("expected 16-byte server session ID, got %d bytes", len(serverA))
+	}
+	if serverA[6]>>4 != 4 || serverA[8]>>6 != 2 {
+		t.Fatalf("expected version 4 UUID, got %x", serverA)
+	}
+	if bytes.Equal(serverA, clientA) {
+		t.Fatal("expected server session ID to differ from client session ID")
+	}
+	if got := r.ServerID(clientA); !bytes.Equal(got, serverA) {
+		t.Fatalf("expected stable server session ID %x, got %x", serverA, got)
+	}
+	if serverB := r.ServerID(clientB); bytes.Equal(serverA, serverB) {
+		t.Fatal("expected different client sessions to get different server sessions")
+	}
+
+	if got := r.ClientID(serverA); !bytes.Equal(got, clientA) {
+		t.Fatalf("expected client session ID %q, got %q", clientA, got)
+	}
+	if !r.Owns(serverA) {
+		t.Fatal("expected registry to own generated server session ID")
+	}
+
+	// Server session IDs that were not generated by the proxy, such as those returned by startSession, map to
+	// themselves but are only owned once they are attached.
+	started := []byte("started-session")
+	if got := r.ClientID(started); !bytes.Equal(got, started) {
+		t.Fatalf("expected unknown server session ID to map to itself, got %q", got)
+	}
+	if r.Owns(started) {
+		t.Fatal("expected registry not to own unknown server session ID")
+	}
+	r.AttachStarted(1, started)
+	if !r.Owns(started) {
+		t.Fatal("expected registry to own server session ID after AttachStarted")
+	}
+	if got := r.ServerID(started); !bytes.Equal(got, started) {
+		t.Fatalf("expected server session ID %q, got %q", started, got)
+	}
+	if ended := r.Detach(1); len(ended) != 1 || !bytes.Equal(ended[0], started) {
+		t.Fatalf("expected started session to be ended when its connection is detached, got %q", ended)
+	}
+	if r.Owns(started) {
+		t.Fatal("expected ended session to be removed from the registry")
+	}
+}
+
+func TestRegistryDetach(t *testing.T) {
+	type attachment struct {
+		connID   uint64
+		clientID string
+	}
+
+	testCases := []struct {
+		name     string
+		attached []attachment
+		forget   []string
+		detach   []uint64 // detached in order; the sessions ended by the last one are checked
+		ended    []string // client session IDs
+	}{
+		{
+			name:   "no sessions",
+			detach: []uint64{1},
+		},
+		{
+			name:     "single connection",
+			attached: []attachment{{1, "a"}, {1, "b"}},
+			detach:   []uint64{1},
+			ended:    []string{"a", "b"},
+		},
+		{
+			name:     "other connection",
+			attached: []attachment{{1, "a"}},
+			detach:   []uint64{2},
+		},
+		{
+			name:     "session still used by another connection",
+			attached: []attachment{{1, "a"}, {2, "a"}, {1, "b"}},
+			detach:   []uint64{1},
+			ended:    []string{"b"},
+		},
+		{
+			name:     "last connection using session",
+			attached: []attachment{{1, "a"}, {2, "a"}},
+			detach:   []uint64{1, 2},
+			ended:    []string{"a"},
+		},
+		{
+			name:     "attached twice",
+			attached: []attachment{{1, "a"}, {1, "a"}},
+			detach:   []uint64{1},
+			ended:    []string{"a"},
+		},
+		{
+			name:     "forgotten session",
+			attached: []attachment{{1, "a"}, {1, "b"}},
+			forget:   []string{"a"},
+			detach:   []uint64{1},
+			ended:    []string{"b"},
+		},
+		{
+			name:     "detached twice",
+			attached: []attachment{{1, "a"}},
+			detach:   []uint64{1, 1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegistry()
+			for _, a := range tc.attached {
+				r.Attach(a.connID, []byte(a.clientID))
+			}
+			for _, clientID := range tc.forget {
+				r.Forget([]byte(clientID))
+			}
+
+			// Record the server session IDs before detaching because ended sessions are removed from the registry.
+			expected := make([]string, 0, len(tc.ended))
+			for _, clientID := range tc.ended {
+				expected = append(expected, string(r.ServerID([]byte(clientID))))
+			}
+
+			var ended [][]byte
+			for _, connID := range tc.detach {
+				ended = r.Detach(connID)
+			}
+			got := make([]string, 0, len(ended))
+			for _, serverID := range ended {
+				got = append(got, string(serverID))
+				if r.Owns(serverID) {
+					t.Fatalf("expected ended session %x to be removed from the registry", serverID)
+				}
+			}
+
+			sort.Strings(got)
+			sort.Strings(expected)
+			if len(got) != len(expected) {
+				t.Fatalf("expected %d ended sessions, got %d", len(expected), len(got))
+			}
+			for i := range got {
+				if got[i] != expected[i] {
+					t.Fatalf("ended sessions mismatch; got %x, want %x", got, expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/divjotarora/proxy/ratelimit"
+)
+
+// DefaultPrefix is the database name prefix used by the default tenant.
+const DefaultPrefix = "fixed"
+
+// DatabaseDelimiter separates a tenant's prefix from the names of its databases on the server. Prefixes cannot contain
+// the delimiter, so no tenant's databases can start with another tenant's prefix and delimiter, even if one prefix
+// starts with the other (e.g. "t1" and "t11").
+const DatabaseDelimiter = "_"
+
+// Default is the tenant used when no other tenant can be determined for a connection.
+var Default = &Tenant{
+	Name:   "default",
+	Prefix: DefaultPrefix,
+}
+
+// Tenant represents a customer of the proxy. Every database owned by a tenant is stored on the server with the
+// tenant's prefix and DatabaseDelimiter prepended to its name so tenants sharing a server cannot see each other's data.
+type Tenant struct {
+	Name string
+	// Prefix identifies the tenant's databases on the server. It must not be empty or contain DatabaseDelimiter.
+	Prefix string
+	// URI is the connection string for the MongoDB deployment that stores the tenant's data. If empty, the proxy's
+	// default deployment is used.
+	URI string
+	// Role determines which commands the tenant can run under the proxy's policy. If empty, policy.RoleTenant is
+	// used.
+	Role string
+	// FixDBRefs enables prefixing the $db values of DBRefs in documents written by the tenant and removing the prefix
+	// in documents read by the tenant. This requires walking every document, so it should only be enabled for tenants
+	// that use DBRefs across databases.
+	FixDBRefs bool
+
+	// ReadLimits and WriteLimits limit the rate and concurrency of the tenant's read and write operations. The zero
+	// value does not impose any limits.
+	ReadLimits  ratelimit.Limits
+	WriteLimits ratelimit.Limits
+	// LimitWait is how long an operation that exceeds the tenant's limits is queued before it is rejected. If zero,
+	// operations that exceed the limits are rejected immediately.
+	LimitWait time.Duration
+
+	// StorageQuota is the maximum number of bytes of storage, including indexes, that the tenant's databases can use
+	// before writes are rejected. If zero, storage is not limited.
+	StorageQuota int64
+	// MaxDatabases is the maximum number of databases the tenant can have. If zero, the number of databases is not
+	// limited.
+	MaxDatabases int
+}
+
+// Validate returns an error if the tenant's configuration cannot be used by the proxy.
+func (t *Tenant) Validate() error {
+	if t.Prefix == "" {
+		return errors.New("tenant prefix cannot be empty")
+	}
+	if strings.Contains(t.Prefix, DatabaseDelimiter) {
+		return fmt.Errorf("tenant prefix %q cannot contain %q", t.Prefix, DatabaseDelimiter)
+	}
+	return nil
+}
+
+// DatabasePrefix returns the string that is prepended to the names of the tenant's databases on the server. All
+// checks for whether a database belongs to the tenant must use this rather than Prefix.
+func (t *Tenant) DatabasePrefix() string {
+	return t.Prefix + DatabaseDelimiter
+}
+
+// Resolver is implemented by types that can determine the tenant for a new client connection.
+type Resolver interface {
+	Resolve(nc net.Conn) (*Tenant, error)
+}
+
+// ResolverFunc is a standalone function implementation of Resolver.
+type ResolverFunc func(net.Conn) (*Tenant, error)
+
+// Resolve implements Resolver.
+func (rf ResolverFunc) Resolve(nc net.Conn) (*Tenant, error) {
+	return rf(nc)
+}
+
+// StaticResolver returns a Resolver that resolves every connection to the provided tenant.
+func StaticResolver(t *Tenant) Resolver {
+	return ResolverFunc(func(net.Conn) (*Tenant, error) {
+		return t, nil
+	})
+}
@@ -0,0 +1,53 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		prefix         string
+		errMsg         string
+		databasePrefix string
+	}{
+		{"valid", "t1", "", "t1_"},
+		{"default", DefaultPrefix, "", "fixed_"},
+		{"empty", "", "tenant prefix cannot be empty", ""},
+		{"contains delimiter", "t1_a", `tenant prefix "t1_a" cannot contain "_"`, ""},
+		{"ends with delimiter", "t1_", `tenant prefix "t1_" cannot contain "_"`, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tenant := &Tenant{Name: tc.name, Prefix: tc.prefix}
+			err := tenant.Validate()
+			switch {
+			case tc.errMsg == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.errMsg != "" && (err == nil || err.Error() != tc.errMsg):
+				t.Fatalf("expected error %q, got %v", tc.errMsg, err)
+			}
+
+			if tc.databasePrefix != "" && tenant.DatabasePrefix() != tc.databasePrefix {
+				t.Fatalf("expected database prefix %q, got %q", tc.databasePrefix, tenant.DatabasePrefix())
+			}
+		})
+	}
+}
+
+// TestDatabasePrefixOverlap checks that valid prefixes where one starts with the other cannot claim each other's
+// databases.
+func TestDatabasePrefixOverlap(t *testing.T) {
+	t1 := &Tenant{Prefix: "t1"}
+	t11 := &Tenant{Prefix: "t11"}
+
+	for _, db := range []string{"db", "1_db", "_db", ""} {
+		if name := t11.DatabasePrefix() + db; strings.HasPrefix(name, t1.DatabasePrefix()) {
+			t.Fatalf("database %q of tenant t11 has tenant t1's prefix", name)
+		}
+		if name := t1.DatabasePrefix() + db; strings.HasPrefix(name, t11.DatabasePrefix()) {
+			t.Fatalf("database %q of tenant t1 has tenant t11's prefix", name)
+		}
+	}
+}